@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CompareAndSwap sets the key to newValue only if its current value is equal to oldValue, and
+// reports whether the value was swapped. An empty oldValue means the key is only set if it
+// doesn't exist, a deleted or expired key doesn't exist. The check and the write happen under
+// the write lock, so no other write can happen in between.
+func (e *Engine) CompareAndSwap(key, oldValue, newValue string) (bool, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return false, err
+	}
+	if err := e.validateValue(newValue); err != nil {
+		return false, err
+	}
+
+	start, now := time.Now(), e.clock.Now()
+	if err := e.lockWrites(); err != nil {
+		return false, err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return false, ErrEngineClosed
+	}
+
+	current, err := e.readLatestValue(key, now)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		if oldValue != "" {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	case oldValue == "" || current != oldValue:
+		return false, nil
+	}
+
+	rec := record{key: key, value: newValue}
+	if err := e.appendRecord(rec); err != nil {
+		return false, err
+	}
+	e.observeWrite(start, rec)
+	return true, nil
+}
+
+// PutIfAbsent sets the key to value only if it has no live value, and reports whether the value was
+// written. A deleted or expired key is absent, so it can be set again. Unlike CompareAndSwap with an
+// empty old value, the value of the existing key is never read, as the in-memory index is enough to
+// tell whether the key exists. The check and the write happen under the write lock.
+func (e *Engine) PutIfAbsent(key, value string) (bool, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return false, err
+	}
+	if err := e.validateValue(value); err != nil {
+		return false, err
+	}
+
+	start, now := time.Now(), e.clock.Now()
+	if err := e.lockWrites(); err != nil {
+		return false, err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return false, ErrEngineClosed
+	}
+
+	if entry, ok := e.latestEntry(key); ok && entry.live(now) {
+		return false, nil
+	}
+	rec := record{key: key, value: value}
+	if err := e.appendRecord(rec); err != nil {
+		return false, err
+	}
+	e.observeWrite(start, rec)
+	return true, nil
+}
+
+// Incr adds delta to the integer value of the key and returns the new value. The value is stored as
+// a base-10 integer, a missing, deleted, expired or empty key counts as zero. The read and the write
+// happen under the write lock, so concurrent increments never get lost.
+func (e *Engine) Incr(key string, delta int64) (int64, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return 0, err
+	}
+
+	start, now := time.Now(), e.clock.Now()
+	if err := e.lockWrites(); err != nil {
+		return 0, err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+
+	current, err := e.readLatestValue(key, now)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return 0, err
+	}
+
+	var number int64
+	if current != "" {
+		number, err = strconv.ParseInt(current, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of %s is not an integer: %w", key, err)
+		}
+	}
+	number += delta
+
+	rec := record{key: key, value: strconv.FormatInt(number, 10)}
+	if err := e.appendRecord(rec); err != nil {
+		return 0, err
+	}
+	e.observeWrite(start, rec)
+	return number, nil
+}
+
+// Decr subtracts delta from the integer value of the key and returns the new value, see Incr.
+func (e *Engine) Decr(key string, delta int64) (int64, error) {
+	return e.Incr(key, -delta)
+}
+
+// Append concatenates suffix to the value of the key and returns the new value, a missing, deleted
+// or expired key counts as empty. The read and the write happen under the write lock, so concurrent
+// appends never get lost. Records are never modified in place, so every append still writes the
+// whole new value, it only saves the caller from the race of reading and putting the value back.
+func (e *Engine) Append(key, suffix string) (string, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return "", err
+	}
+
+	start, now := time.Now(), e.clock.Now()
+	if err := e.lockWrites(); err != nil {
+		return "", err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return "", ErrEngineClosed
+	}
+
+	current, err := e.readLatestValue(key, now)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
+
+	value := current + suffix
+	if err := e.validateValue(value); err != nil {
+		return "", err
+	}
+	rec := record{key: key, value: value}
+	if err := e.appendRecord(rec); err != nil {
+		return "", err
+	}
+	e.observeWrite(start, rec)
+	return value, nil
+}
+
+// PutAndGetPrevious sets the key to value like Put and returns the value the key had before, existed
+// is false if the key was missing, deleted or expired. The read and the write happen under the write
+// lock, so no other write can change the key in between.
+func (e *Engine) PutAndGetPrevious(key, value string) (prev string, existed bool, err error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return "", false, err
+	}
+	if err := e.validateValue(value); err != nil {
+		return "", false, err
+	}
+	return e.replaceRecord(record{key: key, value: value}, true)
+}
+
+// DeleteAndGetPrevious deletes the key like Delete and returns the value the key had before, existed
+// is false if the key was missing, deleted or expired, in which case nothing is written. The read and
+// the write happen under the write lock, so no other write can change the key in between.
+func (e *Engine) DeleteAndGetPrevious(key string) (prev string, existed bool, err error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return "", false, err
+	}
+	return e.replaceRecord(tombstoneRecord(key), false)
+}
+
+// replaceRecord reads the current value of the key of the record and then appends the record, unless
+// the key doesn't exist and writeMissing is false
+func (e *Engine) replaceRecord(rec record, writeMissing bool) (string, bool, error) {
+	start, now := time.Now(), e.clock.Now()
+	if err := e.lockWrites(); err != nil {
+		return "", false, err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return "", false, ErrEngineClosed
+	}
+
+	prev, err := e.readLatestValue(rec.key, now)
+	existed := err == nil
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", false, err
+	}
+	if !existed && !writeMissing {
+		return "", false, nil
+	}
+
+	if err := e.appendRecord(rec); err != nil {
+		return "", false, err
+	}
+	e.observeWrite(start, rec)
+	return prev, existed, nil
+}
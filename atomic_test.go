@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compare_and_swap_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// an empty old value creates the key only if it's absent
+	swapped, err := engine.CompareAndSwap("leader", "", "node1")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = engine.CompareAndSwap("leader", "", "node2")
+	require.NoError(t, err)
+	assert.False(t, swapped)
+
+	// a mismatching old value leaves the key untouched
+	swapped, err = engine.CompareAndSwap("leader", "node2", "node3")
+	require.NoError(t, err)
+	assert.False(t, swapped)
+	value, err := engine.Get("leader")
+	require.NoError(t, err)
+	assert.Equal(t, "node1", value)
+
+	swapped, err = engine.CompareAndSwap("leader", "node1", "node2")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	value, err = engine.Get("leader")
+	require.NoError(t, err)
+	assert.Equal(t, "node2", value)
+
+	// a deleted key can be created again
+	require.NoError(t, engine.Delete("leader"))
+	swapped, err = engine.CompareAndSwap("leader", "node2", "node3")
+	require.NoError(t, err)
+	assert.False(t, swapped)
+	swapped, err = engine.CompareAndSwap("leader", "", "node3")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	_, err = engine.CompareAndSwap("", "", "value")
+	assert.Error(t, err)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "put_if_absent_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	written, err := engine.PutIfAbsent("order", "created")
+	require.NoError(t, err)
+	assert.True(t, written)
+
+	written, err = engine.PutIfAbsent("order", "duplicate")
+	require.NoError(t, err)
+	assert.False(t, written)
+	value, err := engine.Get("order")
+	require.NoError(t, err)
+	assert.Equal(t, "created", value)
+
+	// a deleted key can be inserted again
+	require.NoError(t, engine.Delete("order"))
+	written, err = engine.PutIfAbsent("order", "recreated")
+	require.NoError(t, err)
+	assert.True(t, written)
+	value, err = engine.Get("order")
+	require.NoError(t, err)
+	assert.Equal(t, "recreated", value)
+
+	// so can an expired key
+	require.NoError(t, engine.PutWithTTL("session", "old", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	written, err = engine.PutIfAbsent("session", "new")
+	require.NoError(t, err)
+	assert.True(t, written)
+}
+
+func TestIncrAndDecr(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "incr_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// a missing key starts from zero
+	value, err := engine.Incr("hits", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+	value, err = engine.Decr("hits", 7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), value)
+
+	stored, err := engine.Get("hits")
+	require.NoError(t, err)
+	assert.Equal(t, "-2", stored)
+
+	require.NoError(t, engine.Put("name", "gopher"))
+	_, err = engine.Incr("name", 1)
+	assert.Error(t, err)
+
+	const workers, increments = 10, 100
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				_, err := engine.Incr("counter", 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stored, err = engine.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(workers*increments), stored)
+}
+
+func TestAppend(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_append")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxValueSize(16))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	for i, line := range []string{"a\n", "b\n", "c\n"} {
+		value, err := engine.Append("lines", line)
+		require.NoError(t, err)
+		assert.Len(t, value, 2*(i+1))
+	}
+	stored, err := engine.Get("lines")
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\nc\n", stored)
+
+	// a deleted key starts from an empty value
+	require.NoError(t, engine.Delete("lines"))
+	value, err := engine.Append("lines", "d\n")
+	require.NoError(t, err)
+	assert.Equal(t, "d\n", value)
+
+	// the combined value must fit the max value size
+	_, err = engine.Append("lines", "this is too long")
+	assert.Error(t, err)
+	stored, err = engine.Get("lines")
+	require.NoError(t, err)
+	assert.Equal(t, "d\n", stored)
+}
+
+func TestPutAndDeleteAndGetPrevious(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_previous")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	prev, existed, err := engine.PutAndGetPrevious("key", "first")
+	require.NoError(t, err)
+	assert.False(t, existed)
+	assert.Empty(t, prev)
+
+	// an empty value exists
+	_, _, err = engine.PutAndGetPrevious("key", "")
+	require.NoError(t, err)
+	prev, existed, err = engine.PutAndGetPrevious("key", "second")
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Empty(t, prev)
+
+	prev, existed, err = engine.PutAndGetPrevious("key", "third")
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, "second", prev)
+
+	prev, existed, err = engine.DeleteAndGetPrevious("key")
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, "third", prev)
+	_, err = engine.Get("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// deleting a missing key doesn't write anything
+	size := engine.writeLog.size
+	prev, existed, err = engine.DeleteAndGetPrevious("key")
+	require.NoError(t, err)
+	assert.False(t, existed)
+	assert.Empty(t, prev)
+	assert.Equal(t, size, engine.writeLog.size)
+
+	prev, existed, err = engine.PutAndGetPrevious("key", "fourth")
+	require.NoError(t, err)
+	assert.False(t, existed)
+	assert.Empty(t, prev)
+}
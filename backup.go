@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backup copies a consistent snapshot of the storage engine into dstDir, which must not exist or be
+// empty. The write log is rotated first so every record written before Backup is in a read log,
+// and only the read logs are copied, so writes can continue while the files are copied.
+// A compaction waits for the backup to finish, as it would replace the logs being copied.
+// The backup can be opened with Restore.
+func (e *Engine) Backup(dstDir string) error {
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return ErrEngineClosed
+	}
+	if e.writeLog.size > 0 {
+		if err := e.rotateWriteLog(); err != nil {
+			e.lock.Unlock()
+			return fmt.Errorf("failed to rotate the write log: %w", err)
+		}
+	}
+	snapshotReadLogs := make([]*readLog, len(e.readLogs))
+	copy(snapshotReadLogs, e.readLogs)
+	e.lock.Unlock()
+
+	if err := e.fs.MkdirAll(dstDir, e.dirMode); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	entries, err := e.fs.ReadDir(dstDir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("backup directory %s is not empty", dstDir)
+	}
+
+	// read logs are never modified and compaction is blocked, so they can be copied without the lock
+	for _, log := range snapshotReadLogs {
+		dstPath := filepath.Join(dstDir, filepath.Base(log.path))
+		if err := copyFile(e.fs, log.path, dstPath, e.fileMode); err != nil {
+			return fmt.Errorf("failed to copy %s to backup: %w", log.path, err)
+		}
+		err := copyFile(e.fs, hintFilePath(log.path), hintFilePath(dstPath), e.fileMode)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to copy hint file of %s to backup: %w", log.path, err)
+		}
+	}
+
+	// the meta file keeps the configuration of the data path, so the backup is opened with the same one
+	err = copyFile(e.fs, e.dataPath+metaFileName, filepath.Join(dstDir, metaFileName), e.fileMode)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to copy meta file to backup: %w", err)
+	}
+
+	return nil
+}
+
+// Restore opens a storage engine from a backup made by Backup. The backup directory becomes the data
+// path of the engine, so it should be copied first if the backup needs to be kept untouched.
+func Restore(srcDir string, options ...OptionSetter) (*Engine, error) {
+	engine, err := NewEngine(srcDir, append(options, WithMustExist(true))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	return engine, nil
+}
+
+// copyFile copies the file in srcPath to dstPath and syncs the copy to the disk
+func copyFile(fsys FS, srcPath, dstPath string, perm os.FileMode) error {
+	src, err := fsys.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fsys.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
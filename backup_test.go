@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "backup_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	dataDir := filepath.Join(tempDir, "data")
+	backupDir := filepath.Join(tempDir, "backup")
+
+	engine, err := NewEngine(dataDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Delete("key0"))
+
+	require.NoError(t, engine.Backup(backupDir))
+
+	// mutate the source after the backup
+	require.NoError(t, engine.Put("key1", "changed"))
+	require.NoError(t, engine.Delete("key2"))
+	require.NoError(t, engine.Put("new", "value"))
+	require.NoError(t, engine.Compact())
+
+	// a backup can't overwrite an existing one
+	assert.Error(t, engine.Backup(backupDir))
+
+	restored, err := Restore(backupDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	_, err = restored.Get("key0")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = restored.Get("new")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	for i := 1; i < 20; i++ {
+		value, err := restored.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+	require.NoError(t, restored.Close())
+
+	value, err := engine.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", value)
+	require.NoError(t, engine.Close())
+
+	_, err = Restore(filepath.Join(tempDir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Batch collects Put and Delete operations which are applied to the storage engine together.
+// Committing a batch writes all its records at once, either all of them become visible or none.
+// A Batch is not safe for concurrent use.
+type Batch struct {
+	engine  *Engine
+	records []record
+}
+
+// NewBatch returns an empty batch for the storage engine
+func (e *Engine) NewBatch() *Batch {
+	return &Batch{engine: e}
+}
+
+// Put adds setting the key-value pair to the batch, it's validated on Commit
+func (b *Batch) Put(key, value string) {
+	b.records = append(b.records, record{key: key, value: value})
+}
+
+// Delete adds deleting the key to the batch, it's validated on Commit
+func (b *Batch) Delete(key string) {
+	b.records = append(b.records, tombstoneRecord(key))
+}
+
+// Len returns the number of operations in the batch
+func (b *Batch) Len() int {
+	return len(b.records)
+}
+
+// Commit validates and appends all the operations of the batch to the storage engine.
+// The records are written with a single write under the write lock and synced to the disk before
+// the index is updated, so a failed commit leaves the engine unchanged. All the records of a batch
+// are marked, so a batch partially written because of a crash is discarded when the log is loaded.
+// The whole batch is written to the same log file even if it grows the log over its max size.
+// After a successful commit the batch is empty and can be reused.
+func (b *Batch) Commit() error {
+	e := b.engine
+	for i := range b.records {
+		b.records[i].key = e.normalizeKey(b.records[i].key)
+		rec := b.records[i]
+		if err := e.validateKey(rec.key); err != nil {
+			return err
+		}
+		if !rec.tombstone() {
+			if err := e.validateValue(rec.value); err != nil {
+				return err
+			}
+		}
+	}
+	if len(b.records) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	if err := e.lockWrites(); err != nil {
+		return err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	if err := e.appendBatch(b.records); err != nil {
+		return err
+	}
+	e.observeWrites(start, b.records)
+	b.records = nil
+	return nil
+}
+
+// appendBatch writes the records with a single write and updates the index once they are synced to
+// the disk, the caller must hold the write lock
+func (e *Engine) appendBatch(records []record) error {
+	var data []byte
+	sizes := make([]int64, len(records))
+	for i, rec := range records {
+		if i < len(records)-1 {
+			rec.flags |= flagBatch
+		}
+		encodedValue, err := e.encodeValue(rec)
+		if err != nil {
+			return err
+		}
+		encoded := encodeRecord(encodedValue)
+		sizes[i] = int64(len(encoded))
+		data = append(data, encoded...)
+	}
+
+	// a batch which only deletes keys is written regardless of the disk quota like a delete
+	for _, rec := range records {
+		if !rec.tombstone() {
+			if err := e.checkDiskQuota(int64(len(data))); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if err := e.prepareWriteLog(int64(len(data))); err != nil {
+		return err
+	}
+
+	offset := e.writeLog.size
+	written, err := e.writeBatch(data)
+	e.writeLog.size += int64(written)
+	if err != nil {
+		// remove the partially written batch so the next records are appended after the last valid record
+		return errors.Join(err, e.rollbackWriteLog(offset))
+	}
+
+	for i, rec := range records {
+		e.updateIndex(rec, offset, sizes[i])
+		offset += sizes[i]
+	}
+
+	e.finishOversizedWrite(int64(len(data)))
+	return nil
+}
+
+// writeBatch writes the encoded batch to the write log and syncs it to the disk, it returns the number
+// of the written bytes. The buffered writes are flushed first, so the batch is written to the file
+// directly and can be truncated if it fails.
+func (e *Engine) writeBatch(data []byte) (int, error) {
+	if err := e.writeLog.flush(); err != nil {
+		return 0, err
+	}
+	file := e.writeLog.file
+	written, err := file.Write(data)
+	if err != nil {
+		return written, err
+	}
+	return written, file.Sync()
+}
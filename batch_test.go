@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchCommit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_batch_commit")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("deleted", "value"))
+
+	batch := engine.NewBatch()
+	batch.Put("name", "gopher")
+	batch.Put("other", "badger")
+	batch.Delete("deleted")
+	assert.Equal(t, 3, batch.Len())
+
+	// nothing is visible before the commit
+	_, err = engine.Get("name")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, batch.Commit())
+	assert.Zero(t, batch.Len())
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	value, err = engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+
+	_, err = engine.Get("deleted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// the batch is loaded back after a restart
+	require.NoError(t, engine.Close())
+	require.NoError(t, os.Remove(hintFilePath(engine.writeLog.file.Name())))
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+
+	value, err = engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+
+	_, err = engine.Get("deleted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestBatchCommitWithInvalidOperation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_batch_invalid")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	sizeBefore := engine.writeLog.size
+
+	batch := engine.NewBatch()
+	batch.Put("name", "badger")
+	batch.Put("", "empty key")
+	require.Error(t, batch.Commit())
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+	assert.Equal(t, sizeBefore, engine.writeLog.size, "Expected nothing to be written for a failed commit")
+
+	require.NoError(t, engine.Close())
+}
+
+func TestBatchCommitWithWriteFailure(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_batch_write_failure")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+
+	// closing the write log behind the engine makes the next write fail
+	require.NoError(t, engine.writeLog.file.Close())
+
+	batch := engine.NewBatch()
+	batch.Put("name", "badger")
+	batch.Put("other", "otter")
+	require.Error(t, batch.Commit())
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value, "Expected a failed commit to leave the prior state intact")
+
+	_, err = engine.Get("other")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.Error(t, engine.Close())
+}
+
+// failingWriteFS fails the writes to the files it opens halfway while failing is set
+type failingWriteFS struct {
+	osFS
+	failing bool
+}
+
+func (fsys *failingWriteFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := fsys.osFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &failingWriteFile{File: file, fsys: fsys}, nil
+}
+
+type failingWriteFile struct {
+	File
+	fsys *failingWriteFS
+}
+
+func (f *failingWriteFile) Write(data []byte) (int, error) {
+	if f.fsys.failing {
+		n, _ := f.File.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	return f.File.Write(data)
+}
+
+func TestBatchCommitWithFailedFlush(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_batch_failed_flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	fsys := &failingWriteFS{}
+	engine, err := NewEngine(tempDir, WithFileSystem(fsys), WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	dataFilePath := engine.writeLog.file.Name()
+
+	// the buffered record fails to be flushed before the batch is written
+	fsys.failing = true
+	batch := engine.NewBatch()
+	batch.Put("other", "otter")
+	require.Error(t, batch.Commit())
+	written, err := os.Stat(dataFilePath)
+	require.NoError(t, err)
+	assert.Less(t, written.Size(), engine.writeLog.size, "Expected the file not to be extended to the buffered size")
+	assert.Error(t, engine.Close())
+
+	// the partial record is truncated when the data path is opened again
+	fsys.failing = false
+	engine, err = NewEngine(tempDir, WithFileSystem(fsys))
+	require.NoError(t, err)
+	defer engine.Close()
+	report, err := engine.Verify()
+	require.NoError(t, err)
+	for _, file := range report.Files {
+		assert.Zero(t, file.Errors, "Expected no partial record in %s", file.Path)
+	}
+	_, err = engine.Get("other")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+// failingTruncateFile writes only the first half of the data of the next write and fails to truncate it
+type failingTruncateFile struct {
+	shortWriteFile
+}
+
+func (f *failingTruncateFile) Truncate(int64) error {
+	return errors.New("read-only file system")
+}
+
+func TestBatchCommitWithFailedRollback(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_batch_failed_rollback")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("name", "gopher"))
+
+	file := engine.writeLog.file
+	engine.writeLog.file = &failingTruncateFile{shortWriteFile{File: file}}
+	batch := engine.NewBatch()
+	batch.Put("other", "otter")
+	err = batch.Commit()
+	engine.writeLog.file = file
+	assert.ErrorContains(t, err, "disk full")
+	assert.ErrorContains(t, err, "failed to truncate partial record", "Expected the failed truncation to be returned")
+}
+
+func TestIncompleteBatchIsDiscardedOnLoad(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_incomplete_batch")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+
+	batch := engine.NewBatch()
+	batch.Put("name", "badger")
+	batch.Put("first", "1")
+	batch.Put("last", "2")
+	require.NoError(t, batch.Commit())
+
+	dataFilePath := engine.writeLog.file.Name()
+	lastRecordSize := engine.writeLog.index["last"].size
+	require.NoError(t, engine.Close())
+
+	// simulate a crash before the last record of the batch reached the disk
+	stat, err := os.Stat(dataFilePath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(dataFilePath, stat.Size()-lastRecordSize))
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	_, err = engine.Get("first")
+	assert.ErrorIs(t, err, ErrKeyNotFound, "Expected the records of the incomplete batch to be discarded")
+
+	require.NoError(t, engine.Close())
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+)
+
+// bloomFilter represents the set of the keys of a read log, a key which is not in the filter is
+// definitely not in the log, so a lookup of a missing key can skip the index of the log
+type bloomFilter struct {
+	// bitsPerKey represents the number of bits the filter is sized with for each key
+	bitsPerKey int
+	// hashes represents the number of bits set for each key
+	hashes int
+	bits   []uint64
+}
+
+// WithBloomFilter keeps a bloom filter of the keys of every read log with the given number of bits
+// per key, so looking up a key skips the logs which definitely don't have it. 10 bits per key give
+// about 1% false positives. The filters are built when a log is closed or loaded and are stored in
+// the hint files.
+func WithBloomFilter(bitsPerKey int) OptionSetter {
+	return func(e *Engine) error {
+		if bitsPerKey <= 0 || bitsPerKey > 32 {
+			return fmt.Errorf("invalid bloom filter bits per key")
+		}
+		e.bloomBitsPerKey = bitsPerKey
+
+		return nil
+	}
+}
+
+// newBloomFilter returns an empty filter sized for the given number of keys
+func newBloomFilter(keys, bitsPerKey int) *bloomFilter {
+	words := (max(keys*bitsPerKey, 64) + 63) / 64
+	// the number of hashes which gives the least false positives is ln(2) times the bits per key
+	hashes := min(max(int(math.Round(float64(bitsPerKey)*math.Ln2)), 1), 30)
+	return &bloomFilter{bitsPerKey: bitsPerKey, hashes: hashes, bits: make([]uint64, words)}
+}
+
+// buildBloomFilter returns a filter of all the keys of the index
+func buildBloomFilter(index map[string]indexEntry, bitsPerKey int) *bloomFilter {
+	filter := newBloomFilter(len(index), bitsPerKey)
+	for key := range index {
+		filter.add(key)
+	}
+	return filter
+}
+
+// add adds the key to the filter
+func (f *bloomFilter) add(key string) {
+	h1, h2 := bloomHash(key)
+	size := uint32(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint32(i)*h2) % size
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain reports whether the key may be in the filter, false means it's definitely not
+func (f *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+	size := uint32(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint32(i)*h2) % size
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash returns two hashes of the key from its 64-bit FNV-1a hash, the bits of a key are picked
+// with double hashing so the key is hashed only once
+func bloomHash(key string) (uint32, uint32) {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	return uint32(hash), uint32(hash>>32) | 1
+}
+
+// lookupReadLog returns the index entry of the key in the read log, the index is only looked up if
+// the bloom filter of the log may contain the key. The caller must hold the lock.
+func (e *Engine) lookupReadLog(log *readLog, key string) (indexEntry, bool) {
+	if log.filter != nil && !log.filter.mayContain(key) {
+		e.bloomSkips.Add(1)
+		return indexEntry{}, false
+	}
+	entry, ok := log.index[key]
+	return entry, ok
+}
+
+// setBloomFilters builds the bloom filters of the read logs which don't have one with the configured
+// bits per key, the filters are dropped if they are disabled
+func (e *Engine) setBloomFilters(logs []*readLog) {
+	for _, log := range logs {
+		switch {
+		case e.bloomBitsPerKey == 0:
+			log.filter = nil
+		case log.filter == nil || log.filter.bitsPerKey != e.bloomBitsPerKey:
+			log.filter = buildBloomFilter(log.index, e.bloomBitsPerKey)
+		}
+	}
+}
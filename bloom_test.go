@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	filter := newBloomFilter(1000, 10)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("key%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.mayContain(fmt.Sprintf("key%d", i)))
+		if filter.mayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 50, "Expected about 1%% false positives with 10 bits per key")
+}
+
+func TestBloomFilterSkipsReadLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "bloom_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithBloomFilter(10), WithMaxLogSize(256))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Close())
+
+	// the filters are loaded from the hint files
+	engine, err = NewEngine(tempDir, WithBloomFilter(10), WithMaxLogSize(256))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.Greater(t, len(engine.readLogs), 1)
+	for _, log := range engine.readLogs {
+		require.NotNil(t, log.filter)
+		assert.Equal(t, buildBloomFilter(log.index, 10).bits, log.filter.bits)
+	}
+
+	// a key which none of the filters has is never looked up in the indexes
+	missing := ""
+	for i := 0; missing == ""; i++ {
+		key := fmt.Sprintf("missing%d", i)
+		found := false
+		for _, log := range engine.readLogs {
+			found = found || log.filter.mayContain(key)
+		}
+		if !found {
+			missing = key
+		}
+	}
+	skipsBefore := engine.Stats().BloomFilterSkips
+	_, err = engine.Get(missing)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, uint64(len(engine.readLogs)), engine.Stats().BloomFilterSkips-skipsBefore)
+
+	for i := 0; i < 100; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+
+	_, err = NewEngine(tempDir, WithBloomFilter(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// bulkLoadBufferSize represents the size of the buffer the records of a bulk load are collected in
+// before they are written to the write log, unless the write buffer of the engine is larger
+const bulkLoadBufferSize = 1 * MB
+
+// ErrBulkLoad is returned by the writes made while a bulk load is running
+var ErrBulkLoad = errors.New("bulk load in progress")
+
+// bulkEntry represents a record written by a bulk load which is not in the index yet
+type bulkEntry struct {
+	rec    record
+	offset int64
+	size   int64
+}
+
+// BulkLoad runs load with a put function which appends key-value pairs to the storage engine much
+// faster than Put, e.g. to seed an empty engine with millions of records. The write lock is held
+// for the whole load, so the reads wait for it and the other writes fail with ErrBulkLoad. The
+// records are written through a large buffer without syncing them, and the index of each log is
+// built once the log is written. Before BulkLoad returns the write log is synced and rotated, so
+// the loaded records are in the read logs, even if load returns an error after putting some of
+// them. The put function must not be used after load returns.
+func (e *Engine) BulkLoad(load func(put func(key, value string) error) error) (err error) {
+	if !e.bulkLoading.CompareAndSwap(false, true) {
+		return ErrBulkLoad
+	}
+	defer e.bulkLoading.Store(false)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
+
+	// the write logs created during the load are buffered too
+	bufferSize := e.writeBufferSize
+	e.writeBufferSize = max(bufferSize, bulkLoadBufferSize)
+	if e.writeLog.buffer == nil {
+		e.writeLog.buffer = bufio.NewWriterSize(e.writeLog.file, e.writeBufferSize)
+	}
+
+	var pending []bulkEntry
+	loading := true
+	put := func(key, value string) error {
+		if !loading {
+			return fmt.Errorf("bulk load is finished")
+		}
+		start := time.Now()
+		rec := record{key: e.normalizeKey(key), value: value}
+		if err := e.validateKey(rec.key); err != nil {
+			return err
+		}
+		if err := e.validateValue(rec.value); err != nil {
+			return err
+		}
+
+		encodedValue, err := e.encodeValue(rec)
+		if err != nil {
+			return err
+		}
+		encoded := encodeRecord(encodedValue)
+		if err := e.checkDiskQuota(int64(len(encoded))); err != nil {
+			return err
+		}
+		// the records of the log are indexed before it's rotated, so they are in its hint file
+		if e.writeLogFull(int64(len(encoded))) {
+			e.indexBulkEntries(pending)
+			pending = pending[:0]
+		}
+		if err := e.prepareWriteLog(int64(len(encoded))); err != nil {
+			return err
+		}
+
+		offset := e.writeLog.size
+		written, err := e.writeLog.write(encoded)
+		e.writeLog.size += int64(written)
+		if err != nil {
+			return err
+		}
+		e.observeWrite(start, rec)
+		// the value is read from the data file, only the key and its location are kept until indexed
+		rec.value = ""
+		pending = append(pending, bulkEntry{rec: rec, offset: offset, size: int64(written)})
+		if e.oversized(int64(written)) {
+			e.indexBulkEntries(pending)
+			pending = pending[:0]
+			e.finishOversizedWrite(int64(written))
+		}
+		return nil
+	}
+
+	err = load(put)
+	loading = false
+	e.indexBulkEntries(pending)
+
+	e.writeBufferSize = bufferSize
+	if e.writeLog.size == 0 {
+		if bufferSize == 0 {
+			e.writeLog.buffer = nil
+		}
+		return err
+	}
+	if rotateErr := e.rotateWriteLog(); rotateErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to finish the bulk load: %w", rotateErr))
+	}
+	return err
+}
+
+// indexBulkEntries points the keys of the entries to their records in the write log in the order
+// they are written. The caller must hold the write lock.
+func (e *Engine) indexBulkEntries(entries []bulkEntry) {
+	for _, entry := range entries {
+		e.updateIndex(entry.rec, entry.offset, entry.size)
+	}
+}
+
+// lockWrites acquires the write lock for a write, or returns ErrBulkLoad if a bulk load is running
+func (e *Engine) lockWrites() error {
+	if e.bulkLoading.Load() {
+		return ErrBulkLoad
+	}
+	e.lock.Lock()
+	return nil
+}
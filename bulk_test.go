@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkLoad(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_bulk_load")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64*KB), WithSyncWrites(true))
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("key0", "before"))
+	var leaked func(key, value string) error
+	err = engine.BulkLoad(func(put func(key, value string) error) error {
+		leaked = put
+		for i := 0; i < 5000; i++ {
+			if err := put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+				return err
+			}
+		}
+		// the other writes are rejected during the load
+		assert.ErrorIs(t, engine.Put("other", "value"), ErrBulkLoad)
+		batch := engine.NewBatch()
+		batch.Put("other", "value")
+		assert.ErrorIs(t, batch.Commit(), ErrBulkLoad)
+		assert.ErrorIs(t, engine.BulkLoad(func(func(key, value string) error) error { return nil }), ErrBulkLoad)
+		assert.Error(t, put("", "value"))
+		return put("key1", "changed")
+	})
+	require.NoError(t, err)
+	assert.Error(t, leaked("late", "value"))
+
+	// the loaded records are in the read logs and the write log is empty
+	assert.Greater(t, len(engine.readLogs), 1)
+	assert.Zero(t, engine.writeLog.size)
+	check := func(engine *Engine, keys int) {
+		for i := 0; i < 5000; i++ {
+			expected := fmt.Sprintf("value%d", i)
+			if i == 1 {
+				expected = "changed"
+			}
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+		count, err := engine.KeyCount()
+		require.NoError(t, err)
+		assert.Equal(t, keys, count)
+	}
+	check(engine, 5000)
+	require.NoError(t, engine.Put("after", "value"))
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(64*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+	check(engine, 5001)
+
+	// the records put before load fails are kept
+	loadErr := errors.New("load failed")
+	err = engine.BulkLoad(func(put func(key, value string) error) error {
+		require.NoError(t, put("partial", "value"))
+		return loadErr
+	})
+	assert.ErrorIs(t, err, loadErr)
+	value, err := engine.Get("partial")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	tempDir, err := os.MkdirTemp("", "benchmark_bulk_load")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(b, err)
+	defer engine.Close()
+
+	value := string(make([]byte, 100))
+	b.ResetTimer()
+	err = engine.BulkLoad(func(put func(key, value string) error) error {
+		for i := 0; i < b.N; i++ {
+			if err := put(fmt.Sprintf("key%d", i), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(b, err)
+}
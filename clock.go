@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock represents the source of the current time of the engine, which decides when the keys expire
+// and how the compaction backups are named
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the clock of the operating system
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the engine take the current time from the clock instead of the operating system,
+// e.g. to test the expiry of keys without waiting for them. The latencies reported to the observer
+// are always measured with the clock of the operating system.
+func WithClock(clock Clock) OptionSetter {
+	return func(e *Engine) error {
+		if clock == nil {
+			return fmt.Errorf("invalid clock")
+		}
+		e.clock = clock
+
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock which only moves when it's advanced
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClockExpiresKeys(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_clock_expires_keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithClock(nil))
+	assert.Error(t, err)
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)}
+	engine, err := NewEngine(tempDir, WithClock(clock))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.PutWithTTL("key", "value", time.Hour))
+	ttl, err := engine.GetTTL("key")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, ttl)
+
+	clock.advance(59 * time.Minute)
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	clock.advance(time.Minute)
+	_, err = engine.Get("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestCompactionBackupsInTheSameSecond(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_backups_same_second")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// the clock never moves, so both compactions run at the same time
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)}
+	engine, err := NewEngine(tempDir, WithClock(clock))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	for _, value := range []string{"first", "second"} {
+		require.NoError(t, engine.Put("key", value))
+		require.NoError(t, engine.RotateLog())
+		require.NoError(t, engine.Compact())
+	}
+
+	backups, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, clock.now.UTC().Format(compactionBackupTimeFormat), backups[0].Name())
+	assert.Equal(t, clock.now.Add(time.Nanosecond).UTC().Format(compactionBackupTimeFormat), backups[1].Name())
+	for _, backup := range backups {
+		files, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir, backup.Name()))
+		require.NoError(t, err)
+		assert.NotEmpty(t, files, backup.Name())
+	}
+
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "second", value)
+}
@@ -1,165 +1,538 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	// compactionBackupDir is the directory in the data path where the replaced logs are moved to
+	compactionBackupDir = "compaction_backup"
+	// compactionBackupTimeFormat is the format of the name of each compaction backup in UTC, the
+	// nanoseconds keep the backups of the compactions within the same second apart
+	compactionBackupTimeFormat = "20060102150405.000000000"
+	// compactionBackupParseFormat parses the names of the backups with and without the nanoseconds,
+	// which the backups created by the older versions don't have
+	compactionBackupParseFormat = "20060102150405"
+)
+
 type compactionManager struct {
 	enabled  bool
 	interval time.Duration
 	ticker   *time.Ticker
 	lock     sync.Mutex
+	// cancel signals the background compaction goroutine to exit and cancels its running compaction
+	cancel context.CancelFunc
+	// wg tracks the background compaction goroutine so closing the engine can wait for it
+	wg sync.WaitGroup
+	// backupRetention limits the backups of the replaced logs kept after a compaction, nil keeps all of them
+	backupRetention *backupRetention
+	// trigger represents when the background compaction is worth running, nil runs it on every interval
+	trigger *compactionTrigger
+	// groupSize represents the number of read logs which are compacted and swapped at a time
+	groupSize int
+	// progress is called with the number of the handled index entries of the compacted logs, nil disables it
+	progress func(processed, total int)
+	// versions represents the number of the most recent versions of each key the compaction keeps
+	versions int
+}
+
+// compactionTrigger represents the state of the read logs the background compaction waits for
+type compactionTrigger struct {
+	// minLogs represents the min number of read logs
+	minLogs int
+	// minDeadRatio represents the min estimated ratio of the bytes of the read logs which can be reclaimed
+	minDeadRatio float64
+}
+
+// backupRetention represents how many of the compaction backups are kept and for how long
+type backupRetention struct {
+	// count represents the max number of the newest backups which are kept
+	count int
+	// maxAge represents the max age of a kept backup, zero means the backups don't expire
+	maxAge time.Duration
+}
+
+// Compact runs the compaction process on demand, it merges all the read logs into new logs
+// which only contain the latest live value of each key, or its most recent values with WithVersionRetention.
+// It can't run concurrently with another compaction, in which case it waits for it to finish.
+func (e *Engine) Compact() error {
+	return e.compact(context.Background())
+}
+
+// CompactContext runs the compaction process like Compact, but stops it and returns ctx.Err() when
+// the context is cancelled. The read logs are compacted and swapped in groups, so a cancelled compaction
+// keeps the groups which are already swapped and leaves the rest of the read logs untouched.
+func (e *Engine) CompactContext(ctx context.Context) error {
+	return e.compact(ctx)
+}
+
+// CompactLogs compacts only the read logs in paths into new logs, leaving the other logs untouched,
+// e.g. for a maintenance policy which picks the logs worth compacting. The paths must be consecutive
+// read logs in the order they are written, as the compacted logs take their place between the older
+// and the newer logs, and the logs sharing a sequence number, the outputs of an earlier compaction,
+// must be compacted together. A record is kept if it's the latest live record of its key among the
+// selected logs, the deleted and expired keys are kept as deleted if an older log has a record of them.
+func (e *Engine) CompactLogs(paths []string) error {
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+	start := time.Now()
+
+	e.lock.RLock()
+	closed := e.closed
+	snapshotReadLogs := make([]*readLog, len(e.readLogs))
+	copy(snapshotReadLogs, e.readLogs)
+	e.lock.RUnlock()
+	if closed {
+		return ErrEngineClosed
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
+
+	first, last, err := selectedLogs(snapshotReadLogs, paths)
+	if err != nil {
+		return err
+	}
+	group := snapshotReadLogs[first : last+1]
+
+	compactionPath, err := e.createCompactionPath()
+	if err != nil {
+		return err
+	}
+	defer e.removeCompactionPath(compactionPath)
+
+	backupPath, err := e.newBackupPath()
+	if err != nil {
+		return err
+	}
+
+	newest := make(map[string]int)
+	for i, log := range group {
+		for key := range log.index {
+			newest[key] = i
+		}
+	}
+	now := e.clock.Now()
+	var retained map[versionLocation]struct{}
+	if e.compactionManager.versions > 1 {
+		if retained, err = e.retainedVersions(context.Background(), group, newest, now); err != nil {
+			return err
+		}
+	}
+
+	compactedLogs, err := e.compactGroup(context.Background(), group, 0, newest, retained, snapshotReadLogs[:first],
+		compactionPath, backupPath, now, func() {})
+	if err != nil {
+		return err
+	}
+
+	e.snapshotManager.removeUnpinned(func() {
+		if err := e.removeExpiredBackups(e.clock.Now()); err != nil {
+			slog.Warn("failed to remove old compaction backups", "err", err)
+		}
+	})
+
+	var reclaimed int64
+	for _, log := range group {
+		reclaimed += log.size
+	}
+	for _, log := range compactedLogs {
+		reclaimed -= log.size
+	}
+	e.observer.OnCompaction(time.Since(start), reclaimed)
+	return nil
+}
+
+// selectedLogs returns the positions of the first and the last of the read logs in paths, which must be
+// consecutive logs without splitting the logs sharing a sequence number
+func selectedLogs(logs []*readLog, paths []string) (int, int, error) {
+	if len(paths) == 0 {
+		return 0, 0, fmt.Errorf("no logs to compact")
+	}
+	positions := make(map[string]int, len(logs))
+	for i, log := range logs {
+		positions[log.path] = i
+	}
+
+	selected := make(map[int]struct{}, len(paths))
+	first, last := len(logs), -1
+	for _, path := range paths {
+		i, ok := positions[path]
+		if !ok {
+			return 0, 0, fmt.Errorf("%s is not a read log of the engine", path)
+		}
+		selected[i] = struct{}{}
+		first, last = min(first, i), max(last, i)
+	}
+	if len(selected) != last-first+1 {
+		return 0, 0, fmt.Errorf("the logs to compact must be consecutive")
+	}
+	if (first > 0 && extractFileNumber(logs[first-1].path) == extractFileNumber(logs[first].path)) ||
+		(last < len(logs)-1 && extractFileNumber(logs[last+1].path) == extractFileNumber(logs[last].path)) {
+		return 0, 0, fmt.Errorf("the logs sharing a sequence number must be compacted together")
+	}
+	return first, last, nil
 }
 
 // compact orchestrates the compaction process for the storage engine.
 // It ensures that only one compaction process can run at a time and manages the creation,
 // execution, and cleanup of the compaction environment.
-func (e *Engine) compact() error {
+func (e *Engine) compact(ctx context.Context) error {
 	// Acquire a lock to ensure single execution of the compaction process
 	e.compactionManager.lock.Lock()
 	defer e.compactionManager.lock.Unlock()
+	start := time.Now()
+
+	e.lock.RLock()
+	closed := e.closed
+	e.lock.RUnlock()
+	if closed {
+		return ErrEngineClosed
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
+
+	compactionPath, err := e.createCompactionPath()
+	if err != nil {
+		return err
+	}
+	defer e.removeCompactionPath(compactionPath)
+
+	// Take a snapshot of the current read logs for processing
+	e.lock.RLock()
+	snapshotReadLogs := make([]*readLog, len(e.readLogs))
+	copy(snapshotReadLogs, e.readLogs)
+	e.lock.RUnlock()
+
+	processed, total := 0, 0
+	for _, log := range snapshotReadLogs {
+		total += len(log.index)
+	}
+	progress := func() {
+		// the engine lock is not held here, so the callback can't block the reads and writes
+		if e.compactionManager.progress != nil {
+			processed++
+			e.compactionManager.progress(processed, total)
+		}
+	}
+
+	// all the groups of a compaction move the replaced logs to the same backup
+	backupPath, err := e.newBackupPath()
+	if err != nil {
+		return err
+	}
+
+	// The position of the newest log which has a record of each key decides which record is kept, so
+	// every group drops the records which are shadowed by the other groups, and the deleted and expired
+	// keys are dropped altogether. The keys written after the snapshot only make more records dead, so
+	// it's safe to ignore them.
+	newest := make(map[string]int)
+	for i, log := range snapshotReadLogs {
+		for key := range log.index {
+			newest[key] = i
+		}
+	}
+
+	now := e.clock.Now()
+	// with version retention the records to keep are found by going through all the records of the
+	// logs, as the index only has the latest record of each key in a log
+	var retained map[versionLocation]struct{}
+	if e.compactionManager.versions > 1 {
+		var err error
+		if retained, err = e.retainedVersions(ctx, snapshotReadLogs, newest, now); err != nil {
+			return err
+		}
+	}
 
-	// Define the path for the compaction directory
-	compactionPath := filepath.Join(e.dataPath, "compaction")
-	compactionPath = ensureTrailingSlash(compactionPath)
+	var reclaimed int64
+	groupStart := 0
+	for i, group := range compactionGroups(snapshotReadLogs, e.compactionManager.groupSize) {
+		groupPath := ensureTrailingSlash(filepath.Join(compactionPath, strconv.Itoa(i)))
+		compactedLogs, err := e.compactGroup(ctx, group, groupStart, newest, retained, nil, groupPath, backupPath, now, progress)
+		if err != nil {
+			return err
+		}
+		groupStart += len(group)
+
+		for _, log := range group {
+			reclaimed += log.size
+		}
+		for _, log := range compactedLogs {
+			reclaimed -= log.size
+		}
+	}
+
+	// the backups are only removed after a successful compaction, so a failed one can be recovered,
+	// and only once no snapshot reads the compacted logs anymore
+	e.snapshotManager.removeUnpinned(func() {
+		if err := e.removeExpiredBackups(e.clock.Now()); err != nil {
+			slog.Warn("failed to remove old compaction backups", "err", err)
+		}
+	})
+
+	e.observer.OnCompaction(time.Since(start), reclaimed)
+
+	return nil
+}
+
+// createCompactionPath creates the directory the compaction engines write the compacted logs to, a
+// directory left behind by another compaction fails it
+func (e *Engine) createCompactionPath() (string, error) {
+	compactionPath := ensureTrailingSlash(filepath.Join(e.dataPath, "compaction"))
 
 	// Check if the compaction directory already exists as a sign of problematic or incomplete compaction process
-	if _, err := os.Stat(compactionPath); err == nil {
-		return fmt.Errorf("compaction process already in progress or previous compaction was not properly cleaned up")
+	if _, err := e.fs.Stat(compactionPath); err == nil {
+		return "", fmt.Errorf("compaction process already in progress or previous compaction was not properly cleaned up")
 	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to check compaction directory: %w", err)
+		return "", fmt.Errorf("failed to check compaction directory: %w", err)
 	}
 
-	// Create the compaction directory
-	if err := os.MkdirAll(compactionPath, 0755); err != nil {
-		return fmt.Errorf("failed to create compaction directory: %w", err)
+	if err := e.fs.MkdirAll(compactionPath, e.dirMode); err != nil {
+		return "", fmt.Errorf("failed to create compaction directory: %w", err)
 	}
+	return compactionPath, nil
+}
 
-	// cleanup compaction path
-	defer func() {
-		// Cleanup compaction directory after compaction, regardless of success or failure
-		if cleanupErr := os.RemoveAll(compactionPath); cleanupErr != nil {
-			slog.Warn("failed to clean up compaction directory", "err", cleanupErr)
+// removeCompactionPath cleans up the compaction directory after a compaction, regardless of its success
+func (e *Engine) removeCompactionPath(compactionPath string) {
+	if err := removeAll(e.fs, compactionPath); err != nil {
+		slog.Warn("failed to clean up compaction directory", "err", err)
+	}
+}
+
+// newBackupPath returns the path of the backup of a new compaction, which is named after the current
+// time in UTC, so the age of the backups doesn't depend on the time zone of the clock. The time is moved forward if a backup with the same name exists, e.g. with a clock which
+// doesn't move, so a compaction never moves its logs into the backup of another one.
+func (e *Engine) newBackupPath() (string, error) {
+	createdAt := e.clock.Now().UTC()
+	for {
+		path := filepath.Join(e.dataPath, compactionBackupDir, createdAt.Format(compactionBackupTimeFormat))
+		_, err := e.fs.Stat(path)
+		if os.IsNotExist(err) {
+			return path, nil
 		}
-	}()
+		if err != nil {
+			return "", fmt.Errorf("failed to check compaction backup: %w", err)
+		}
+		createdAt = createdAt.Add(time.Nanosecond)
+	}
+}
+
+// compactionGroups splits the read logs into groups of consecutive logs, from the oldest to the newest,
+// which are compacted and swapped one at a time. Each group has size logs, except that the logs with
+// the same sequence number are kept in the same group as the output of a group is named after its
+// newest log.
+func compactionGroups(logs []*readLog, size int) [][]*readLog {
+	var groups [][]*readLog
+	for start := 0; start < len(logs); {
+		end := min(start+size, len(logs))
+		for end < len(logs) && extractFileNumber(logs[end].path) == extractFileNumber(logs[end-1].path) {
+			end++
+		}
+		groups = append(groups, logs[start:end])
+		start = end
+	}
+	return groups
+}
+
+// compactGroup merges the group of read logs, which starts at the given position of the snapshot of
+// the compaction, into new logs written by a compaction engine in path and swaps them in place of the
+// group, then it returns the new logs. A record is only kept if it's live and its log is the newest
+// log of the snapshot which has a record of its key, or with version retention if it's one of the
+// retained records. The deleted and expired keys which have records in olderLogs, the logs older
+// than the group which are not compacted, are kept as deleted so those records stay hidden.
+func (e *Engine) compactGroup(ctx context.Context, group []*readLog, groupStart int, newest map[string]int,
+	retained map[versionLocation]struct{}, olderLogs []*readLog, path, backupPath string, now time.Time,
+	progress func()) ([]*readLog, error) {
+	if err := e.fs.MkdirAll(path, e.dirMode); err != nil {
+		return nil, fmt.Errorf("failed to create compaction directory: %w", err)
+	}
 
 	// Create a new engine instance for the compaction process
 	// compaction engine should have the same settings and options as the main engine
-	cEngine, err := NewEngine(compactionPath, e.options...)
+	// except for the background processes which should never run on the compaction engine itself
+	cOptions := append(append([]OptionSetter{}, e.options...), withoutBackgroundCompaction())
+	cEngine, err := NewEngine(path, cOptions...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer func() {
+		// the write log of the compaction engine is already closed unless the compaction is stopped early
+		_ = cEngine.writeLog.file.Close()
+		if closeErr := cEngine.fileCache.close(); closeErr != nil {
+			slog.Warn("failed to close compaction engine files", "err", closeErr)
+		}
+		if closeErr := releaseFlock(cEngine.lockFile); closeErr != nil {
+			slog.Warn("failed to release compaction engine lock", "err", closeErr)
+		}
+	}()
 
-	// Take a snapshot of the current read logs for processing
-	snapshotReadLogs := make([]*readLog, len(e.readLogs))
-	copy(snapshotReadLogs, e.readLogs)
-
-	// Map to track the keys that have been deleted
-	deletedKeys := make(map[string]struct{})
-
-	// Iterate through each log in the snapshot and compact the data
-	for i := len(snapshotReadLogs) - 1; i >= 0; i-- {
-		currentLog := snapshotReadLogs[i]
-		for key, offset := range currentLog.index {
-			if _, ok := deletedKeys[key]; ok {
-				continue // Skip this key as it's already deleted
+	// Iterate through each log of the group and compact the data
+	for i, currentLog := range group {
+		if retained != nil {
+			if err := e.compactRetainedVersions(ctx, cEngine, currentLog, groupStart+i, retained, progress); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for key, entry := range currentLog.index {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress()
+			if newest[key] != groupStart+i {
+				continue // Skip this record as a newer log has a record of its key
+			}
+			if !entry.live(now) {
+				continue // Skip this record as its key is deleted or expired
 			}
 
-			// Try to get the key from the compaction engine. If it exists, no need to re-add it.
-			if _, err := cEngine.Get(key); err != nil {
-				// If the key doesn't exist in the compaction engine, read its value
-				value, err := e.readValueFromFile(currentLog.path, offset)
-				if err != nil {
-					return fmt.Errorf("failed to read value for key %s: %w", key, err)
-				}
+			value, err := e.readValueFromFile(currentLog.path, entry.offset, currentLog.version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read value for key %s: %w", key, err)
+			}
 
-				// Check if the current value is a tombstone, indicating the key is deleted
-				if value == e.tombStone {
-					deletedKeys[key] = struct{}{}
-					continue // Skip adding this key-value pair to the compaction engine
-				}
+			// Add the key-value pair to the compaction engine keeping its expiry time and its tags
+			if err := cEngine.putRecord(record{key: key, value: value, expiry: entry.expiry, tags: currentLog.tags[key]}); err != nil {
+				return nil, fmt.Errorf("failed to put key-value pair in compaction engine: %w", err)
+			}
+		}
+	}
 
-				// Add the key-value pair to the compaction engine
-				if err := cEngine.Put(key, value); err != nil {
-					return fmt.Errorf("failed to put key-value pair in compaction engine: %w", err)
-				}
+	for i, currentLog := range group {
+		if len(olderLogs) == 0 {
+			break
+		}
+		for key, entry := range currentLog.index {
+			if newest[key] != groupStart+i || entry.live(now) || !existsInLogs(olderLogs, key) {
+				continue
+			}
+			if err := cEngine.appendKeyValue(tombstoneRecord(key)); err != nil {
+				return nil, fmt.Errorf("failed to put tombstone in compaction engine: %w", err)
 			}
 		}
 	}
 
 	// Close the write log of the compaction engine to finalize the current log
-	err = cEngine.closeWriteLog()
-	if err != nil {
-		return err
+	if err := cEngine.closeWriteLog(); err != nil {
+		return nil, err
+	}
+	// the last log of the compaction engine is empty if nothing of the group is kept, e.g. when all
+	// its keys are deleted, and it's left behind so the group is only removed from the data path
+	compactedLogs := cEngine.readLogs[:0]
+	for _, log := range cEngine.readLogs {
+		if log.size > 0 {
+			compactedLogs = append(compactedLogs, log)
+		}
 	}
+	cEngine.readLogs = compactedLogs
 
 	// Replace the compacted logs in the original engine
-	err = e.replaceCompactedLogs(snapshotReadLogs, cEngine)
-	if err != nil {
-		return err
+	if err := e.replaceCompactedLogs(group, cEngine, backupPath); err != nil {
+		return nil, err
 	}
-
-	return nil
+	return cEngine.readLogs, nil
 }
 
-// replaceCompactedLogs handles the final steps of the compaction process.
-// It moves the old log files to a backup directory and updates the engine's read logs
-// with the new compacted logs from the compaction engine.
-func (e *Engine) replaceCompactedLogs(snapshotReadLogs []*readLog, cEngine *Engine) error {
+// replaceCompactedLogs handles the final steps of the compaction of a group of logs.
+// It moves the old log files to the backup directory and puts the new compacted logs from the
+// compaction engine in place of the group in the engine's read logs.
+func (e *Engine) replaceCompactedLogs(group []*readLog, cEngine *Engine, backupPath string) error {
 	// Ensure exclusive access to the engine during the replacement process
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	// Create a backup directory with a timestamp to store old logs
-	backupPath := filepath.Join(e.dataPath, "compaction_backup", time.Now().Format("20060102150405"))
-	if err := os.MkdirAll(backupPath, 0755); err != nil {
+	// Create the backup directory to store old logs
+	if err := e.fs.MkdirAll(backupPath, e.dirMode); err != nil {
 		return fmt.Errorf("failed to create backup directory: %w", err)
 	}
 
 	// Move each old log file to the backup directory
-	for _, log := range snapshotReadLogs {
+	for _, log := range group {
 		backupFilePath := filepath.Join(backupPath, filepath.Base(log.path))
-		if err := os.Rename(log.path, backupFilePath); err != nil {
+		if err := e.fs.Rename(log.path, backupFilePath); err != nil {
 			return fmt.Errorf("failed to move old file %s to backup: %w", log.path, err)
 		}
-	}
-
-	// Move compacted files from the compaction directory to the main directory
-	compactionFiles, err := extractDatafiles(cEngine.dataPath)
-	if err != nil {
-		return fmt.Errorf("failed to read compaction directory: %w", err)
-	}
-	for _, path := range compactionFiles {
-		newPath := filepath.Join(e.dataPath, filepath.Base(path))
-		if err := os.Rename(path, newPath); err != nil {
-			return fmt.Errorf("failed to move compacted file %s to %s: %w", path, newPath, err)
+		if err := moveHintFile(e.fs, log.path, backupFilePath); err != nil {
+			return fmt.Errorf("failed to move old hint file of %s to backup: %w", log.path, err)
 		}
+		// the cached handle points to the file which is now in the backup directory
+		e.fileCache.evict(log.path)
 	}
 
-	// Update the file paths in the read logs of the compaction engine to reflect their new location
-	for _, log := range cEngine.readLogs {
-		fileName := filepath.Base(log.path)
-		log.path = filepath.Join(e.dataPath, fileName)
+	// Move compacted files from the compaction directory to the main directory. They are named after
+	// the newest log of the group with a new generation, so they keep its position in the order of
+	// the logs before the newer logs, and never reuse an existing name.
+	number, generation := compactedFileName(group)
+	for i, log := range cEngine.readLogs {
+		newPath, err := e.dataFilePath(number, i+1, generation)
+		if err != nil {
+			return err
+		}
+		if err := e.fs.Rename(log.path, newPath); err != nil {
+			return fmt.Errorf("failed to move compacted file %s to %s: %w", log.path, newPath, err)
+		}
+		if err := moveHintFile(e.fs, log.path, newPath); err != nil {
+			return fmt.Errorf("failed to move compacted hint file of %s to %s: %w", log.path, newPath, err)
+		}
+		// Update the file path in the read log of the compaction engine to reflect its new location
+		log.path = newPath
 	}
+	e.openReadLogs(cEngine.readLogs)
 
-	// Combine the new compacted logs with the remaining original logs
-	newReadLogs := make([]*readLog, len(cEngine.readLogs))
-	copy(newReadLogs, cEngine.readLogs)
-
+	// The new compacted logs take the place of the group among the other logs
+	newReadLogs := make([]*readLog, 0, len(e.readLogs)-len(group)+len(cEngine.readLogs))
+	replaced := false
 	for _, log := range e.readLogs {
-		if !isLogInSnapshot(log, snapshotReadLogs) {
+		if !isLogInSnapshot(log, group) {
 			newReadLogs = append(newReadLogs, log)
+		} else if !replaced {
+			newReadLogs = append(newReadLogs, cEngine.readLogs...)
+			replaced = true
 		}
 	}
 
 	e.readLogs = newReadLogs
+	// compaction drops the expired keys, so the count is rebuilt from the new indexes
+	e.keyCount = e.countKeys()
+	// the cached values are read again from the compacted logs
+	e.valueCache.clear()
 
-	return nil
+	// the renames are synced once the logs are replaced, the files are in place even if it fails
+	renamed := make([]string, 0, 2*len(group)+len(cEngine.readLogs))
+	for _, log := range group {
+		renamed = append(renamed, log.path, filepath.Join(backupPath, filepath.Base(log.path)))
+	}
+	for _, log := range cEngine.readLogs {
+		renamed = append(renamed, log.path)
+	}
+	return e.syncDirs(renamed...)
+}
+
+// compactedFileName returns the sequence number and the generation the output files of a compaction
+// of the given read logs are named with, which are the sequence number of the newest compacted log
+// and a generation newer than any of its data files.
+func compactedFileName(snapshotReadLogs []*readLog) (int, int) {
+	if len(snapshotReadLogs) == 0 {
+		return 0, 1
+	}
+	number := extractFileNumber(snapshotReadLogs[len(snapshotReadLogs)-1].path)
+	generation := 0
+	for _, log := range snapshotReadLogs {
+		if extractFileNumber(log.path) == number {
+			generation = max(generation, extractFileGeneration(log.path))
+		}
+	}
+	return number, generation + 1
 }
 
 func isLogInSnapshot(log *readLog, snapshotReadLogs []*readLog) bool {
@@ -177,12 +550,200 @@ func (e *Engine) startBackgroundCompaction() error {
 	}
 
 	e.compactionManager.ticker = time.NewTicker(e.compactionManager.interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	e.compactionManager.cancel = cancel
+	e.compactionManager.wg.Add(1)
 	go func() {
-		for range e.compactionManager.ticker.C {
-			if err := e.compact(); err != nil {
-				slog.Warn("failed to run compaction", "err", err)
+		defer e.compactionManager.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.compactionManager.ticker.C:
+				if _, err := e.maybeCompact(ctx); err != nil && !errors.Is(err, context.Canceled) {
+					slog.Warn("failed to run compaction", "err", err)
+				}
 			}
 		}
 	}()
 	return nil
 }
+
+// WithCompactionTrigger makes the background compaction only run when there are at least minLogs read
+// logs and the estimated ratio of their bytes which can be reclaimed is more than minDeadRatio, so a
+// mostly clean data path is not rewritten for nothing. The compaction interval becomes the interval
+// the trigger is checked on. Compact runs the compaction regardless of the trigger.
+func WithCompactionTrigger(minLogs int, minDeadRatio float64) OptionSetter {
+	return func(engine *Engine) error {
+		if minLogs < 1 || minDeadRatio < 0 || minDeadRatio >= 1 {
+			return fmt.Errorf("invalid compaction trigger")
+		}
+		engine.compactionManager.trigger = &compactionTrigger{minLogs: minLogs, minDeadRatio: minDeadRatio}
+		return nil
+	}
+}
+
+// WithCompactionProgress calls progress for every index entry of the compacted logs a compaction
+// handles, with the number of the handled entries and the total number of the entries to handle,
+// e.g. to show the progress of a long compaction. It's called without holding any lock of the engine.
+func WithCompactionProgress(progress func(processed, total int)) OptionSetter {
+	return func(engine *Engine) error {
+		if progress == nil {
+			return fmt.Errorf("invalid compaction progress callback")
+		}
+		engine.compactionManager.progress = progress
+		return nil
+	}
+}
+
+// WithCompactionGroupSize sets the number of read logs a compaction merges and swaps at a time,
+// the default is 8. The compaction only keeps the output of one group besides the data path and
+// only blocks the reads and writes while a group is swapped, so smaller groups make it lighter on
+// the memory and the disk at the cost of more and smaller compacted logs.
+func WithCompactionGroupSize(size int) OptionSetter {
+	return func(engine *Engine) error {
+		if size < 1 {
+			return fmt.Errorf("invalid compaction group size")
+		}
+		engine.compactionManager.groupSize = size
+		return nil
+	}
+}
+
+// WithVersionRetention makes the compaction keep the n most recent values of each key instead of only
+// the latest one, e.g. for auditing, which are read back with GetVersions. A delete still drops all the
+// values of its key. The garbage collection only keeps the latest value of the keys of a rewritten log.
+func WithVersionRetention(n int) OptionSetter {
+	return func(engine *Engine) error {
+		if n < 1 {
+			return fmt.Errorf("invalid version retention")
+		}
+		engine.compactionManager.versions = n
+		return nil
+	}
+}
+
+// maybeCompact runs the compaction if the read logs match the compaction trigger and reports whether it ran
+func (e *Engine) maybeCompact(ctx context.Context) (bool, error) {
+	if trigger := e.compactionManager.trigger; trigger != nil {
+		e.lock.RLock()
+		logs := len(e.readLogs)
+		deadRatio := e.readLogsDeadRatio(e.clock.Now())
+		e.lock.RUnlock()
+		if logs < trigger.minLogs || deadRatio <= trigger.minDeadRatio {
+			return false, nil
+		}
+	}
+	return true, e.compact(ctx)
+}
+
+// readLogsDeadRatio estimates the ratio of the bytes of the read logs which can be reclaimed by
+// compaction, these are the records shadowed by newer logs, tombstones and expired records.
+// The caller must hold the lock.
+func (e *Engine) readLogsDeadRatio(now time.Time) float64 {
+	shadowed := make(map[string]struct{}, len(e.writeLog.index))
+	for key := range e.writeLog.index {
+		shadowed[key] = struct{}{}
+	}
+
+	var size, liveBytes int64
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		log := e.readLogs[i]
+		size += log.size
+		if log.version != formatVersionLegacy && log.size > 0 {
+			size -= fileHeaderSize
+		}
+		live, _ := liveRecords(log, shadowed, now)
+		liveBytes += live
+		for key := range log.index {
+			shadowed[key] = struct{}{}
+		}
+	}
+	if size <= 0 {
+		return 0
+	}
+	return float64(size-liveBytes) / float64(size)
+}
+
+// WithBackupRetention removes the backups of the logs replaced by compaction after every successful
+// compaction, keeping at most the count newest backups which are not older than maxAge. A count of
+// zero removes the backup right after the compaction and a zero maxAge keeps the backups regardless
+// of their age. Without this option all the backups are kept.
+func WithBackupRetention(count int, maxAge time.Duration) OptionSetter {
+	return func(engine *Engine) error {
+		if count < 0 || maxAge < 0 {
+			return fmt.Errorf("invalid backup retention")
+		}
+		engine.compactionManager.backupRetention = &backupRetention{count: count, maxAge: maxAge}
+		return nil
+	}
+}
+
+// removeExpiredBackups removes the compaction backups which are not kept by the retention policy
+func (e *Engine) removeExpiredBackups(now time.Time) error {
+	retention := e.compactionManager.backupRetention
+	if retention == nil {
+		return nil
+	}
+
+	backupsPath := filepath.Join(e.dataPath, compactionBackupDir)
+	entries, err := e.fs.ReadDir(backupsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// the names are timestamps, so the sorted entries go from the oldest to the newest backup
+	var errs []error
+	kept := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		createdAt, err := time.Parse(compactionBackupParseFormat, entries[i].Name())
+		if err != nil || !entries[i].IsDir() {
+			// not created by compaction
+			continue
+		}
+		if kept < retention.count && (retention.maxAge == 0 || now.Sub(createdAt) <= retention.maxAge) {
+			kept++
+			continue
+		}
+		if err := removeAll(e.fs, filepath.Join(backupsPath, entries[i].Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withoutBackgroundCompaction disables the background compaction, sync and garbage collection, it's used for the internal engines
+func withoutBackgroundCompaction() OptionSetter {
+	return func(engine *Engine) error {
+		engine.compactionManager.enabled = false
+		engine.syncManager.interval = 0
+		engine.gcManager.interval = 0
+		// the compaction has to be able to run over the disk quota to reclaim space
+		engine.maxDiskBytes = 0
+		// the writes of the internal engines are not operations of the user
+		engine.observer = noopObserver{}
+		// the internal engines lock their own directories, the lock file of the engine is already held
+		engine.lockFilePath = ""
+		// the engine already warned about its max log size
+		engine.allowTinyLogs = true
+		return nil
+	}
+}
+
+// stopBackgroundCompaction stops the background compaction, cancelling its in-flight compaction,
+// and waits for any compaction started on demand to finish.
+func (e *Engine) stopBackgroundCompaction() {
+	if e.compactionManager.ticker != nil {
+		e.compactionManager.ticker.Stop()
+		e.compactionManager.cancel()
+		e.compactionManager.wg.Wait()
+		e.compactionManager.ticker = nil
+	}
+
+	// acquiring the compaction lock waits for a compaction started by Compact
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+}
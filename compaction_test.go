@@ -1,13 +1,18 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSuccessfulCompactionWithUpdates(t *testing.T) {
@@ -54,7 +59,7 @@ func TestSuccessfulCompactionWithUpdates(t *testing.T) {
 	startFiles, err := os.ReadDir(tempDir)
 
 	// Run Compaction
-	err = engine.compact()
+	err = engine.Compact()
 	require.NoError(t, err)
 
 	// Assertions
@@ -106,20 +111,19 @@ func TestSuccessfulCompactionWithDeletions(t *testing.T) {
 		require.NoError(t, err)
 	}
 
-	err = engine.closeWriteLog()
-	require.NoError(t, err)
+	require.NoError(t, engine.RotateLog())
 
 	// Run Compaction
-	err = engine.compact()
+	err = engine.Compact()
 	require.NoError(t, err)
 
 	// Get a list of compacted files
-	compactFiles, err := extractDatafiles(tempDir)
+	compactFiles, err := extractDatafiles(osFS{}, tempDir)
 	require.NoError(t, err)
 
 	// Read each compacted file and check for deleted keys
 	for _, filePath := range compactFiles {
-		keys, err := extractKeysFromDataFile(filePath)
+		keys, err := extractKeysFromDataFile(osFS{}, filePath)
 		require.NoError(t, err)
 
 		// Check that none of the deleted keys are present
@@ -151,3 +155,717 @@ func isDeletedKey(key string) bool {
 	}
 	return keyNum < 25 // since we deleted keys from key0 to key24
 }
+
+func TestCompactionDropsExpiredKeys(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compaction_with_expired_keys_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(256))
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if i < 10 {
+			require.NoError(t, engine.PutWithTTL(key, "expiring", 50*time.Millisecond))
+		} else {
+			require.NoError(t, engine.PutWithTTL(key, "lasting", time.Hour))
+		}
+	}
+	// reopen the engine so all the records are in the read logs
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir, WithMaxLogSize(256))
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, engine.Compact())
+
+	compactFiles, err := extractDatafiles(osFS{}, tempDir)
+	require.NoError(t, err)
+	for _, filePath := range compactFiles {
+		keys, err := extractKeysFromDataFile(osFS{}, filePath)
+		require.NoError(t, err)
+		for _, key := range keys {
+			keyNum, err := strconv.Atoi(strings.TrimPrefix(key, "key"))
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, keyNum, 10, "Expired key %s found in compacted file %s", key, filePath)
+		}
+	}
+
+	// the expiry time of the lasting keys is kept by the compaction
+	for _, log := range engine.readLogs {
+		for key, entry := range log.index {
+			assert.NotZero(t, entry.expiry, "Expected key %s to keep its expiry time", key)
+		}
+	}
+
+	for i := 10; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, "lasting", value)
+	}
+
+	require.NoError(t, engine.Close())
+}
+
+func TestCompactOnDemand(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compact_on_demand_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d_%d", i, round)))
+		}
+	}
+	logsBefore := len(engine.readLogs)
+
+	require.NoError(t, engine.Compact())
+	assert.Less(t, len(engine.readLogs), logsBefore)
+
+	for i := 0; i < 10; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d_4", i), value)
+	}
+
+	require.NoError(t, engine.Close())
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "background_compaction_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	require.Error(t, WithBackgroundCompaction(0)(&Engine{compactionManager: &compactionManager{}}))
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128), WithBackgroundCompaction(10*time.Millisecond))
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(tempDir, "compaction_backup"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "Expected the background compaction to run")
+
+	require.NoError(t, engine.Close())
+
+	// no compaction should be left running or half done after close
+	_, err = os.Stat(filepath.Join(tempDir, "compaction"))
+	assert.True(t, os.IsNotExist(err))
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+	require.NoError(t, engine.Close())
+}
+
+// cancelAfterContext is a context which gets cancelled after its Err method is called n times,
+// it's used to cancel an operation at a deterministic point in the middle of its work
+type cancelAfterContext struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestCancelCompaction(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cancel_compaction_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d_%d", i, round)))
+		}
+	}
+
+	logsBefore := make([]string, 0, len(engine.readLogs))
+	for _, log := range engine.readLogs {
+		logsBefore = append(logsBefore, log.path)
+	}
+
+	ctx := &cancelAfterContext{Context: context.Background(), n: 10}
+	err = engine.CompactContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the original logs are untouched and the compaction directory is cleaned up
+	logsAfter := make([]string, 0, len(engine.readLogs))
+	for _, log := range engine.readLogs {
+		logsAfter = append(logsAfter, log.path)
+		_, err := os.Stat(log.path)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, logsBefore, logsAfter)
+	_, err = os.Stat(filepath.Join(tempDir, "compaction"))
+	assert.True(t, os.IsNotExist(err))
+
+	for i := 0; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d_2", i), value)
+	}
+
+	// a later compaction is not blocked by the cancelled one
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+}
+
+func TestBackupRetention(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "backup_retention_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithBackupRetention(-1, 0))
+	require.Error(t, err)
+
+	// backups left by earlier compactions, from the oldest to the newest
+	now := time.Now().UTC()
+	oldBackups := []string{
+		now.Add(-72 * time.Hour).Format(compactionBackupTimeFormat),
+		now.Add(-3 * time.Hour).Format(compactionBackupTimeFormat),
+		now.Add(-2 * time.Hour).Format(compactionBackupTimeFormat),
+		now.Add(-1 * time.Hour).Format(compactionBackupTimeFormat),
+	}
+	for _, name := range oldBackups {
+		require.NoError(t, os.MkdirAll(filepath.Join(tempDir, compactionBackupDir, name), 0755))
+	}
+
+	backups := func() []string {
+		entries, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+		require.NoError(t, err)
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128), WithBackupRetention(3, 24*time.Hour))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+
+	// the backup of this compaction and the two newest old ones are kept, the oldest is too old anyway
+	remaining := backups()
+	require.Len(t, remaining, 3)
+	assert.Equal(t, oldBackups[2:], remaining[:2])
+
+	// a retention of zero removes the backups right after the compaction
+	engine, err = NewEngine(tempDir, WithMaxLogSize(128), WithBackupRetention(0, 0))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("new_value%d", i)))
+	}
+	require.NoError(t, engine.Compact())
+	assert.Empty(t, backups())
+
+	for i := 0; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("new_value%d", i), value)
+	}
+	require.NoError(t, engine.Close())
+}
+
+func TestFileNamesAfterCompaction(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "file_names_after_compaction_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(256))
+	require.NoError(t, err)
+
+	// logPaths returns the paths of all the logs of the engine from the oldest to the newest
+	logPaths := func(engine *Engine) []string {
+		var paths []string
+		for _, log := range engine.readLogs {
+			paths = append(paths, log.path)
+		}
+		return append(paths, engine.writeLog.file.Name())
+	}
+	seen := make(map[string]struct{})
+	assertOrdered := func(paths []string) {
+		for i := 1; i < len(paths); i++ {
+			assert.True(t, lessFileName(paths[i-1], paths[i]), "%s should be older than %s", paths[i-1], paths[i])
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	for _, log := range engine.readLogs {
+		seen[log.path] = struct{}{}
+	}
+
+	require.NoError(t, engine.Compact())
+	assertOrdered(logPaths(engine))
+
+	// the updates after the compaction must be loaded after the compacted logs
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("new_value%d", i)))
+	}
+	paths := logPaths(engine)
+	assertOrdered(paths)
+	for _, path := range paths {
+		_, ok := seen[path]
+		assert.False(t, ok, "%s reuses the name of a compacted log", path)
+	}
+
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	paths = logPaths(engine)
+	assertOrdered(paths)
+	assert.Greater(t, extractFileNumber(paths[len(paths)-1]), extractFileNumber(paths[len(paths)-2]))
+	for i := 0; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("new_value%d", i), value)
+	}
+}
+
+func TestCompactionTrigger(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_trigger")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128), WithCompactionTrigger(2, 0.5))
+	require.NoError(t, err)
+	defer engine.Close()
+	for i := 0; i < 40; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+	require.Greater(t, len(engine.readLogs), 2)
+
+	// every record of a clean engine is live, so the compaction is not worth running
+	logs := len(engine.readLogs)
+	compacted, err := engine.maybeCompact(context.Background())
+	require.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Len(t, engine.readLogs, logs)
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 40; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", round)))
+		}
+	}
+	logs = len(engine.readLogs)
+	compacted, err = engine.maybeCompact(context.Background())
+	require.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Less(t, len(engine.readLogs), logs)
+
+	value, err := engine.Get("key7")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", value)
+
+	_, err = NewEngine(tempDir, WithCompactionTrigger(0, 0.5))
+	assert.Error(t, err)
+}
+
+func TestCompactionProgress(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_progress")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var processed, totals []int
+	var engine *Engine
+	progress := func(p, total int) {
+		// the callback doesn't hold the engine lock, so the engine can be used from it
+		_, err := engine.Exists("key0")
+		require.NoError(t, err)
+		processed = append(processed, p)
+		totals = append(totals, total)
+	}
+	engine, err = NewEngine(tempDir, WithMaxLogSize(128), WithCompactionProgress(progress))
+	require.NoError(t, err)
+	defer engine.Close()
+	for i := 0; i < 40; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+
+	keys := 0
+	for _, log := range engine.readLogs {
+		keys += len(log.index)
+	}
+	require.NoError(t, engine.Compact())
+
+	require.Len(t, processed, keys)
+	for i := range processed {
+		assert.Equal(t, i+1, processed[i])
+		assert.Equal(t, keys, totals[i])
+	}
+
+	_, err = NewEngine(tempDir, WithCompactionProgress(nil))
+	assert.Error(t, err)
+}
+
+func TestCompactionInGroups(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_in_groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128), WithCompactionGroupSize(2))
+	require.NoError(t, err)
+
+	// the keys are updated and deleted across the logs of different groups
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 30; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d_%d", i, round)))
+		}
+		for i := round; i < 30; i += 5 {
+			require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i)))
+		}
+	}
+	// only the keys deleted in the last round stay deleted
+	expected := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		if i%5 != 2 {
+			expected[fmt.Sprintf("key%d", i)] = fmt.Sprintf("value%d_2", i)
+		}
+	}
+	// the last deletions are moved from the write log to the read logs
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("filler%d", i)
+		require.NoError(t, engine.Put(key, "value"))
+		expected[key] = "value"
+	}
+	require.Greater(t, len(engine.readLogs), 4)
+
+	check := func(engine *Engine) {
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value, err := engine.Get(key)
+			if expectedValue, ok := expected[key]; ok {
+				require.NoError(t, err)
+				assert.Equal(t, expectedValue, value)
+			} else {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}
+	}
+
+	require.NoError(t, engine.Compact())
+	check(engine)
+
+	// every key has a single record left, and the deleted keys have none
+	seen := make(map[string]struct{})
+	for _, log := range engine.readLogs {
+		for key := range log.index {
+			_, ok := expected[key]
+			assert.True(t, ok, "deleted key %s found in compacted log %s", key, log.path)
+			_, ok = seen[key]
+			assert.False(t, ok, "key %s found in more than one compacted log", key)
+			seen[key] = struct{}{}
+		}
+	}
+	for key := range expected {
+		if strings.HasPrefix(key, "key") {
+			assert.Contains(t, seen, key)
+		}
+	}
+
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	check(engine)
+}
+
+func TestCompactionGroups(t *testing.T) {
+	logs := func(paths ...string) []*readLog {
+		var logs []*readLog
+		for _, path := range paths {
+			logs = append(logs, &readLog{path: path})
+		}
+		return logs
+	}
+	paths := func(groups [][]*readLog) [][]string {
+		var paths [][]string
+		for _, group := range groups {
+			var groupPaths []string
+			for _, log := range group {
+				groupPaths = append(groupPaths, log.path)
+			}
+			paths = append(paths, groupPaths)
+		}
+		return paths
+	}
+
+	// the logs with the same sequence number stay in the same group
+	groups := compactionGroups(logs("/data/1.dat", "/data/3_1-1.dat", "/data/3_2-1.dat", "/data/4.dat", "/data/5.dat"), 2)
+	assert.Equal(t, [][]string{
+		{"/data/1.dat", "/data/3_1-1.dat", "/data/3_2-1.dat"},
+		{"/data/4.dat", "/data/5.dat"},
+	}, paths(groups))
+
+	assert.Empty(t, compactionGroups(nil, 2))
+
+	_, err := NewEngine(t.TempDir(), WithCompactionGroupSize(0))
+	assert.Error(t, err)
+}
+
+func TestCompactionOfDeletedKeys(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_of_deleted_keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, engine.RotateLog())
+	writeLogPath := engine.writeLog.file.Name()
+
+	require.NoError(t, engine.Compact())
+
+	// nothing is left of the deleted keys but the empty write log
+	assert.Empty(t, engine.readLogs)
+	dataFiles, err := extractDatafiles(osFS{}, tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, dataFiles)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasSuffix(entry.Name(), dataFileFormatSuffix) && entry.Name() != filepath.Base(writeLogPath),
+			"%s is left in the data path", entry.Name())
+		assert.False(t, strings.HasSuffix(entry.Name(), hintFileFormatSuffix), "%s is left in the data path", entry.Name())
+	}
+
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	_, err = engine.Get("key1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, engine.Put("key1", "new_value"))
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	value, err := engine.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "new_value", value)
+	_, err = engine.Get("key2")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestBackupNamesAreInUTC(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "backup_names_utc_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// the clock is far behind UTC, the backup of the compaction must not look half a day old
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC-12", -12*60*60))}
+	engine, err := NewEngine(tempDir, WithClock(clock), WithBackupRetention(1, time.Hour))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Compact())
+
+	backups, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, clock.now.UTC().Format(compactionBackupTimeFormat), backups[0].Name())
+}
+
+func TestCompactLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compact_logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)}
+	engine, err := NewEngine(tempDir, WithClock(clock))
+	require.NoError(t, err)
+
+	// four logs, the two in the middle are compacted
+	writes := [][]string{
+		{"deleted", "value", "kept", "first"},
+		{"overwritten", "old", "kept", "second", "moved", "value"},
+		{"overwritten", "new", "deleted", "", "expired", "value"},
+		{"newest", "value", "moved", "newer"},
+	}
+	for _, log := range writes {
+		for i := 0; i < len(log); i += 2 {
+			if log[i+1] == "" {
+				require.NoError(t, engine.Delete(log[i]))
+			} else if log[i] == "expired" {
+				require.NoError(t, engine.PutWithTTL(log[i], log[i+1], time.Hour))
+			} else {
+				require.NoError(t, engine.Put(log[i], log[i+1]))
+			}
+		}
+		require.NoError(t, engine.RotateLog())
+	}
+	clock.advance(time.Hour)
+	require.Len(t, engine.readLogs, 4)
+	oldest, newest := *engine.readLogs[0], *engine.readLogs[3]
+
+	assert.Error(t, engine.CompactLogs(nil))
+	assert.Error(t, engine.CompactLogs([]string{engine.readLogs[0].path, engine.readLogs[2].path}))
+	assert.Error(t, engine.CompactLogs([]string{filepath.Join(tempDir, "missing.dat")}))
+
+	require.NoError(t, engine.CompactLogs([]string{engine.readLogs[2].path, engine.readLogs[1].path}))
+	require.Len(t, engine.readLogs, 3)
+	assert.Equal(t, oldest, *engine.readLogs[0])
+	assert.Equal(t, newest, *engine.readLogs[2])
+	// the deleted key is kept as deleted as the older log has a value of it
+	compacted := engine.readLogs[1]
+	var keys []string
+	for key := range compacted.index {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"overwritten", "kept", "moved", "deleted"}, keys)
+	assert.True(t, compacted.index["deleted"].tombstone)
+
+	check := func(engine *Engine) {
+		for key, expected := range map[string]string{"overwritten": "new", "kept": "second", "moved": "newer", "newest": "value"} {
+			value, err := engine.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value, key)
+		}
+		for _, key := range []string{"deleted", "expired"} {
+			_, err := engine.Get(key)
+			assert.ErrorIs(t, err, ErrKeyNotFound, key)
+		}
+	}
+	check(engine)
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithClock(clock))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.Len(t, engine.readLogs, 3)
+	check(engine)
+}
+
+// closeTrackingFS counts the file system operations made after the engine using it is closed
+type closeTrackingFS struct {
+	osFS
+	closed       atomic.Bool
+	lateAccesses atomic.Int64
+}
+
+func (fsys *closeTrackingFS) track() {
+	if fsys.closed.Load() {
+		fsys.lateAccesses.Add(1)
+	}
+}
+
+func (fsys *closeTrackingFS) Open(name string) (File, error) {
+	fsys.track()
+	return fsys.osFS.Open(name)
+}
+
+func (fsys *closeTrackingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fsys.track()
+	return fsys.osFS.OpenFile(name, flag, perm)
+}
+
+func (fsys *closeTrackingFS) Stat(name string) (os.FileInfo, error) {
+	fsys.track()
+	return fsys.osFS.Stat(name)
+}
+
+func (fsys *closeTrackingFS) Remove(name string) error {
+	fsys.track()
+	return fsys.osFS.Remove(name)
+}
+
+func (fsys *closeTrackingFS) Rename(oldpath, newpath string) error {
+	fsys.track()
+	return fsys.osFS.Rename(oldpath, newpath)
+}
+
+func (fsys *closeTrackingFS) ReadDir(name string) ([]os.DirEntry, error) {
+	fsys.track()
+	return fsys.osFS.ReadDir(name)
+}
+
+func (fsys *closeTrackingFS) MkdirAll(path string, perm os.FileMode) error {
+	fsys.track()
+	return fsys.osFS.MkdirAll(path, perm)
+}
+
+func TestCloseWaitsForBackgroundProcesses(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_close_waits_for_background_processes")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// backgroundGoroutines returns the stacks of the goroutines started by the engine
+	backgroundGoroutines := func() []string {
+		buffer := make([]byte, 1<<20)
+		var stacks []string
+		for _, stack := range strings.Split(string(buffer[:runtime.Stack(buffer, true)]), "\n\n") {
+			if strings.Contains(stack, "(*Engine).start") {
+				stacks = append(stacks, stack)
+			}
+		}
+		return stacks
+	}
+	// other tests may leave engines open, so only the goroutines of this engine are counted
+	before := len(backgroundGoroutines())
+	// every other goroutine started by the engine, e.g. for a compaction, a merge or a garbage collection
+	// run by the background processes, must be gone after close too
+	goroutinesBefore := runtime.NumGoroutine()
+
+	fsys := &closeTrackingFS{}
+	engine, err := NewEngine(tempDir, WithFileSystem(fsys), WithMaxLogSize(128),
+		WithBackgroundCompaction(time.Millisecond), WithSyncInterval(time.Millisecond),
+		WithGarbageCollection(time.Millisecond, 0.1))
+	require.NoError(t, err)
+	require.Len(t, backgroundGoroutines(), before+3)
+
+	// the engine is closed while the background processes are busy with the writes
+	for i := 0; i < 500; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i%20), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Close())
+	fsys.closed.Store(true)
+
+	// the goroutines only need to return from the functions they're done with
+	assert.Eventually(t, func() bool { return len(backgroundGoroutines()) == before }, time.Second, time.Millisecond,
+		"the background goroutines are still running after close")
+	// assert.Eventually checks the condition in a goroutine of its own, so the goroutines are waited for here
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutinesBefore && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutinesBefore, "goroutines are leaked by the engine after close")
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, fsys.lateAccesses.Load(), "the data path is accessed after close")
+	_, err = os.Stat(filepath.Join(tempDir, "compaction"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Codec compresses the values before they are written to the data files and decompresses them
+// when they are read back. The same codec must be used every time the data path is opened.
+type Codec interface {
+	Compress(data []byte) []byte
+	Decompress(data []byte) ([]byte, error)
+}
+
+// SnappyCodec compresses the values with snappy, which is much faster than gzip at the cost of a lower
+// compression ratio, so it suits the values read on the latency sensitive paths
+type SnappyCodec struct{}
+
+func (SnappyCodec) Compress(data []byte) []byte {
+	return snappy.Encode(nil, data)
+}
+
+func (SnappyCodec) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// GzipCodec compresses the values with gzip at the given level, a zero level uses gzip.DefaultCompression
+type GzipCodec struct {
+	Level int
+}
+
+func (c GzipCodec) Compress(data []byte) []byte {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		// an invalid level is reported by WithCompression, fall back to the default level otherwise
+		writer = gzip.NewWriter(&buf)
+	}
+	// writes to a bytes.Buffer never fail
+	_, _ = writer.Write(data)
+	_ = writer.Close()
+	return buf.Bytes()
+}
+
+func (c GzipCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// WithCompression compresses the values with the given codec. A value is only stored compressed if
+// it gets smaller, so incompressible values don't pay for the decompression on reads. Tombstones are
+// never compressed, and the records written before enabling the compression are read as they are.
+func WithCompression(codec Codec) OptionSetter {
+	return func(engine *Engine) error {
+		if codec == nil {
+			return fmt.Errorf("invalid compression codec")
+		}
+		if gzipCodec, ok := codec.(GzipCodec); ok && gzipCodec.Level != 0 {
+			if _, err := gzip.NewWriterLevel(io.Discard, gzipCodec.Level); err != nil {
+				return fmt.Errorf("invalid gzip compression level: %w", err)
+			}
+		}
+		engine.codec = codec
+		return nil
+	}
+}
+
+// compressRecord returns the record with its value compressed by the codec of the engine,
+// the record is returned as it is if the compression is disabled or doesn't make the value smaller
+func (e *Engine) compressRecord(rec record) record {
+	if e.codec == nil || rec.tombstone() {
+		return rec
+	}
+	compressed := e.codec.Compress([]byte(rec.value))
+	if len(compressed) >= len(rec.value) {
+		return rec
+	}
+	rec.value = string(compressed)
+	rec.flags |= flagCompressed
+	return rec
+}
+
+// decompressValue returns the decompressed value of a record, an encrypted value must be decrypted first
+func (e *Engine) decompressValue(rec record) (string, error) {
+	if rec.flags&flagCompressed == 0 {
+		return rec.value, nil
+	}
+	if e.codec == nil {
+		return "", fmt.Errorf("value of %s is compressed but no compression codec is configured", rec.key)
+	}
+	value, err := e.codec.Decompress([]byte(rec.value))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress value of %s: %w", rec.key, err)
+	}
+	return string(value), nil
+}
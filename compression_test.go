@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"gzip": GzipCodec{}, "snappy": SnappyCodec{}} {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			testCompressionRoundTrip(t, codec)
+		})
+	}
+}
+
+func testCompressionRoundTrip(t *testing.T, codec Codec) {
+	tempDir, err := os.MkdirTemp("", "compression_round_trip_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	compressible := strings.Repeat(`{"name":"gopher","tags":["go","storage"]}`, 100)
+	randomBytes := make([]byte, 4*KB)
+	_, err = rand.Read(randomBytes)
+	require.NoError(t, err)
+	incompressible := string(randomBytes)
+
+	engine, err := NewEngine(tempDir, WithCompression(codec))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("compressible", compressible))
+	require.NoError(t, engine.Put("incompressible", incompressible))
+	require.NoError(t, engine.Put("deleted", compressible))
+	require.NoError(t, engine.Delete("deleted"))
+
+	batch := engine.NewBatch()
+	batch.Put("batch", compressible)
+	require.NoError(t, batch.Commit())
+
+	// the compressible value is stored in much less space than its size
+	assert.Less(t, engine.writeLog.index["compressible"].size, int64(len(compressible)/5))
+	assert.Less(t, engine.writeLog.index["batch"].size, int64(len(compressible)/5))
+	// the incompressible value is stored as it is
+	assert.Equal(t, int64(recordHeaderSize(currentFormatVersion)+len("incompressible")+len(incompressible)), engine.writeLog.index["incompressible"].size)
+
+	verify := func(engine *Engine) {
+		for key, expected := range map[string]string{"compressible": compressible, "incompressible": incompressible, "batch": compressible} {
+			value, err := engine.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+		_, err := engine.Get("deleted")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	verify(engine)
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithCompression(codec))
+	require.NoError(t, err)
+	verify(engine)
+
+	// compaction keeps the values readable
+	require.NoError(t, engine.Compact())
+	verify(engine)
+	require.NoError(t, engine.Close())
+
+	// the compressed values can't be read without the codec
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	_, err = engine.Get("compressible")
+	assert.Error(t, err)
+	value, err := engine.Get("incompressible")
+	require.NoError(t, err)
+	assert.Equal(t, incompressible, value)
+	require.NoError(t, engine.Close())
+}
+
+func TestCompressionOfExistingData(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compression_of_existing_data_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	value := strings.Repeat("gopher", 100)
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("before", value))
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithCompression(GzipCodec{Level: 9}))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("after", value))
+	for _, key := range []string{"before", "after"} {
+		actual, err := engine.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, value, actual)
+	}
+	require.NoError(t, engine.Close())
+
+	_, err = NewEngine(tempDir, WithCompression(GzipCodec{Level: 42}))
+	assert.Error(t, err)
+	_, err = NewEngine(tempDir, WithCompression(nil))
+	assert.Error(t, err)
+}
+
+func TestSnappyCodec(t *testing.T) {
+	randomBytes := make([]byte, 4*KB)
+	_, err := rand.Read(randomBytes)
+	require.NoError(t, err)
+
+	codec := SnappyCodec{}
+	for _, data := range [][]byte{[]byte(strings.Repeat("gopher", 1000)), randomBytes, {}} {
+		decompressed, err := codec.Decompress(codec.Compress(data))
+		require.NoError(t, err)
+		assert.Equal(t, len(data), len(decompressed))
+		assert.Equal(t, string(data), string(decompressed))
+	}
+	_, err = codec.Decompress([]byte("not snappy"))
+	assert.Error(t, err)
+}
@@ -1,19 +1,164 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
 	dataFileFormatSuffix = ".dat"
 )
 
+// data file format versions, every data file except the legacy ones starts with a header
+// containing the magic bytes followed by a single byte representing the format version
+const (
+	// formatVersionLegacy is the format of the files written before the header was introduced
+	// records are stored as keySize|key|valueSize|value without any integrity check
+	formatVersionLegacy = 1
+	// formatVersionChecksum stores records as checksum|keySize|valueSize|key|value where the
+	// checksum is the CRC32C of everything following it in the record
+	formatVersionChecksum = 2
+	// formatVersionExpiry adds the expiry time of the record as unix nanoseconds after the sizes,
+	// checksum|keySize|valueSize|expiry|key|value, an expiry of zero means the record never expires
+	formatVersionExpiry = 3
+	// formatVersionFlags adds a byte of record flags after the expiry time,
+	// checksum|keySize|valueSize|expiry|flags|key|value
+	formatVersionFlags = 4
+	// formatVersionTombstoneFlag marks the deleted keys with flagTombstone and an empty value instead
+	// of the tombstone value, the records are stored the same way as in formatVersionFlags
+	formatVersionTombstoneFlag = 5
+	// formatVersionTags adds the size of the tags of the record after the flags and the tags between the
+	// key and the value, checksum|keySize|valueSize|expiry|flags|tagsSize|key|tags|value, every tag is
+	// stored as tagSize|tag
+	formatVersionTags = 6
+	// currentFormatVersion is the format used for all newly written data files
+	currentFormatVersion = formatVersionTags
+)
+
+// record flags stored in the flags byte of the record header
+const (
+	// flagBatch marks a record which is followed by more records of the same batch, the last record
+	// of a batch doesn't have the flag, so a batch without its last record is known to be incomplete
+	flagBatch byte = 1 << iota
+	// flagCompressed marks a record whose value is compressed with the codec of the engine
+	flagCompressed
+	// flagTombstone marks a record which deletes its key, the record doesn't have a value
+	flagTombstone
+	// flagEncrypted marks a record whose value is encrypted with the encryption key of the engine
+	flagEncrypted
+)
+
+const fileHeaderSize = 5
+
+var (
+	fileMagic = []byte("KSHK")
+	crcTable  = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// ErrCorruptRecord is returned when a record read from a data file doesn't match its checksum
+var ErrCorruptRecord = errors.New("corrupt record")
+
+// errRecordPastEnd is returned by readRecord for a record whose sizes make it go past the end of the
+// file, so a corrupted size is caught before the record is allocated. It's a corrupt record unless the
+// file is cut off by a crash while the record is appended to it.
+var errRecordPastEnd = fmt.Errorf("%w: record goes past the end of the file", ErrCorruptRecord)
+
+// partialRecordError is returned when a data file ends in the middle of a record or a batch, or with
+// a streamed record whose checksum is not filled in, which happens when the process crashes while
+// appending to the file
+type partialRecordError struct {
+	// offset represents the end of the last complete record in the file
+	offset int64
+	// err represents why the last record is not complete, e.g. ErrCorruptRecord, it's nil if the file
+	// ends in the middle of it
+	err error
+}
+
+func (e *partialRecordError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("partial record at offset %d: %v", e.offset, e.err)
+	}
+	return fmt.Sprintf("partial record at offset %d", e.offset)
+}
+
+func (e *partialRecordError) Unwrap() error {
+	return e.err
+}
+
+// record represents a single key-value pair stored in a data file
+type record struct {
+	key   string
+	value string
+	// expiry represents the time the record expires as unix nanoseconds, zero means no expiry
+	expiry int64
+	// flags represents the record flags
+	flags byte
+	// tags represents the tags the key is looked up with by KeysByTag
+	tags []string
+}
+
+// tombstoneRecord returns the record which deletes the key
+func tombstoneRecord(key string) record {
+	return record{key: key, flags: flagTombstone}
+}
+
+// tombstone reports whether the record deletes its key
+func (rec record) tombstone() bool {
+	return rec.flags&flagTombstone != 0
+}
+
+// recordHeaderSize returns the size of the fixed part of a record preceding the key and value
+func recordHeaderSize(version int) int {
+	switch version {
+	case formatVersionChecksum:
+		return 12
+	case formatVersionExpiry:
+		return 20
+	case formatVersionFlags, formatVersionTombstoneFlag:
+		return 21
+	default:
+		return 25
+	}
+}
+
+// encodeTags encodes the tags the way they are stored in a record
+func encodeTags(tags []string) []byte {
+	var data []byte
+	for _, tag := range tags {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(tag)))
+		data = append(data, tag...)
+	}
+	return data
+}
+
+// decodeTags decodes the tags stored in a record, no tags are returned as nil
+func decodeTags(data []byte) ([]string, error) {
+	var tags []string
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := binary.LittleEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(size) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		tags = append(tags, string(data[4:4+size]))
+		data = data[4+size:]
+	}
+	return tags, nil
+}
+
 func validatePathFormat(path string) error {
 	if path == "" || path[len(path)-1] != '/' {
 		return fmt.Errorf("path is mandatory and should end with a /")
@@ -21,11 +166,11 @@ func validatePathFormat(path string) error {
 	return nil
 }
 
-func ensureDataDirectoryExists(path string) error {
-	stat, err := os.Stat(path)
+func ensureDataDirectoryExists(fsys FS, path string, perm os.FileMode) error {
+	stat, err := fsys.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if err := os.MkdirAll(path, 0o755); err != nil {
+			if err := fsys.MkdirAll(path, perm); err != nil {
 				return err
 			} else {
 				return nil
@@ -44,14 +189,14 @@ func ensureTrailingSlash(path string) string {
 	return filepath.Clean(path) + string(filepath.Separator)
 }
 
-func validateWriteAccess(path string) error {
+func validateWriteAccess(fsys FS, path string) error {
 	testPath := filepath.Join(path, "test-access-file")
-	testFile, err := os.OpenFile(testPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	testFile, err := fsys.OpenFile(testPath, os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 
-	_, err = testFile.WriteString("test")
+	_, err = testFile.Write([]byte("test"))
 	if err != nil {
 		return err
 	}
@@ -61,7 +206,7 @@ func validateWriteAccess(path string) error {
 		return err
 	}
 
-	err = os.Remove(testPath)
+	err = fsys.Remove(testPath)
 	if err != nil {
 		return err
 	}
@@ -69,27 +214,81 @@ func validateWriteAccess(path string) error {
 	return nil
 }
 
-func validateDataPath(path string) error {
+// validateDataPath validates the data path of a writable engine, which is created if it doesn't exist
+// unless mustExist is set
+func validateDataPath(fsys FS, path string, dirMode os.FileMode, mustExist bool) error {
 	if err := validatePathFormat(path); err != nil {
 		return err
 	}
 
-	if err := ensureDataDirectoryExists(path); err != nil {
+	if mustExist {
+		if err := checkDataDirectoryExists(fsys, path); err != nil {
+			return err
+		}
+	} else if err := ensureDataDirectoryExists(fsys, path, dirMode); err != nil {
 		return err
 	}
 
-	if err := validateWriteAccess(path); err != nil {
+	if err := validateWriteAccess(fsys, path); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// extractDatafiles returns a list of data files in the given path
-// it's not recursive, it only returns the files in the given path
-func extractDatafiles(path string) ([]string, error) {
+// validateReadOnlyDataPath validates the data path of a read-only engine, which must already exist
+func validateReadOnlyDataPath(fsys FS, path string) error {
+	if err := validatePathFormat(path); err != nil {
+		return err
+	}
+
+	return checkDataDirectoryExists(fsys, path)
+}
+
+// checkDataDirectoryExists returns an error if the data path doesn't exist or is not a directory
+func checkDataDirectoryExists(fsys FS, path string) error {
+	stat, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("path is not a directory")
+	}
+
+	return nil
+}
+
+// extractDatafiles returns a list of data files in the given path and in its shard directories,
+// other directories are not visited
+func extractDatafiles(fsys FS, path string) ([]string, error) {
+	dataFiles, err := extractDatafilesInDir(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isShardDir(entry.Name()) {
+			continue
+		}
+		shardFiles, err := extractDatafilesInDir(fsys, filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		dataFiles = append(dataFiles, shardFiles...)
+	}
+
+	return dataFiles, nil
+}
+
+// extractDatafilesInDir returns a list of data files in the given directory
+// it's not recursive, it only returns the files in the given directory
+func extractDatafilesInDir(fsys FS, path string) ([]string, error) {
 	var dataFiles []string
-	entries, err := os.ReadDir(path)
+	entries, err := fsys.ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
@@ -112,26 +311,200 @@ func extractDatafiles(path string) ([]string, error) {
 	return dataFiles, err
 }
 
-func extractFileNumber(filename string) int {
-	filepath.Base(filename)
-	strings.TrimSuffix(filename, dataFileFormatSuffix)
-	num, err := strconv.Atoi(filename)
-	if err == nil {
-		return num
+// shardDirName returns the name of the shard directory the data file with the given sequence number
+// is placed in when the data files are spread over the given number of shards, e.g. 07
+func shardDirName(number, shards int) string {
+	return fmt.Sprintf("%02d", number%shards)
+}
+
+// isShardDir reports whether the directory name is the name of a shard directory
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := strconv.Atoi(name)
+	return err == nil
+}
+
+// parseFileName splits the name of the data file in path into its sequence number, part and
+// generation, e.g. 10, 2 and 1 for /data/10_2-1.dat. A new data file is named after its sequence
+// number only, a compaction names its output files after the newest compacted log with a part
+// for each output file, and a rewrite by the garbage collector adds a generation to the name.
+// The sequence number is -1 if the file name is not a number.
+func parseFileName(path string) (number, part, generation int) {
+	name := strings.TrimSuffix(filepath.Base(path), dataFileFormatSuffix)
+	name, generationName, found := strings.Cut(name, "-")
+	if found {
+		if num, err := strconv.Atoi(generationName); err == nil {
+			generation = num
+		}
+	}
+	name, partName, found := strings.Cut(name, "_")
+	if found {
+		if num, err := strconv.Atoi(partName); err == nil {
+			part = num
+		}
+	}
+	number, err := strconv.Atoi(name)
+	if err != nil {
+		number = -1
 	}
+	return number, part, generation
+}
 
-	return -1
+// dataFileName returns the name of the data file with the given sequence number, part and generation
+func dataFileName(number, part, generation int) string {
+	name := strconv.Itoa(number)
+	if part > 0 {
+		name += fmt.Sprintf("_%d", part)
+	}
+	if generation > 0 {
+		name += fmt.Sprintf("-%d", generation)
+	}
+	return name + dataFileFormatSuffix
 }
 
-func readDataFile(file *os.File) (string, error) {
+// extractFileNumber returns the sequence number of the data file in path, e.g. 10 for /data/10.dat
+// and /data/10_1-2.dat, it returns -1 if the file name is not a number
+func extractFileNumber(path string) int {
+	number, _, _ := parseFileName(path)
+	return number
+}
+
+// extractFileGeneration returns how many times the data file in path is rewritten by the garbage
+// collector, e.g. 2 for /data/10-2.dat, a data file which is never rewritten is generation 0
+func extractFileGeneration(path string) int {
+	_, _, generation := parseFileName(path)
+	return generation
+}
+
+// lessFileName reports whether the data file in path a is older than the one in path b,
+// the data files are ordered by their sequence number, then their part and then their generation
+func lessFileName(a, b string) bool {
+	aNumber, aPart, aGeneration := parseFileName(a)
+	bNumber, bPart, bGeneration := parseFileName(b)
+	if aNumber != bNumber {
+		return aNumber < bNumber
+	}
+	if aPart != bPart {
+		return aPart < bPart
+	}
+	return aGeneration < bGeneration
+}
+
+// nextGenerationPath returns the path of the rewrite of the data file in path, e.g. /data/10-1.dat
+// for /data/10.dat, so the rewrite keeps the position of the data file in the order of the logs
+func nextGenerationPath(path string) string {
+	number, part, generation := parseFileName(path)
+	return filepath.Join(filepath.Dir(path), dataFileName(number, part, generation+1))
+}
+
+// fileHeader returns the header written at the beginning of every new data file
+func fileHeader() []byte {
+	return append(append([]byte{}, fileMagic...), currentFormatVersion)
+}
+
+// readFileHeader reads the header of the data file and returns the format version of the file.
+// files without a header are legacy files, for them the file cursor is moved back to the beginning
+func readFileHeader(file File) (int, error) {
+	header := make([]byte, fileHeaderSize)
+	_, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if err != nil || !bytes.Equal(header[:len(fileMagic)], fileMagic) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return formatVersionLegacy, nil
+	}
+
+	version := int(header[len(fileMagic)])
+	if version < formatVersionChecksum || version > currentFormatVersion {
+		return 0, fmt.Errorf("unsupported data file format version %d", version)
+	}
+	return version, nil
+}
+
+// encodeRecord encodes the record in the current record format
+func encodeRecord(rec record) []byte {
+	headerSize := recordHeaderSize(currentFormatVersion)
+	tags := encodeTags(rec.tags)
+	buffer := make([]byte, headerSize+len(rec.key)+len(tags)+len(rec.value))
+	binary.LittleEndian.PutUint32(buffer[4:], uint32(len(rec.key)))
+	binary.LittleEndian.PutUint32(buffer[8:], uint32(len(rec.value)))
+	binary.LittleEndian.PutUint64(buffer[12:], uint64(rec.expiry))
+	buffer[20] = rec.flags
+	binary.LittleEndian.PutUint32(buffer[21:], uint32(len(tags)))
+	copy(buffer[headerSize:], rec.key)
+	copy(buffer[headerSize+len(rec.key):], tags)
+	copy(buffer[headerSize+len(rec.key)+len(tags):], rec.value)
+	binary.LittleEndian.PutUint32(buffer, crc32.Checksum(buffer[4:], crcTable))
+	return buffer
+}
+
+// readRecord reads a single record of a file with the given format version and verifies its checksum
+// it returns the record and the number of bytes read for the record, which is also returned with
+// ErrCorruptRecord so the next record can be found. The sizes in the header are not verified until
+// the record is read, so a record which takes more than the limit bytes left in the file returns
+// errRecordPastEnd before anything is allocated for it.
+func readRecord(reader io.Reader, version int, limit int64) (record, int64, error) {
+	header := make([]byte, recordHeaderSize(version))
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return record{}, 0, err
+	}
+
+	keySize := binary.LittleEndian.Uint32(header[4:])
+	valueSize := binary.LittleEndian.Uint32(header[8:])
+	var tagsSize uint32
+	if version >= formatVersionTags {
+		tagsSize = binary.LittleEndian.Uint32(header[21:])
+	}
+	size := int64(len(header)) + int64(keySize) + int64(tagsSize) + int64(valueSize)
+	if size > limit {
+		return record{}, size, errRecordPastEnd
+	}
+	data := make([]byte, size-int64(len(header)))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return record{}, 0, err
+	}
+
+	checksum := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, data)
+	if checksum != binary.LittleEndian.Uint32(header) {
+		return record{}, int64(len(header) + len(data)), ErrCorruptRecord
+	}
+
+	rec := record{key: string(data[:keySize]), value: string(data[int64(keySize)+int64(tagsSize):])}
+	if tagsSize > 0 {
+		tags, err := decodeTags(data[keySize : int64(keySize)+int64(tagsSize)])
+		if err != nil {
+			return record{}, int64(len(header) + len(data)), ErrCorruptRecord
+		}
+		rec.tags = tags
+	}
+	if version >= formatVersionExpiry {
+		rec.expiry = int64(binary.LittleEndian.Uint64(header[12:]))
+	}
+	if version >= formatVersionFlags {
+		rec.flags = header[20]
+	}
+
+	return rec, int64(len(header) + len(data)), nil
+}
+
+// readDataFile reads a length-prefixed field of a legacy data file
+func readDataFile(reader io.Reader) (string, error) {
 	var size uint32
-	err := binary.Read(file, binary.LittleEndian, &size)
+	err := binary.Read(reader, binary.LittleEndian, &size)
 	if err != nil {
 		return "", err
 	}
 
 	dataBuffer := make([]byte, size)
-	_, err = io.ReadFull(file, dataBuffer)
+	_, err = io.ReadFull(reader, dataBuffer)
 	if err != nil {
 		return "", err
 	}
@@ -139,57 +512,314 @@ func readDataFile(file *os.File) (string, error) {
 	return string(dataBuffer), nil
 }
 
-func readAtDataFile(file *os.File, offset int64) (string, error) {
-	_, err := file.Seek(offset, io.SeekStart)
+// readAtDataFile reads the record at the given offset of a data file with the given format version.
+// It uses positioned reads instead of seeking so the same file handle can be shared between
+// concurrent readers. For legacy files the offset points to the value size, otherwise it points
+// to the beginning of the record and the record checksum is verified before returning the value.
+func readAtDataFile(file io.ReaderAt, path string, offset int64, version int) (record, error) {
+	reader := io.NewSectionReader(file, offset, math.MaxInt64-offset)
+
+	if version == formatVersionLegacy {
+		value, err := readDataFile(reader)
+		return record{value: value}, err
+	}
+
+	size, err := fileSize(file)
 	if err != nil {
-		return "", err
+		return record{}, err
+	}
+	rec, _, err := readRecord(reader, version, size-offset)
+	if errors.Is(err, ErrCorruptRecord) {
+		return record{}, fmt.Errorf("%w at offset %d of %s", err, offset, path)
 	}
-	return readDataFile(file)
+	return rec, err
 }
 
-func openAndReadAtDataFile(path string, offset int64) (string, error) {
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+// recordReadAhead represents the number of bytes read for a record whose size is not known, so the
+// records which fit in it are read with a single positioned read
+const recordReadAhead = 4 * KB
+
+// readWholeRecordAt reads the record at the given offset of a data file with the given format version
+// like readAtDataFile, but with a single positioned read for its header, key, tags and value. The
+// size of the record is taken from the index, a record which turns out to be larger than size, e.g.
+// as its size is not known, is completed with a second read. Legacy files are not supported as their
+// index doesn't point to the key.
+func readWholeRecordAt(file io.ReaderAt, path string, offset, size int64, version int) (record, error) {
+	headerSize := recordHeaderSize(version)
+	buffer := make([]byte, max(size, int64(headerSize)))
+	n, err := file.ReadAt(buffer, offset)
+	if n < len(buffer) && (err != io.EOF || n < headerSize) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return record{}, err
+	}
+
+	total := int64(headerSize) + int64(binary.LittleEndian.Uint32(buffer[4:])) + int64(binary.LittleEndian.Uint32(buffer[8:]))
+	if version >= formatVersionTags {
+		total += int64(binary.LittleEndian.Uint32(buffer[21:]))
+	}
+	if total > int64(n) {
+		// a record which goes past the end of the file is detected before it's allocated
+		end := offset + int64(n)
+		if n == len(buffer) {
+			if end, err = fileSize(file); err != nil {
+				return record{}, err
+			}
+		}
+		if offset+total > end {
+			return record{}, fmt.Errorf("%w at offset %d of %s", errRecordPastEnd, offset, path)
+		}
+		buffer = append(buffer[:n], make([]byte, total-int64(n))...)
+		if _, err := file.ReadAt(buffer[n:], offset+int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return record{}, err
+		}
+	}
+
+	rec, _, err := readRecord(bytes.NewReader(buffer[:total]), version, total)
+	if errors.Is(err, ErrCorruptRecord) {
+		return record{}, fmt.Errorf("%w at offset %d of %s", err, offset, path)
+	}
+	return rec, err
+}
+
+// errEncodedValue is returned by readValueInto for a value which has to be decoded before it's copied
+var errEncodedValue = errors.New("value is compressed or encrypted")
+
+// recordPrefixPool holds the buffers the header, key and tags of a record are read into by readValueInto
+var recordPrefixPool = sync.Pool{New: func() any {
+	buffer := make([]byte, 0, recordReadAhead)
+	return &buffer
+}}
+
+// readValueInto reads the value of the record at the given offset of a data file with the given
+// format version straight into dst and returns its size. The header is read first, so a value which
+// doesn't fit in dst returns a ShortBufferError before anything else is read, and a compressed or
+// encrypted value returns errEncodedValue. The sizes in the header must add up to the size of the
+// record in the index. The checksum is verified over the value in dst, which may be overwritten even
+// if the record turns out to be corrupt. Legacy files are not supported.
+func readValueInto(file io.ReaderAt, path string, offset, size int64, version int, dst []byte) (int, error) {
+	buffer := recordPrefixPool.Get().(*[]byte)
+	defer recordPrefixPool.Put(buffer)
+
+	headerSize := recordHeaderSize(version)
+	header := (*buffer)[:headerSize]
+	if err := readFullAt(file, header, offset); err != nil {
+		return 0, err
+	}
+	keySize := binary.LittleEndian.Uint32(header[4:])
+	valueSize := binary.LittleEndian.Uint32(header[8:])
+	var tagsSize uint32
+	if version >= formatVersionTags {
+		tagsSize = binary.LittleEndian.Uint32(header[21:])
+	}
+	if int64(headerSize)+int64(keySize)+int64(tagsSize)+int64(valueSize) != size {
+		return 0, fmt.Errorf("%w at offset %d of %s: record size doesn't match the index", ErrCorruptRecord, offset, path)
+	}
+	if version >= formatVersionFlags && header[20]&(flagCompressed|flagEncrypted) != 0 {
+		return 0, errEncodedValue
+	}
+	if int64(valueSize) > int64(len(dst)) {
+		return 0, &ShortBufferError{Size: int(valueSize)}
+	}
+
+	prefixSize := int64(headerSize) + int64(keySize) + int64(tagsSize)
+	if int64(cap(*buffer)) < prefixSize {
+		grown := make([]byte, prefixSize)
+		copy(grown, header)
+		*buffer = grown[:0]
+	}
+	prefix := (*buffer)[:prefixSize]
+	if err := readFullAt(file, prefix[headerSize:], offset+int64(headerSize)); err != nil {
+		return 0, err
+	}
+	value := dst[:valueSize]
+	if err := readFullAt(file, value, offset+prefixSize); err != nil {
+		return 0, err
+	}
+
+	checksum := crc32.Update(crc32.Checksum(prefix[4:], crcTable), crcTable, value)
+	if checksum != binary.LittleEndian.Uint32(prefix) {
+		return 0, fmt.Errorf("%w at offset %d of %s", ErrCorruptRecord, offset, path)
+	}
+	return len(value), nil
+}
+
+// readFullAt fills p from the given offset of file, a file which ends before p is filled returns
+// io.ErrUnexpectedEOF
+func readFullAt(file io.ReaderAt, p []byte, offset int64) error {
+	n, err := file.ReadAt(p, offset)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF || err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// fileSize returns the size of the data file read through file, which bounds the sizes of its records.
+// The size of a reader which is not a file is not known, so its records are not bounded.
+func fileSize(file io.ReaderAt) (int64, error) {
+	statter, ok := file.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return math.MaxInt64, nil
+	}
+	stat, err := statter.Stat()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	defer file.Close()
+	return stat.Size(), nil
+}
 
-	value, err := readAtDataFile(file, offset)
+// openAndReadAtDataFile reads the record at the given offset of the file in path using a file handle
+// from the cache, the handle is opened lazily on the first read from the file. Files which are not
+// written anymore are immutable and can be memory mapped by the cache.
+func openAndReadAtDataFile(cache *fileCache, path string, offset int64, version int, immutable bool) (record, error) {
+	acquire := cache.acquire
+	if immutable {
+		acquire = cache.acquireMapped
+	}
+	cf, err := acquire(path)
 	if err != nil {
-		return "", err
+		return record{}, err
 	}
+	defer cache.release(cf)
 
-	return value, nil
+	return readAtDataFile(cf, path, offset, version)
 }
 
-func extractKeysFromDataFile(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
+// scanRecords reads the records of the data file from the current position to the end of the file
+// and calls fn for every record with the offset which is kept in the index for it and the number of
+// bytes the record takes in the file. If the file ends in the middle of a record or a batch a
+// partialRecordError is returned, the records of an incomplete batch are never passed to fn. So is a
+// last record whose checksum is left empty by a streamed write, which is returned as ErrCorruptRecord
+// too. Any other record whose checksum doesn't match is a corruption and returns ErrCorruptRecord.
+func scanRecords(file File, version int, fn func(rec record, offset, size int64) error) error {
+	position, err := file.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	end := stat.Size()
+	reader := bufio.NewReader(file)
+
+	type scannedRecord struct {
+		rec          record
+		offset, size int64
+	}
+	var batch []scannedRecord
 
-	var keys []string
 	for {
-		// Read key size and key
-		key, err := readDataFile(file)
-		if err == io.EOF {
-			break // End of file reached
+		if version == formatVersionLegacy {
+			key, err := readDataFile(reader)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading key: %w", err)
+			}
+			// the index of legacy files points to the value size
+			offset := position + 4 + int64(len(key))
+
+			value, err := readDataFile(reader)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading value: %w", err)
+			}
+			size := offset + 4 + int64(len(value)) - position
+			position += size
+
+			if err := fn(record{key: key, value: value}, offset, size); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rec, size, err := readRecord(reader, version, end-position)
+		// a record which goes past the end of the file is cut off by a crash like a partial header
+		if err == errRecordPastEnd {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if len(batch) > 0 {
+				return &partialRecordError{offset: batch[0].offset}
+			}
+			if err == io.ErrUnexpectedEOF {
+				return &partialRecordError{offset: position}
+			}
+			return nil
+		}
+		// the records streamed before the values were staged got their checksum after their value, so
+		// a crash in between left the last record of the file with an empty checksum. Any other record
+		// whose checksum doesn't match is corrupted after it was written.
+		if err == ErrCorruptRecord && position+size == end {
+			unfinished, checkErr := emptyChecksum(file, position)
+			if checkErr != nil {
+				return fmt.Errorf("error reading record at offset %d: %w", position, checkErr)
+			}
+			if unfinished {
+				if len(batch) > 0 {
+					return &partialRecordError{offset: batch[0].offset, err: err}
+				}
+				return &partialRecordError{offset: position, err: err}
+			}
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error reading key: %w", err)
+			return fmt.Errorf("error reading record at offset %d: %w", position, err)
 		}
 
-		keys = append(keys, key)
-
-		// Read value size and skip the value
-		_, err = readDataFile(file)
-		if err == io.EOF {
-			break // End of file reached
+		// records of a batch are only passed to fn once the whole batch is read
+		batch = append(batch, scannedRecord{rec: rec, offset: position, size: size})
+		position += size
+		if rec.flags&flagBatch != 0 {
+			continue
 		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading value: %w", err)
+		for _, scanned := range batch {
+			if err := fn(scanned.rec, scanned.offset, scanned.size); err != nil {
+				return err
+			}
 		}
+		batch = batch[:0]
+	}
+}
+
+// emptyChecksum reports whether the checksum of the record at the given offset of the file is empty
+func emptyChecksum(file io.ReaderAt, offset int64) (bool, error) {
+	checksum := make([]byte, 4)
+	if _, err := file.ReadAt(checksum, offset); err != nil {
+		return false, err
+	}
+	return binary.LittleEndian.Uint32(checksum) == 0, nil
+}
+
+func extractKeysFromDataFile(fsys FS, filePath string) ([]string, error) {
+	file, err := fsys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	version, err := readFileHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = scanRecords(file, version, func(rec record, _, _ int64) error {
+		keys = append(keys, rec.key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return keys, nil
 }
@@ -1,8 +1,17 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,7 +44,7 @@ func TestEnsureDataDirectoryExists(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	path := filepath.Join(tempDir, "data/")
-	err = ensureDataDirectoryExists(path)
+	err = ensureDataDirectoryExists(osFS{}, path, defaultDirMode)
 	require.NoError(t, err, "Failed to ensure directory exists: %v", err)
 
 	_, err = os.Stat(path)
@@ -44,12 +53,67 @@ func TestEnsureDataDirectoryExists(t *testing.T) {
 	assert.True(t, isDir(path), "Path is not a directory")
 }
 
+func TestFileAndDirModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+	tempDir, err := os.MkdirTemp("", "test_modes")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	dataDir := filepath.Join(tempDir, "data")
+
+	engine, err := NewEngine(dataDir, WithFileMode(0o600), WithDirMode(0o700), WithShards(2), WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+
+	err = filepath.WalkDir(dataDir, func(path string, entry os.DirEntry, err error) error {
+		require.NoError(t, err)
+		info, err := entry.Info()
+		require.NoError(t, err)
+		if entry.IsDir() {
+			assert.Equal(t, os.FileMode(0o700), info.Mode().Perm(), "Unexpected mode of directory %s", path)
+		} else {
+			assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "Unexpected mode of file %s", path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = NewEngine(dataDir, WithFileMode(0o400))
+	assert.Error(t, err)
+	_, err = NewEngine(dataDir, WithDirMode(0o600))
+	assert.Error(t, err)
+}
+
+func TestMustExist(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_must_exist")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "data")
+
+	_, err = NewEngine(path, WithMustExist(true))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.False(t, isDir(path), "Expected the data path not to be created")
+
+	engine, err := NewEngine(path, WithMustExist(false))
+	require.NoError(t, err)
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(path, WithMustExist(true))
+	require.NoError(t, err)
+	require.NoError(t, engine.Close())
+}
+
 func TestValidateWriteAccess(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "test_storage")
 	require.NoError(t, err)
 	defer os.RemoveAll(tempDir)
 
-	err = validateWriteAccess(tempDir + "/")
+	err = validateWriteAccess(osFS{}, tempDir+"/")
 	assert.NoError(t, err, "Failed to test write access: %v", err)
 }
 
@@ -63,7 +127,7 @@ func TestDataFileExists(t *testing.T) {
 	_, err = dataFile.Write([]byte("test"))
 	require.NoError(t, err, "Failed to write to test .dat file: %v", err)
 
-	files, err := extractDatafiles(tempDir)
+	files, err := extractDatafiles(osFS{}, tempDir)
 	exists := len(files) > 0
 	require.NoError(t, err, "Failed to check if data file exists: %v", err)
 	assert.True(t, exists, "Expected data file to exist")
@@ -78,7 +142,7 @@ func TestDataFileNotExists(t *testing.T) {
 	_, err = os.Create(dataFilePath)
 	require.NoError(t, err, "Failed to create test .dat file: %v", err)
 
-	files, err := extractDatafiles(tempDir)
+	files, err := extractDatafiles(osFS{}, tempDir)
 	exists := len(files) > 0
 	require.NoError(t, err, "Failed to check if data file exists: %v", err)
 	assert.False(t, exists, "Expected data file to exist")
@@ -91,3 +155,339 @@ func isDir(path string) bool {
 	}
 	return stat.IsDir()
 }
+
+func TestEncodeAndReadRecord(t *testing.T) {
+	rec := record{key: "key", value: "value", expiry: 42}
+	encoded := encodeRecord(rec)
+
+	readRec, size, err := readRecord(bytes.NewReader(encoded), currentFormatVersion, int64(len(encoded)))
+	require.NoError(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, int64(len(encoded)), size)
+}
+
+func TestReadCorruptRecord(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_corrupt_record")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("other", "badger"))
+
+	// flip the last byte of the value of the first record
+	offset := engine.writeLog.index["name"].offset + int64(recordHeaderSize(currentFormatVersion)+len("name")+len("gopher")) - 1
+	file, err := os.OpenFile(engine.writeLog.file.Name(), os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	buffer := make([]byte, 1)
+	_, err = file.ReadAt(buffer, offset)
+	require.NoError(t, err)
+	buffer[0] ^= 0xff
+	_, err = file.WriteAt(buffer, offset)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = engine.Get("name")
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+
+	value, err := engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+
+	dataFilePath := engine.writeLog.file.Name()
+	require.NoError(t, engine.Close())
+
+	// the index is loaded from the hint file without reading the records, the checksum is verified on read
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	_, err = engine.Get("name")
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+	require.NoError(t, engine.Close())
+
+	require.NoError(t, os.Remove(hintFilePath(dataFilePath)))
+	_, err = NewEngine(tempDir)
+	assert.ErrorIs(t, err, ErrCorruptRecord, "Expected the corrupted record to be detected while loading the index")
+}
+
+func TestCorruptRecordSizeIsNotAllocated(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_corrupt_record_size")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("other", "badger"))
+	require.NoError(t, engine.RotateLog())
+
+	// the value size of the first record is corrupted to the largest size which can be stored
+	log := engine.readLogs[len(engine.readLogs)-1]
+	file, err := os.OpenFile(log.path, os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteAt(binary.LittleEndian.AppendUint32(nil, math.MaxUint32), log.index["name"].offset+8)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = engine.Get("name")
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+	_, err = engine.GetInto("name", make([]byte, 16))
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+	err = engine.ForEach(func(string, string) error { return nil })
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(MB), "Expected the corrupted size not to be allocated")
+
+	_, err = engine.Verify()
+	require.NoError(t, err)
+	value, err := engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+}
+
+// Test for reading the records of the same file handle from many goroutines, the positioned reads
+// don't share a cursor so every reader gets its own record
+func TestReadAtDataFileConcurrently(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_read_at_concurrently")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	const records = 200
+	data := fileHeader()
+	offsets := make([]int64, records)
+	for i := 0; i < records; i++ {
+		offsets[i] = int64(len(data))
+		data = append(data, encodeRecord(record{key: fmt.Sprintf("key%d", i), value: fmt.Sprintf("value%d", i)})...)
+	}
+	path := filepath.Join(tempDir, "1"+dataFileFormatSuffix)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	legacyPath := filepath.Join(tempDir, "2"+dataFileFormatSuffix)
+	legacyFile, err := os.Create(legacyPath)
+	require.NoError(t, err)
+	legacyOffsets := make([]int64, records)
+	for i := 0; i < records; i++ {
+		offset, err := legacyFile.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		// the index of a legacy file points to the value size after the key
+		legacyOffsets[i] = offset + 4 + int64(len(fmt.Sprintf("key%d", i)))
+		writeLegacyRecord(t, legacyFile, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	require.NoError(t, legacyFile.Close())
+
+	for _, tc := range []struct {
+		path    string
+		version int
+		offsets []int64
+	}{
+		{path: path, version: currentFormatVersion, offsets: offsets},
+		{path: legacyPath, version: formatVersionLegacy, offsets: legacyOffsets},
+	} {
+		file, err := os.Open(tc.path)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for reader := 0; reader < 8; reader++ {
+			wg.Add(1)
+			go func(reader int) {
+				defer wg.Done()
+				for i := 0; i < records; i++ {
+					// every reader goes through the records in a different order
+					index := (i*7 + reader*31) % records
+					rec, err := readAtDataFile(file, tc.path, tc.offsets[index], tc.version)
+					if assert.NoError(t, err) {
+						assert.Equal(t, fmt.Sprintf("value%d", index), rec.value)
+					}
+				}
+			}(reader)
+		}
+		wg.Wait()
+		require.NoError(t, file.Close())
+	}
+}
+
+func TestReadLegacyDataFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_legacy_data_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	legacyFile, err := os.Create(filepath.Join(tempDir, "1"+dataFileFormatSuffix))
+	require.NoError(t, err)
+	writeLegacyRecord(t, legacyFile, "name", "gopher")
+	writeLegacyRecord(t, legacyFile, "other", "badger")
+	require.NoError(t, legacyFile.Close())
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.Len(t, engine.readLogs, 1)
+	assert.Equal(t, formatVersionLegacy, engine.readLogs[0].version)
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	// new records are written in the current format next to the legacy ones
+	require.NoError(t, engine.Put("other", "otter"))
+	value, err = engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "otter", value)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestTombstoneValueInOlderFormat(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_tombstone_value_older_format")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// before the tombstone flag the deleted keys were marked with the tombstone value
+	data := append(append([]byte{}, fileMagic...), formatVersionFlags)
+	data = append(data, encodeFlagsRecord(record{key: "deleted", value: "value"})...)
+	data = append(data, encodeFlagsRecord(record{key: "deleted", value: "removed"})...)
+	data = append(data, encodeFlagsRecord(record{key: "kept", value: "value"})...)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "1"+dataFileFormatSuffix), data, 0o644))
+
+	engine, err := NewEngine(tempDir, WithTombStone("removed"))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.Len(t, engine.readLogs, 1)
+	assert.Equal(t, formatVersionFlags, engine.readLogs[0].version)
+
+	_, err = engine.Get("deleted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	value, err := engine.Get("kept")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// in the current format the tombstone value is an ordinary value
+	require.NoError(t, engine.Put("deleted", "removed"))
+	value, err = engine.Get("deleted")
+	require.NoError(t, err)
+	assert.Equal(t, "removed", value)
+}
+
+// encodeFlagsRecord encodes the record in formatVersionFlags, which doesn't have the tags of the record
+func encodeFlagsRecord(rec record) []byte {
+	encoded := encodeRecord(rec)
+	headerSize := recordHeaderSize(formatVersionFlags)
+	encoded = append(encoded[:headerSize], encoded[recordHeaderSize(currentFormatVersion):]...)
+	binary.LittleEndian.PutUint32(encoded, crc32.Checksum(encoded[4:], crcTable))
+	return encoded
+}
+
+// writeLegacyRecord writes a key-value pair in the legacy format without header and checksum
+func writeLegacyRecord(t *testing.T, file *os.File, key, value string) {
+	require.NoError(t, binary.Write(file, binary.LittleEndian, uint32(len(key))))
+	_, err := file.Write([]byte(key))
+	require.NoError(t, err)
+	require.NoError(t, binary.Write(file, binary.LittleEndian, uint32(len(value))))
+	_, err = file.Write([]byte(value))
+	require.NoError(t, err)
+}
+
+func TestExtractFileNumber(t *testing.T) {
+	tests := []struct {
+		path       string
+		number     int
+		generation int
+	}{
+		{"/data/2.dat", 2, 0},
+		{"/data/10.dat", 10, 0},
+		{"10.dat", 10, 0},
+		{"/data/10-3.dat", 10, 3},
+		{"/data/10_2-3.dat", 10, 3},
+		{"/data/compaction.dat", -1, 0},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.number, extractFileNumber(test.path), "Unexpected number for path '%s'", test.path)
+		assert.Equal(t, test.generation, extractFileGeneration(test.path), "Unexpected generation for path '%s'", test.path)
+	}
+	assert.Equal(t, filepath.Join("/data", "10-1.dat"), nextGenerationPath("/data/10.dat"))
+	assert.Equal(t, filepath.Join("/data", "10-4.dat"), nextGenerationPath("/data/10-3.dat"))
+	assert.Equal(t, filepath.Join("/data", "10_2-4.dat"), nextGenerationPath("/data/10_2-3.dat"))
+	assert.True(t, lessFileName("/data/10_1-1.dat", "/data/10_2-1.dat"))
+	assert.True(t, lessFileName("/data/10_2-1.dat", "/data/11.dat"))
+	assert.Greater(t, extractFileNumber("/data/10.dat"), extractFileNumber("/data/2.dat"))
+}
+
+func TestShardedLayout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_sharded_layout")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// the logs written before sharding stay in the data path itself
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "flat"))
+	}
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(64), WithShards(4))
+	require.NoError(t, err)
+	for i := 5; i < 40; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "sharded"))
+	}
+	require.NoError(t, engine.Put("key0", "updated"))
+	require.NoError(t, engine.Close())
+
+	for shard := 0; shard < 4; shard++ {
+		shardFiles, err := extractDatafilesInDir(osFS{}, filepath.Join(tempDir, shardDirName(shard, 4)))
+		require.NoError(t, err)
+		assert.NotEmpty(t, shardFiles, "Expected data files in shard %d", shard)
+		for _, path := range shardFiles {
+			assert.Equal(t, shard, extractFileNumber(path)%4)
+		}
+	}
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(64), WithShards(4))
+	require.NoError(t, err)
+	defer engine.Close()
+	assertValues := func() {
+		value, err := engine.Get("key0")
+		require.NoError(t, err)
+		assert.Equal(t, "updated", value)
+		for i := 1; i < 40; i++ {
+			expected := "flat"
+			if i >= 5 {
+				expected = "sharded"
+			}
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+	}
+	assertValues()
+
+	require.NoError(t, engine.Compact())
+	assertValues()
+}
+
+func TestNewestValueAfterRestartWithManyLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_restart_many_logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(2), WithAllowTinyLogs(true))
+	require.NoError(t, err)
+
+	// every record gets its own log, so the key is overwritten across more than ten logs
+	for i := 0; i < 12; i++ {
+		require.NoError(t, engine.Put("key", strconv.Itoa(i)))
+	}
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(2), WithAllowTinyLogs(true))
+	require.NoError(t, err)
+
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "11", value)
+
+	require.NoError(t, engine.Close())
+}
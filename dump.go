@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DumpedRecord represents a record of a data file as it's written by DumpLog
+type DumpedRecord struct {
+	Key string `json:"key"`
+	// ValueLen represents the size of the value as it's stored, i.e. after the compression
+	ValueLen int64 `json:"valueLen"`
+	// Offset represents where the record starts in the data file
+	Offset    int64    `json:"offset"`
+	Tombstone bool     `json:"tombstone"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// DumpLog writes a JSON object for every record of the data file in path to w, one per line, e.g. for
+// inspection tools. The values are skipped without being read into memory, so the records are not
+// checked against their checksum. The records which delete their key in the data files written before
+// the tombstone flag are recognized by the default tombstone value. It only reads the data file, so it
+// can be used on the data path of a running engine.
+func DumpLog(path string, w io.Writer) error {
+	return dumpLog(osFS{}, path, w)
+}
+
+func dumpLog(fsys FS, path string, w io.Writer) error {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	version, err := readFileHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	offset := int64(0)
+	if version != formatVersionLegacy {
+		offset = fileHeaderSize
+	}
+
+	reader := bufio.NewReader(file)
+	encoder := json.NewEncoder(w)
+	for {
+		rec, size, err := dumpRecord(reader, version)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read %s: %w", path, &partialRecordError{offset: offset})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read record at offset %d of %s: %w", offset, path, err)
+		}
+
+		rec.Offset = offset
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+		offset += size
+	}
+}
+
+// dumpRecord reads the next record of a file with the given format version skipping its value, and
+// returns it with the number of bytes it takes in the file
+func dumpRecord(reader *bufio.Reader, version int) (DumpedRecord, int64, error) {
+	var keySize, valueSize, tagsSize uint32
+	var flags byte
+	var header []byte
+	if version == formatVersionLegacy {
+		header = make([]byte, 4)
+	} else {
+		header = make([]byte, recordHeaderSize(version))
+	}
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return DumpedRecord{}, 0, err
+	}
+	if version == formatVersionLegacy {
+		keySize = binary.LittleEndian.Uint32(header)
+	} else {
+		keySize = binary.LittleEndian.Uint32(header[4:])
+		valueSize = binary.LittleEndian.Uint32(header[8:])
+		if version >= formatVersionFlags {
+			flags = header[20]
+		}
+		if version >= formatVersionTags {
+			tagsSize = binary.LittleEndian.Uint32(header[21:])
+		}
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return DumpedRecord{}, 0, unexpectedEOF(err)
+	}
+	tags := make([]byte, tagsSize)
+	if _, err := io.ReadFull(reader, tags); err != nil {
+		return DumpedRecord{}, 0, unexpectedEOF(err)
+	}
+	size := int64(len(header)) + int64(keySize) + int64(tagsSize)
+	if version == formatVersionLegacy {
+		if err := binary.Read(reader, binary.LittleEndian, &valueSize); err != nil {
+			return DumpedRecord{}, 0, unexpectedEOF(err)
+		}
+		size += 4
+	}
+	size += int64(valueSize)
+
+	rec := DumpedRecord{Key: string(key), ValueLen: int64(valueSize), Tombstone: flags&flagTombstone != 0}
+	tagList, err := decodeTags(tags)
+	if err != nil {
+		return DumpedRecord{}, 0, err
+	}
+	rec.Tags = tagList
+	// the values of the data files written before the tombstone flag are only read if they can be the tombstone value
+	if version < formatVersionTombstoneFlag && int(valueSize) == len(defaultTombstone) {
+		value := make([]byte, valueSize)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return DumpedRecord{}, 0, unexpectedEOF(err)
+		}
+		rec.Tombstone = string(value) == defaultTombstone
+		return rec, size, nil
+	}
+	if _, err := reader.Discard(int(valueSize)); err != nil {
+		return DumpedRecord{}, 0, unexpectedEOF(err)
+	}
+	return rec, size, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF for the reads in the middle of a record
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_dump_log")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("large", strings.Repeat("x", 100000)))
+	require.NoError(t, engine.Delete("name"))
+	batch := engine.NewBatch()
+	batch.Put("first", "1")
+	batch.Put("second", "2")
+	require.NoError(t, batch.Commit())
+	path := engine.writeLog.file.Name()
+	require.NoError(t, engine.Close())
+
+	var buffer bytes.Buffer
+	require.NoError(t, DumpLog(path, &buffer))
+
+	var records []DumpedRecord
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var rec DumpedRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, records, 5)
+	assert.Equal(t, DumpedRecord{Key: "name", ValueLen: 6, Offset: fileHeaderSize}, records[0])
+	assert.Equal(t, DumpedRecord{Key: "large", ValueLen: 100000, Offset: fileHeaderSize + int64(recordHeaderSize(currentFormatVersion)) + 4 + 6}, records[1])
+	assert.Equal(t, "name", records[2].Key)
+	assert.True(t, records[2].Tombstone)
+	assert.Zero(t, records[2].ValueLen)
+
+	// the offsets are the ones the index points to
+	log, err := extractReadLog(osFS{}, path, defaultTombstone, 0)
+	require.NoError(t, err)
+	for _, rec := range records[1:] {
+		assert.Equal(t, log.index[rec.Key].offset, rec.Offset, rec.Key)
+	}
+
+	// a partial record at the end of the file is reported
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, stat.Size()-1))
+	var partialErr *partialRecordError
+	assert.ErrorAs(t, DumpLog(path, &bytes.Buffer{}), &partialErr)
+}
+
+func TestDumpLegacyLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_dump_legacy_log")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "1"+dataFileFormatSuffix)
+	legacyFile, err := os.Create(path)
+	require.NoError(t, err)
+	writeLegacyRecord(t, legacyFile, "name", "gopher")
+	writeLegacyRecord(t, legacyFile, "name", defaultTombstone)
+	require.NoError(t, legacyFile.Close())
+
+	var buffer bytes.Buffer
+	require.NoError(t, DumpLog(path, &buffer))
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"key":"name","valueLen":6,"offset":0,"tombstone":false}`, lines[0])
+	assert.JSONEq(t, `{"key":"name","valueLen":39,"offset":18,"tombstone":true}`, lines[1])
+}
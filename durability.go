@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// syncManager controls when the write log is flushed to the disk.
+// By default records are only synced when the write log is rotated or the engine is closed, which
+// gives the best write throughput but a crash can lose the records written since the last sync.
+type syncManager struct {
+	// writes enables syncing the write log after every write before returning to the caller
+	writes bool
+	// interval enables syncing the write log in the background on the given interval
+	interval time.Duration
+	ticker   *time.Ticker
+	// stop is closed to signal the background sync goroutine to exit
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startBackgroundSync starts syncing the write log on the configured interval
+func (e *Engine) startBackgroundSync() {
+	e.syncManager.ticker = time.NewTicker(e.syncManager.interval)
+	e.syncManager.stop = make(chan struct{})
+	e.syncManager.wg.Add(1)
+	go func() {
+		defer e.syncManager.wg.Done()
+		for {
+			select {
+			case <-e.syncManager.stop:
+				return
+			case <-e.syncManager.ticker.C:
+				if err := e.syncWriteLog(); err != nil {
+					slog.Warn("failed to sync the write log", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackgroundSync stops the background sync and waits for an in-flight sync to finish
+func (e *Engine) stopBackgroundSync() {
+	if e.syncManager.ticker != nil {
+		e.syncManager.ticker.Stop()
+		close(e.syncManager.stop)
+		e.syncManager.wg.Wait()
+		e.syncManager.ticker = nil
+	}
+}
+
+// SyncNow flushes the buffered writes and syncs the write log to the disk, so every write made before
+// it survives a crash. It's meant for checkpoints at known safe points without closing the engine.
+// Writes need the write lock, so holding the read lock is enough to sync all of them while reads continue.
+func (e *Engine) SyncNow() error {
+	return e.syncWriteLog()
+}
+
+// syncWriteLog flushes the current write log to the disk
+func (e *Engine) syncWriteLog() error {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+	// the write log of a read-only engine doesn't have a file
+	if e.writeLog.file == nil {
+		return nil
+	}
+	return e.writeLog.sync()
+}
+
+// syncDirs syncs the directories of the given paths when every write is synced, so the files created
+// or renamed in them are not lost on a crash even though their content is already synced
+func (e *Engine) syncDirs(paths ...string) error {
+	if !e.syncManager.writes {
+		return nil
+	}
+	synced := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if _, ok := synced[dir]; ok {
+			continue
+		}
+		synced[dir] = struct{}{}
+		if err := syncDir(e.fs, dir); err != nil {
+			return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// crashEngine drops the engine without closing it, like a process that was killed,
+// so the next engine can open the same path
+func crashEngine(t *testing.T, engine *Engine) {
+	require.NoError(t, releaseFlock(engine.lockFile))
+	require.NoError(t, engine.fileCache.close())
+}
+
+func TestSyncWritesSurviveReopen(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sync_writes_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithSyncWrites(true), WithMaxLogSize(128))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Delete("key0"))
+	crashEngine(t, engine)
+
+	engine, err = NewEngine(tempDir, WithSyncWrites(true), WithMaxLogSize(128))
+	require.NoError(t, err)
+	_, err = engine.Get("key0")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	for i := 1; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+	require.NoError(t, engine.Close())
+}
+
+func TestSyncInterval(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sync_interval_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithSyncInterval(0))
+	require.Error(t, err)
+
+	engine, err := NewEngine(tempDir, WithSyncInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	time.Sleep(50 * time.Millisecond)
+	crashEngine(t, engine)
+
+	engine, err = NewEngine(tempDir, WithSyncInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// the background sync stops with the engine
+	require.NoError(t, engine.Close())
+	assert.Nil(t, engine.syncManager.ticker)
+}
+
+func TestBufferedWritesAreReadable(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "buffered_writes_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	// the records are still in the buffer
+	stat, err := os.Stat(engine.writeLog.file.Name())
+	require.NoError(t, err)
+	assert.Zero(t, stat.Size())
+
+	for i := 0; i < 10; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+	stat, err = os.Stat(engine.writeLog.file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, engine.writeLog.size, stat.Size())
+
+	// the buffer is flushed when the engine is closed
+	require.NoError(t, engine.Put("last", "value"))
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+	value, err := engine.Get("last")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func BenchmarkPut(b *testing.B) {
+	benchmarkPut(b)
+}
+
+func BenchmarkPutWithWriteBuffer(b *testing.B) {
+	benchmarkPut(b, WithWriteBufferSize(64*KB))
+}
+
+func benchmarkPut(b *testing.B, options ...OptionSetter) {
+	tempDir, err := os.MkdirTemp("", "benchmark_put")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, options...)
+	require.NoError(b, err)
+	defer engine.Close()
+
+	value := string(make([]byte, 100))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, engine.Put(fmt.Sprintf("key%d", i), value))
+	}
+}
+
+func TestSyncNow(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sync_now_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.SyncNow())
+	crashEngine(t, engine)
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	for i := 0; i < 10; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+}
+
+// dirSyncRecordingFS is the file system of the operating system which records the synced directories
+type dirSyncRecordingFS struct {
+	osFS
+	lock   sync.Mutex
+	synced []string
+}
+
+func (f *dirSyncRecordingFS) SyncDir(name string) error {
+	f.lock.Lock()
+	f.synced = append(f.synced, filepath.Clean(name))
+	f.lock.Unlock()
+	return f.osFS.SyncDir(name)
+}
+
+func (f *dirSyncRecordingFS) reset() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	synced := f.synced
+	f.synced = nil
+	return synced
+}
+
+func TestSyncWritesSyncDirectories(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sync_dirs_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	fsys := &dirSyncRecordingFS{}
+	engine, err := NewEngine(tempDir, WithFileSystem(fsys))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.RotateLog())
+	assert.Empty(t, fsys.reset(), "the directories are only synced with WithSyncWrites")
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithFileSystem(fsys), WithSyncWrites(true))
+	require.NoError(t, err)
+	defer engine.Close()
+	fsys.reset()
+	require.NoError(t, engine.Put("key", "new"))
+	require.NoError(t, engine.RotateLog())
+	assert.Contains(t, fsys.reset(), filepath.Clean(tempDir))
+
+	require.NoError(t, engine.Compact())
+	backups, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	synced := fsys.reset()
+	assert.Contains(t, synced, filepath.Clean(tempDir))
+	assert.Contains(t, synced, filepath.Join(tempDir, compactionBackupDir, backups[0].Name()))
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "new", value)
+
+	// the garbage collector syncs the directory of the new generation before removing the old one
+	require.NoError(t, engine.Put("other", "value"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("other", "newer"))
+	fsys.reset()
+	require.NoError(t, engine.collectGarbage(context.Background(), 0.1))
+	assert.Contains(t, fsys.reset(), filepath.Clean(tempDir))
+	value, err = engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
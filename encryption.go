@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryption is returned when an encrypted value can't be authenticated with the encryption key
+// of the engine, e.g. the data path is opened with another key or the value is tampered with
+var ErrDecryption = errors.New("failed to decrypt value")
+
+// WithEncryption encrypts the values at rest with AES-GCM using the given key, which must be 16, 24
+// or 32 bytes long to select AES-128, AES-192 or AES-256. Every value is sealed with its own random
+// nonce, which is stored in front of the encrypted value, and the key of the record is authenticated
+// with it, so a value can't be moved to another key unnoticed. Only the values are encrypted, the
+// keys and the tags are stored in plaintext since the index and the hint files are built from them,
+// so they must not hold secrets. Tombstones are known from the flag of their record and are never
+// encrypted. The records written before enabling the encryption are read as they are, and reading
+// an encrypted value with a wrong key fails with ErrDecryption. The values are compressed before
+// they are encrypted, since encrypted data doesn't compress. Export writes the decrypted values, while
+// Backup copies the encrypted data files. The nonce and the tag take 28 bytes of the size a record
+// can hold, so the largest value is that much smaller than without the encryption.
+func WithEncryption(key []byte) OptionSetter {
+	return func(engine *Engine) error {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+		engine.aead = aead
+		return nil
+	}
+}
+
+// encodeValue returns the record with its value compressed and encrypted as it's stored in the data files
+func (e *Engine) encodeValue(rec record) (record, error) {
+	rec = e.compressRecord(rec)
+	if e.aead == nil || rec.tombstone() {
+		return rec, nil
+	}
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(rec.value)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return record{}, fmt.Errorf("failed to generate nonce for %s: %w", rec.key, err)
+	}
+	rec.value = string(e.aead.Seal(nonce, nonce, []byte(rec.value), []byte(rec.key)))
+	rec.flags |= flagEncrypted
+	return rec, nil
+}
+
+// decodeValue returns the original value of a record read from a data file, it's decrypted before
+// it's decompressed
+func (e *Engine) decodeValue(rec record) (string, error) {
+	if rec.flags&flagEncrypted != 0 {
+		if e.aead == nil {
+			return "", fmt.Errorf("value of %s is encrypted but no encryption key is configured", rec.key)
+		}
+		nonceSize := e.aead.NonceSize()
+		if len(rec.value) < nonceSize {
+			return "", fmt.Errorf("%w of %s: value is shorter than the nonce", ErrDecryption, rec.key)
+		}
+		value, err := e.aead.Open(nil, []byte(rec.value[:nonceSize]), []byte(rec.value[nonceSize:]), []byte(rec.key))
+		if err != nil {
+			return "", fmt.Errorf("%w of %s: %v", ErrDecryption, rec.key, err)
+		}
+		rec.value = string(value)
+	}
+	return e.decompressValue(rec)
+}
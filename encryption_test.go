@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "encryption_round_trip_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithEncryption([]byte("short")))
+	assert.Error(t, err)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	secret := strings.Repeat("secret", 100)
+	engine, err := NewEngine(tempDir, WithEncryption(key), WithCompression(GzipCodec{}))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("secret", secret))
+	require.NoError(t, engine.Put("empty", ""))
+	require.NoError(t, engine.PutReader("streamed", strings.NewReader(secret), int64(len(secret))))
+	require.NoError(t, engine.Put("deleted", secret))
+	require.NoError(t, engine.Delete("deleted"))
+	batch := engine.NewBatch()
+	batch.Put("batch", secret)
+	require.NoError(t, batch.Commit())
+	require.NoError(t, engine.RotateLog())
+
+	verify := func(engine *Engine) {
+		for key, expected := range map[string]string{"secret": secret, "empty": "", "streamed": secret, "batch": secret} {
+			value, err := engine.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+		reader, err := engine.GetReader("streamed")
+		require.NoError(t, err)
+		value, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, secret, string(value))
+		_, err = engine.Get("deleted")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	verify(engine)
+	require.NoError(t, engine.Close())
+
+	// the values are not stored in plaintext while the keys are
+	dataFiles, err := filepath.Glob(filepath.Join(tempDir, "*"+dataFileFormatSuffix))
+	require.NoError(t, err)
+	require.NotEmpty(t, dataFiles)
+	for _, dataFile := range dataFiles {
+		data, err := os.ReadFile(dataFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secretsecret")
+	}
+
+	engine, err = NewEngine(tempDir, WithEncryption(key), WithCompression(GzipCodec{}))
+	require.NoError(t, err)
+	verify(engine)
+	// compaction keeps the values readable
+	require.NoError(t, engine.Compact())
+	verify(engine)
+	require.NoError(t, engine.Close())
+}
+
+func TestEncryptionWithWrongKey(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "encryption_wrong_key_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithEncryption(bytes.Repeat([]byte{1}, 16)))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.Delete("deleted"))
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithEncryption(bytes.Repeat([]byte{2}, 16)))
+	require.NoError(t, err)
+	_, err = engine.Get("key")
+	assert.ErrorIs(t, err, ErrDecryption)
+	// the tombstones are known without decrypting anything
+	_, err = engine.Get("deleted")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	require.NoError(t, engine.Close())
+
+	// the encrypted values can't be read without the key
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	_, err = engine.Get("key")
+	assert.Error(t, err)
+}
+
+func TestEncryptedValueSizeLimit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "encrypted_value_size_limit_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithEncryption(bytes.Repeat([]byte{7}, 32)), WithMaxValueSize(math.MaxInt64))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// the nonce and the tag added by the encryption must still fit in the size of the record
+	limit := int64(math.MaxUint32 - engine.aead.NonceSize() - engine.aead.Overhead())
+	err = engine.PutReader("key", strings.NewReader(""), limit+1)
+	var sizeErr *SizeError
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Equal(t, limit, sizeErr.Limit)
+	assert.NoError(t, engine.validateValueSize(limit))
+}
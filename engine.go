@@ -2,13 +2,19 @@
 package storage
 
 import (
-	"encoding/binary"
+	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
-	"golang.org/x/sys/unix"
 	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,9 +31,63 @@ const (
 	defaultTombstone          = "tombstone-jbc46-q42fd-pggmc-kp38y-6mqd8"
 	defaultLogSize            = 10 * MB
 	defaultKeySize            = 1 * KB
+	defaultValueSize          = 64 * MB
 	defaultCompactionInterval = 1 * time.Hour
+	defaultCompactionGroup    = 8
+	defaultMaxOpenFiles       = 64
+	defaultFileMode           = os.FileMode(0o644)
+	defaultDirMode            = os.FileMode(0o755)
 )
 
+// tinyLogSize represents the max log size below which a log can hardly hold more than one record, so
+// every write creates a new log and a lookup of a missing key has to search one log per record
+const tinyLogSize = 64
+
+var (
+	// ErrKeyNotFound is returned when the key doesn't exist, is deleted or is expired
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrEngineClosed is returned when the engine is used after it's closed
+	ErrEngineClosed = errors.New("engine is closed")
+	// ErrReadOnly is returned when a read-only engine is written to
+	ErrReadOnly = errors.New("engine is read-only")
+	// ErrQuotaExceeded is returned when a write would grow the data files over the limit set with WithMaxDiskBytes
+	ErrQuotaExceeded = errors.New("disk quota exceeded")
+	// ErrTooLarge is matched by the SizeError returned for a key or a value which is over its size limit
+	ErrTooLarge = errors.New("too large")
+)
+
+// SizeError is returned when a key or a value is larger than its size limit, errors.Is matches it with ErrTooLarge
+type SizeError struct {
+	// Kind represents what is too large, it's either "key" or "value"
+	Kind string
+	// Actual represents the size of the key or the value in bytes
+	Actual int64
+	// Limit represents the max size of the key or the value in bytes
+	Limit int64
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes is longer than %d bytes", e.Kind, e.Actual, e.Limit)
+}
+
+func (e *SizeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// ShortBufferError is returned by GetInto when the buffer can't hold the value, errors.Is matches it with io.ErrShortBuffer
+type ShortBufferError struct {
+	// Size represents the size of the value in bytes, which is the size the buffer needs
+	Size int
+}
+
+func (e *ShortBufferError) Error() string {
+	return fmt.Sprintf("short buffer: value of %d bytes doesn't fit in the buffer", e.Size)
+}
+
+func (e *ShortBufferError) Is(target error) bool {
+	return target == io.ErrShortBuffer
+}
+
 // Engine represents the storage engine for key-value storage
 type Engine struct {
 	// logs represents the list of log file and index for the storage engine
@@ -39,21 +99,31 @@ type Engine struct {
 	// if the key is not found in the storage we have to recursively search all the log files to find the key from the
 	// latest to the oldest log file so inorder to reduce the number of log files we can increase the max log size
 	maxLogBytes int64
+	// allowTinyLogs silences the warning about a max log size smaller than tinyLogSize, e.g. in the tests
+	// which rotate the write log on every write
+	allowTinyLogs bool
 	// maxKeyBytes represents the max size of the key in bytes if the key exceeds this size an error will be returned
 	// and the state of the storage engine will not be changed. Since all the keys are stored in the in-memory index
 	// it's better to keep the key size small to reduce the memory footprint of the storage engine and practically have
 	// more keys in the storage engine
 	maxKeyBytes int64
-	// represents the tombstone value for the storage engine which a special value used to mark a key as deleted
-	// the key will still be part of the index and the value will be set to the tombstone value which later will be
-	// picked up by the garbage collector and removed from the index also the compaction process will remove the key
-	// from all the other log files
+	// maxValueBytes represents the max size of the value in bytes, it's independent of the max log size
+	// so a log can be kept small without limiting the size of the values
+	maxValueBytes int64
+	// represents the tombstone value which marks a key as deleted in the data files written before the
+	// tombstone flag, newer data files mark the deleted keys with a flag in the record, so any value
+	// including the tombstone value can be stored
 	tombStone string
 	// represents the path where the data files will be stored if the path doesn't exist it will be created
 	dataPath string
 	// represents the file used to lock the storage engine for writing
 	// this lock makes sure only one process can write to the storage engine at a time
-	lockFile *os.File
+	lockFile File
+	// lockFilePath represents the path of the lock file, empty keeps it in the data path
+	lockFilePath string
+	// lockTimeout represents how long opening the engine waits for the lock of the data path held by
+	// another engine, zero fails immediately with ErrLocked
+	lockTimeout time.Duration
 	// represents the lock for the storage engine to ensure only one process can write to the storage engine at a time
 	lock sync.RWMutex
 	// writeLog represents the current log file and index for the storage engine
@@ -65,33 +135,115 @@ type Engine struct {
 	options []OptionSetter
 	// compactionManager handles all compaction-related processes
 	compactionManager *compactionManager
+	// maxOpenFiles represents the max number of read file handles kept open by the file cache
+	maxOpenFiles int
+	// keepAllFilesOpen opens the handles of all the read logs up front and keeps them open until they are removed
+	keepAllFilesOpen bool
+	// readOnly opens the data path with a shared lock so multiple engines can read it, writes are rejected
+	readOnly bool
+	// mmapReads enables memory mapping the read logs, so reading a value doesn't need a syscall
+	mmapReads bool
+	// fileCache keeps the recently used read file handles open, so reading a value doesn't need to
+	// open and close the log file on every read
+	fileCache *fileCache
+	// shards represents the number of shard directories the data files are spread over, zero keeps
+	// all the data files in the data path itself
+	shards int
+	// writeBufferSize represents the size of the buffer the writes are collected in before they are
+	// written to the write log file, zero writes every record to the file directly
+	writeBufferSize int
+	// valueCache keeps the recently read values in memory, it's nil unless WithValueCache is used
+	valueCache *valueCache
+	// closed is set when the engine is closed, it's protected by lock
+	closed bool
+	// syncManager controls when the written records are flushed to the disk
+	syncManager *syncManager
+	// gcManager runs the garbage collector which rewrites the read logs with many dead records
+	gcManager *gcManager
+	// watchManager publishes the changes made to the keys to the watchers
+	watchManager *watchManager
+	// snapshotManager keeps track of the open snapshots, which postpone the removal of the data files
+	snapshotManager *snapshotManager
+	// codec compresses the values before they are written, nil means the values are stored as they are
+	codec Codec
+	// aead encrypts the values before they are written, nil means the values are stored in plaintext
+	aead cipher.AEAD
+	// meta represents the sequence number of the last data file created by the engine and the
+	// configuration of the data path, it's persisted in the meta file so the data files are numbered
+	// in the order they are created across restarts
+	meta meta
+	// overrideConfig replaces the configuration recorded in the data path instead of failing on a mismatch
+	overrideConfig bool
+	// keyCount represents the number of distinct keys whose latest record is not a tombstone,
+	// it's updated on every write so it can be read without going through the indexes
+	keyCount int
+	// fs represents the file system the data files are kept in, it's the file system of the operating
+	// system unless WithFileSystem is used
+	fs FS
+	// mustExist fails opening the engine if the data path doesn't exist instead of creating it
+	mustExist bool
+	// maxDiskBytes represents the max total size of the data files the writes can grow them to, zero means no limit
+	maxDiskBytes int64
+	// expectedKeys represents the number of keys the indexes are allocated for when the data path is loaded
+	expectedKeys int
+	// tags represents the keys of every tag of the latest records
+	tags *tagIndex
+	// clock represents the source of the current time, it's the clock of the operating system unless WithClock is used
+	clock Clock
+	// histogramBounds represents the upper bounds of the buckets of SizeHistogram, nil uses defaultHistogramBounds
+	histogramBounds []int64
+	// keyNormalizer maps the keys to the form they are stored and looked up with, nil keeps them as they are
+	keyNormalizer func(string) string
+	// namespace represents the subdirectory of the given path the engine keeps its files in, empty uses the path itself
+	namespace string
+	// observer receives the latency and the outcome of the operations, it's a no-op unless WithObserver is used
+	observer Observer
+	// fileMode represents the permissions the files are created with
+	fileMode os.FileMode
+	// dirMode represents the permissions the directories are created with
+	dirMode os.FileMode
+	// bloomBitsPerKey represents the size of the bloom filters of the read logs, zero disables them
+	bloomBitsPerKey int
+	// retryPolicy retries the file system calls which fail with a transient error, nil attempts them once
+	retryPolicy *retryPolicy
+	// bulkLoading is set while BulkLoad is running, the other writes fail with ErrBulkLoad meanwhile
+	bulkLoading atomic.Bool
+	// bloomSkips represents the number of read logs skipped by their bloom filter in the lookups
+	bloomSkips atomic.Uint64
+	// stagedValues represents the number of the values staged by PutReader, it names their staging files
+	stagedValues atomic.Int64
 }
 
 // NewEngine creates a new Engine instance with default settings which can be overridden with optional settings
 // path is where the data files will be stored if the path doesn't exist it will be created
-// the user should have write access to the path otherwise an error will be returned
-func NewEngine(path string, options ...OptionSetter) (*Engine, error) {
+// the user should have write access to the path otherwise an error will be returned, unless it's opened
+// with WithReadOnly or OpenForRead
+func NewEngine(path string, options ...OptionSetter) (_ *Engine, err error) {
 	path = ensureTrailingSlash(path)
-	if err := validateDataPath(path); err != nil {
-		return nil, err
-	}
-
-	lockFile, err := createFlock(path)
-	if err != nil {
-		return nil, err
-	}
 
 	engine := &Engine{
-		maxLogBytes: defaultLogSize,
-		maxKeyBytes: defaultKeySize,
-		tombStone:   defaultTombstone,
-		dataPath:    path,
-		lockFile:    lockFile,
-		options:     options,
+		maxLogBytes:   defaultLogSize,
+		maxKeyBytes:   defaultKeySize,
+		maxValueBytes: defaultValueSize,
+		tombStone:     defaultTombstone,
+		dataPath:      path,
+		fs:            osFS{},
+		observer:      noopObserver{},
+		clock:         realClock{},
+		fileMode:      defaultFileMode,
+		dirMode:       defaultDirMode,
+		options:       options,
+		maxOpenFiles:  defaultMaxOpenFiles,
 		compactionManager: &compactionManager{
-			enabled:  false,
-			interval: defaultCompactionInterval,
+			enabled:   false,
+			interval:  defaultCompactionInterval,
+			groupSize: defaultCompactionGroup,
+			versions:  1,
 		},
+		syncManager:     &syncManager{},
+		gcManager:       &gcManager{},
+		watchManager:    &watchManager{watchers: make(map[int]chan ChangeEvent)},
+		snapshotManager: &snapshotManager{},
 	}
 
 	for _, option := range options {
@@ -99,25 +251,91 @@ func NewEngine(path string, options ...OptionSetter) (*Engine, error) {
 			return nil, err
 		}
 	}
+	if engine.maxLogBytes < tinyLogSize && !engine.allowTinyLogs {
+		slog.Warn("max log size is too small, almost every write creates a new log, use WithAllowTinyLogs if it's intended",
+			"maxLogSize", engine.maxLogBytes, "minLogSize", tinyLogSize)
+	}
+	if engine.retryPolicy != nil {
+		engine.fs = retryFS{fs: engine.fs, policy: engine.retryPolicy}
+	}
+	if engine.namespace != "" {
+		path = ensureTrailingSlash(filepath.Join(path, engine.namespace))
+		engine.dataPath = path
+	}
+
+	if engine.readOnly {
+		err = validateReadOnlyDataPath(engine.fs, path)
+	} else {
+		err = validateDataPath(engine.fs, path, engine.dirMode, engine.mustExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lockPath := path + lockFileName
+	if engine.lockFilePath != "" {
+		lockPath = engine.lockFilePath
+	}
+	engine.lockFile, err = createFlock(engine.fs, lockPath, engine.readOnly, engine.fileMode, engine.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// release the lock if the engine can't be created, so the path can be opened again
+	defer func() {
+		if err != nil {
+			_ = releaseFlock(engine.lockFile)
+		}
+	}()
+
+	if engine.keepAllFilesOpen {
+		engine.fileCache = newFileCache(engine.fs, 0)
+	} else {
+		engine.fileCache = newFileCache(engine.fs, engine.maxOpenFiles)
+	}
+	engine.fileCache.mmap = engine.mmapReads
 
-	dataFiles, err := extractDatafiles(path)
+	dataFiles, err := extractDatafiles(engine.fs, path)
 	if err != nil {
 		return nil, err
 	}
 
-	readLogs, err := initReadLogs(dataFiles)
+	// the options are validated before the data files are read with them
+	if err := engine.loadMeta(dataFiles); err != nil {
+		return nil, err
+	}
+
+	readLogs, err := initReadLogs(engine.fs, dataFiles, engine.tombStone, engine.readOnly, engine.indexCapacity(len(dataFiles)))
 	if err != nil {
 		return nil, err
 	}
 
+	engine.setBloomFilters(readLogs)
+	engine.openReadLogs(readLogs)
 	engine.readLogs = readLogs
+	engine.keyCount = engine.countKeys()
+
+	// a read-only engine has an empty write log without a file, so it never has to be checked for writes
+	engine.writeLog = &writeLog{index: make(map[string]indexEntry)}
+	engine.buildTagIndex()
+	if engine.readOnly {
+		return engine, nil
+	}
 
+	if err := removeStagedValues(engine.fs, path); err != nil {
+		return nil, fmt.Errorf("failed to remove staged values: %w", err)
+	}
 	file, err := engine.createNewFile()
 	if err != nil {
 		return nil, err
 	}
+	engine.writeLog = newWriteLog(file, engine.writeBufferSize, engine.indexCapacity(len(engine.readLogs)+1))
 
-	engine.writeLog = &writeLog{file: file, index: make(map[string]int64)}
+	if engine.syncManager.interval > 0 {
+		engine.startBackgroundSync()
+	}
+	if engine.gcManager.interval > 0 {
+		engine.startGarbageCollection()
+	}
 
 	// start background compaction process if enabled
 	if engine.compactionManager.enabled {
@@ -132,7 +350,8 @@ func NewEngine(path string, options ...OptionSetter) (*Engine, error) {
 
 type OptionSetter func(*Engine) error
 
-// WithMaxLogSize sets the max size of the log file
+// WithMaxLogSize sets the max size of the log file, a size smaller than 64 bytes logs a warning unless
+// WithAllowTinyLogs is set
 func WithMaxLogSize(size int64) OptionSetter {
 	return func(e *Engine) error {
 		if size <= 0 {
@@ -144,7 +363,17 @@ func WithMaxLogSize(size int64) OptionSetter {
 	}
 }
 
-// WithMaxKeySize sets the max size of the key
+// WithAllowTinyLogs allows a max log size smaller than 64 bytes without a warning. Such a size rotates
+// the write log on almost every write, which is useful to test the rotation but makes the lookups
+// search thousands of logs in production.
+func WithAllowTinyLogs(allowed bool) OptionSetter {
+	return func(e *Engine) error {
+		e.allowTinyLogs = allowed
+		return nil
+	}
+}
+
+// WithMaxKeySize sets the max size of the key in bytes, a multibyte UTF-8 character counts as all its bytes
 func WithMaxKeySize(size int64) OptionSetter {
 	return func(e *Engine) error {
 		if size <= 0 {
@@ -156,7 +385,20 @@ func WithMaxKeySize(size int64) OptionSetter {
 	}
 }
 
-// WithTombStone sets the tombstone value
+// WithMaxValueSize sets the max size of the value
+func WithMaxValueSize(size int64) OptionSetter {
+	return func(e *Engine) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid max value size")
+		}
+		e.maxValueBytes = size
+
+		return nil
+	}
+}
+
+// WithTombStone sets the tombstone value the deleted keys are marked with in the data files written
+// before the tombstone flag, it's only needed to read such data files written with a custom value
 func WithTombStone(value string) OptionSetter {
 	return func(engine *Engine) error {
 		if value == "" {
@@ -168,6 +410,300 @@ func WithTombStone(value string) OptionSetter {
 	}
 }
 
+// WithConfigOverride replaces the configuration recorded in the data path, e.g. the tombstone value
+// and the max key size, with the options of the engine instead of failing with ErrConfigMismatch.
+// It's meant for intentional migrations, the existing records are not rewritten, so e.g. the
+// records deleted with the old tombstone value in the data files written before the tombstone flag
+// are read as values with the new one.
+func WithConfigOverride() OptionSetter {
+	return func(e *Engine) error {
+		e.overrideConfig = true
+
+		return nil
+	}
+}
+
+// WithFileSystem keeps the data files in the given file system instead of the file system of the
+// operating system, e.g. NewMemFS for tests which don't touch the disk. The data path is opened in
+// the given file system, the engines sharing a file system other than the one of the operating system
+// don't lock the data path against each other.
+func WithFileSystem(fsys FS) OptionSetter {
+	return func(e *Engine) error {
+		if fsys == nil {
+			return fmt.Errorf("invalid file system")
+		}
+		e.fs = fsys
+
+		return nil
+	}
+}
+
+// WithFileMode sets the permissions the data files and the other files in the data path are created
+// with, e.g. 0o600 to keep them private to the user, the default is 0o644. The permissions are
+// masked by the umask of the process.
+func WithFileMode(mode os.FileMode) OptionSetter {
+	return func(e *Engine) error {
+		if mode&^os.ModePerm != 0 || mode&0o600 != 0o600 {
+			return fmt.Errorf("invalid file mode")
+		}
+		e.fileMode = mode
+
+		return nil
+	}
+}
+
+// WithDirMode sets the permissions the data path and the directories in it are created with, e.g.
+// 0o700 to keep them private to the user, the default is 0o755. The permissions are masked by the
+// umask of the process.
+func WithDirMode(mode os.FileMode) OptionSetter {
+	return func(e *Engine) error {
+		if mode&^os.ModePerm != 0 || mode&0o700 != 0o700 {
+			return fmt.Errorf("invalid directory mode")
+		}
+		e.dirMode = mode
+
+		return nil
+	}
+}
+
+// WithMustExist fails opening the engine if the data path doesn't exist instead of creating it, e.g.
+// when the data path must be provisioned beforehand with the right ownership. A read-only engine
+// always requires an existing data path.
+func WithMustExist(enabled bool) OptionSetter {
+	return func(e *Engine) error {
+		e.mustExist = enabled
+
+		return nil
+	}
+}
+
+// WithMaxDiskBytes makes the writes fail with ErrQuotaExceeded instead of growing the data files of the
+// engine over limit bytes, as reported by DiskUsage. The deletes are always written, so the keys can
+// be deleted and the space reclaimed by a compaction, which is allowed to run over the limit.
+func WithMaxDiskBytes(limit int64) OptionSetter {
+	return func(e *Engine) error {
+		if limit <= 0 {
+			return fmt.Errorf("invalid max disk size")
+		}
+		e.maxDiskBytes = limit
+
+		return nil
+	}
+}
+
+// WithHistogramBuckets sets the inclusive upper bounds of the buckets SizeHistogram bins the sizes into,
+// the sizes larger than the last bound are counted in an extra bucket. The bounds must be increasing.
+func WithHistogramBuckets(bounds ...int64) OptionSetter {
+	return func(e *Engine) error {
+		if len(bounds) == 0 || bounds[0] < 0 {
+			return fmt.Errorf("invalid histogram buckets")
+		}
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] <= bounds[i-1] {
+				return fmt.Errorf("invalid histogram buckets")
+			}
+		}
+		e.histogramBounds = slices.Clone(bounds)
+
+		return nil
+	}
+}
+
+// WithExpectedKeys allocates the in-memory indexes for about n keys, which are shared evenly by the
+// logs, so loading a data path with millions of keys doesn't grow the indexes over and over. It's
+// only a hint to speed up opening the engine and doesn't limit the number of keys.
+func WithExpectedKeys(n int) OptionSetter {
+	return func(e *Engine) error {
+		if n < 0 {
+			return fmt.Errorf("invalid expected keys")
+		}
+		e.expectedKeys = n
+
+		return nil
+	}
+}
+
+// WithKeyNormalizer maps every key given to the engine to the form it's stored and looked up with, e.g.
+// strings.ToLower for case-insensitive keys. It's applied uniformly to the keys of the writes, the
+// reads, the deletes and the batches, and to the prefixes and the bounds of the scans, while the
+// scans and the exports return the keys in their normalized form. The normalizer must be idempotent,
+// and the same normalizer must be used every time the data path is opened.
+func WithKeyNormalizer(normalizer func(string) string) OptionSetter {
+	return func(e *Engine) error {
+		if normalizer == nil {
+			return fmt.Errorf("invalid key normalizer")
+		}
+		e.keyNormalizer = normalizer
+
+		return nil
+	}
+}
+
+// WithNamespace keeps the data files and the lock of the engine in the subdirectory of the path with
+// the given name, so several engines with different namespaces can share the same parent directory
+// without locking each other out. The name must be a single path element.
+func WithNamespace(name string) OptionSetter {
+	return func(e *Engine) error {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid namespace")
+		}
+		e.namespace = name
+
+		return nil
+	}
+}
+
+// WithShards spreads the new data files over the given number of shard directories in the data path,
+// named 00, 01 and so on, which keeps the directories small when the engine has many logs. A data
+// file is placed in a shard by its sequence number. The data files in the data path itself and in all
+// the shard directories are loaded regardless of the option, so the number of shards can be changed.
+func WithShards(n int) OptionSetter {
+	return func(e *Engine) error {
+		if n <= 0 || n > 100 {
+			return fmt.Errorf("invalid number of shards")
+		}
+		e.shards = n
+
+		return nil
+	}
+}
+
+// WithWriteBufferSize collects the writes in a buffer of the given size before writing them to the
+// write log file, which saves a syscall per write under a high write rate. The buffer is flushed when
+// it's full, the write log is rotated, synced or closed, and before a value is read from the write log.
+// Buffered writes which are not flushed are lost in a crash, WithSyncWrites flushes every write.
+func WithWriteBufferSize(size int) OptionSetter {
+	return func(e *Engine) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid write buffer size")
+		}
+		e.writeBufferSize = size
+
+		return nil
+	}
+}
+
+// WithValueCache keeps up to maxBytes of the recently read values in memory, so reading a hot key
+// doesn't hit the disk. The size of a cached value is the size of its key and value.
+func WithValueCache(maxBytes int64) OptionSetter {
+	return func(e *Engine) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("invalid value cache size")
+		}
+		e.valueCache = newValueCache(maxBytes)
+
+		return nil
+	}
+}
+
+// WithMaxOpenFiles sets the max number of read file handles kept open by the engine
+func WithMaxOpenFiles(n int) OptionSetter {
+	return func(engine *Engine) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max open files")
+		}
+		engine.maxOpenFiles = n
+		return nil
+	}
+}
+
+// WithKeepAllFilesOpen opens the file handles of all the read logs when the engine is opened and when
+// a log is added, and keeps them open until the log is removed or the engine is closed, instead of
+// keeping up to WithMaxOpenFiles recently used handles. It suits a small number of large logs, whose
+// reads never have to open a file, as long as the number of the logs stays within the file limit of the process.
+func WithKeepAllFilesOpen(enabled bool) OptionSetter {
+	return func(engine *Engine) error {
+		engine.keepAllFilesOpen = enabled
+		return nil
+	}
+}
+
+// WithReadOnly opens the data path for reading only. Read-only engines take a shared lock on the
+// data path, so many of them can read the same data path at the same time while no engine can
+// write to it. Writes and compaction return ErrReadOnly, and background processes are not started.
+func WithReadOnly(enabled bool) OptionSetter {
+	return func(engine *Engine) error {
+		engine.readOnly = enabled
+		return nil
+	}
+}
+
+// OpenForRead opens the existing data path for reading only, like NewEngine with WithReadOnly. It never
+// writes to the data path, so it works on read-only mounts and with directories the process can't
+// write to. Without a lock file in the data path, e.g. if it's copied to a read-only mount, the
+// engine reads it without taking a lock, since the lock file can't be created.
+func OpenForRead(path string, options ...OptionSetter) (*Engine, error) {
+	options = append(append([]OptionSetter{}, options...), WithReadOnly(true))
+	return NewEngine(path, options...)
+}
+
+// WithLockTimeout makes opening the engine wait up to the timeout for another engine to release the
+// lock of the data path, e.g. while a previous process is shutting down, instead of failing right
+// away with ErrLocked. It fails with ErrLockTimeout if the data path is still locked once the timeout
+// elapses, a zero timeout fails immediately.
+func WithLockTimeout(timeout time.Duration) OptionSetter {
+	return func(e *Engine) error {
+		if timeout < 0 {
+			return fmt.Errorf("invalid lock timeout")
+		}
+		e.lockTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithLockFilePath keeps the lock file of the data path in the given path instead of the data path,
+// e.g. on a local volume when the data path is on a mount which doesn't support locks. The directory
+// of the lock file must exist. Every engine opening the data path must be given the same lock file,
+// and the engines of different data paths, e.g. different namespaces, must not share one. The lock
+// file is recorded in the data path, so opening it with another lock file, or without this option,
+// fails with ErrConfigMismatch unless the configuration is overridden by WithConfigOverride.
+func WithLockFilePath(path string) OptionSetter {
+	return func(e *Engine) error {
+		if path == "" || strings.HasSuffix(path, string(filepath.Separator)) {
+			return fmt.Errorf("invalid lock file path")
+		}
+		e.lockFilePath = filepath.Clean(path)
+
+		return nil
+	}
+}
+
+// WithMmapReads memory maps the read logs, so values are copied out of the mapping instead of being
+// read with a syscall on every Get. It speeds up random reads of read-heavy workloads at the cost of
+// address space, the mapped files are limited by WithMaxOpenFiles like the file handles.
+// It's not supported on windows, where the files are always read through the file handles.
+func WithMmapReads(enabled bool) OptionSetter {
+	return func(engine *Engine) error {
+		engine.mmapReads = enabled
+		return nil
+	}
+}
+
+// WithSyncWrites makes every write sync the write log to the disk before returning, so a record is
+// never lost after Put returns even on a power loss. It's much slower than the default, which only
+// syncs when a log is rotated or the engine is closed. The directories are synced too after a data
+// file is created or the logs are replaced by a compaction, so the new names survive a crash as well.
+func WithSyncWrites(enabled bool) OptionSetter {
+	return func(engine *Engine) error {
+		engine.syncManager.writes = enabled
+		return nil
+	}
+}
+
+// WithSyncInterval syncs the write log to the disk in the background on the given interval.
+// It's a middle ground between the default and WithSyncWrites, a crash can lose at most the
+// records written during the last interval while writes don't wait for the disk.
+func WithSyncInterval(interval time.Duration) OptionSetter {
+	return func(engine *Engine) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid sync interval")
+		}
+		engine.syncManager.interval = interval
+		return nil
+	}
+}
+
 // WithCompactionEnabled enables compaction for the storage engine
 func WithCompactionEnabled() OptionSetter {
 	return func(engine *Engine) error {
@@ -176,6 +712,18 @@ func WithCompactionEnabled() OptionSetter {
 	}
 }
 
+// WithBackgroundCompaction enables the compaction process running in the background on the given interval
+func WithBackgroundCompaction(interval time.Duration) OptionSetter {
+	return func(engine *Engine) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid compaction interval")
+		}
+		engine.compactionManager.enabled = true
+		engine.compactionManager.interval = interval
+		return nil
+	}
+}
+
 // WithCompactionInterval sets the interval for the compaction process
 func WithCompactionInterval(interval time.Duration) OptionSetter {
 	return func(engine *Engine) error {
@@ -187,204 +735,936 @@ func WithCompactionInterval(interval time.Duration) OptionSetter {
 	}
 }
 
+// Close stops the background processes, flushes the write log to the disk and releases all the
+// resources and the lock held by the engine. It's safe to call Close more than once, after the
+// first call all the operations on the engine return ErrEngineClosed.
 func (e *Engine) Close() error {
-	if e.compactionManager.ticker != nil {
-		e.compactionManager.ticker.Stop()
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return nil
 	}
+	e.closed = true
+	e.lock.Unlock()
+
+	// background processes must be finished before the files are closed
+	e.stopBackgroundSync()
+	e.stopGarbageCollection()
+	e.stopBackgroundCompaction()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
 
-	if err := e.writeLog.file.Sync(); err != nil {
+	var errs []error
+	if e.writeLog.file != nil {
+		if err := e.writeLog.sync(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := e.writeLog.file.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// an empty write log is never loaded, so its file is removed instead of being left behind
+	if len(errs) == 0 && e.writeLog.file != nil && e.writeLog.size == 0 {
+		if err := e.fs.Remove(e.writeLog.file.Name()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// the write log is loaded as a read log next time, so its hint file speeds up the next start
+	if len(errs) == 0 && e.writeLog.size > 0 {
+		log := &readLog{path: e.writeLog.file.Name(), index: e.writeLog.index, tags: e.writeLog.tags, version: currentFormatVersion, size: e.writeLog.size}
+		e.setBloomFilters([]*readLog{log})
+		if err := writeHintFile(e.fs, log, e.fileMode); err != nil {
+			slog.Warn("failed to write hint file", "path", log.path, "err", err)
+		}
+	}
+
+	e.watchManager.close()
+
+	if err := e.fileCache.close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := releaseFlock(e.lockFile); err != nil {
+		errs = append(errs, fmt.Errorf("failed to release the lock: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Reopen rebuilds the in-memory index from the data files in the data path, e.g. after they are
+// changed by an external tool. The write log is closed and a new one is created, while the lock of
+// the data path is kept. Reads and writes are blocked until the index is rebuilt, and a running
+// compaction or garbage collection is finished first. If it fails the engine should be closed.
+func (e *Engine) Reopen() error {
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	switch {
+	case e.writeLog.file == nil:
+	case e.writeLog.size == 0:
+		// an empty write log is never loaded, so its file is removed like on Close
+		if err := e.writeLog.file.Close(); err != nil {
+			return fmt.Errorf("failed to close the write log: %w", err)
+		}
+		if err := e.fs.Remove(e.writeLog.file.Name()); err != nil {
+			return fmt.Errorf("failed to remove the empty write log: %w", err)
+		}
+	default:
+		if err := e.closeWriteLog(); err != nil {
+			return fmt.Errorf("failed to close the write log: %w", err)
+		}
+	}
+
+	// the data files may be replaced, so the cached handles and values can't be used anymore
+	if err := e.fileCache.evictAll(); err != nil {
+		slog.Warn("failed to close cached files", "err", err)
+	}
+	e.valueCache.clear()
+
+	dataFiles, err := extractDatafiles(e.fs, e.dataPath)
+	if err != nil {
 		return err
 	}
-	if err := e.writeLog.file.Close(); err != nil {
+	if err := e.loadMeta(dataFiles); err != nil {
+		return err
+	}
+	readLogs, err := initReadLogs(e.fs, dataFiles, e.tombStone, e.readOnly, e.indexCapacity(len(dataFiles)))
+	if err != nil {
 		return err
 	}
 
-	if err := unix.Flock(int(e.lockFile.Fd()), unix.LOCK_UN); err != nil {
+	e.setBloomFilters(readLogs)
+	e.openReadLogs(readLogs)
+	e.readLogs = readLogs
+	e.writeLog = &writeLog{index: make(map[string]indexEntry)}
+	e.keyCount = e.countKeys()
+	e.buildTagIndex()
+	if e.readOnly {
 		return nil
 	}
 
+	file, err := e.createNewFile()
+	if err != nil {
+		return err
+	}
+	e.writeLog = newWriteLog(file, e.writeBufferSize, e.indexCapacity(len(e.readLogs)+1))
+	return nil
+}
+
+// RepairIndex rebuilds the in-memory indexes of the logs from the data files they are read from, e.g.
+// if the index is suspected to diverge from the disk. Unlike Reopen it keeps the same data files and
+// the same write log and only trusts their contents over the memory. Reads and writes are blocked
+// until the indexes are rebuilt, and a running compaction or garbage collection is finished first.
+func (e *Engine) RepairIndex() error {
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	// the indexes of the read logs are shared with the snapshots, so they are replaced instead of changed
+	readLogs := make([]*readLog, len(e.readLogs))
+	for i, log := range e.readLogs {
+		rebuilt, err := extractReadLog(e.fs, log.path, e.tombStone, len(log.index))
+		if err != nil {
+			return fmt.Errorf("failed to rebuild index: %w", err)
+		}
+		readLogs[i] = rebuilt
+	}
+
+	writeIndex := make(map[string]indexEntry, len(e.writeLog.index))
+	var writeTags map[string][]string
+	// the header of the write log is only written with its first record
+	if e.writeLog.file != nil && e.writeLog.size > 0 {
+		if err := e.writeLog.flush(); err != nil {
+			return err
+		}
+		rebuilt, err := extractReadLog(e.fs, e.writeLog.file.Name(), e.tombStone, len(e.writeLog.index))
+		// a failed buffered write leaves a partial record at the end of the write log
+		var partialErr *partialRecordError
+		if err != nil && !errors.As(err, &partialErr) {
+			return fmt.Errorf("failed to rebuild index: %w", err)
+		}
+		writeIndex, writeTags = rebuilt.index, rebuilt.tags
+	}
+
+	e.setBloomFilters(readLogs)
+	e.readLogs = readLogs
+	e.writeLog.index, e.writeLog.tags = writeIndex, writeTags
+	e.keyCount = e.countKeys()
+	e.buildTagIndex()
+	e.valueCache.clear()
 	return nil
 }
 
 // Put set a key-value pair in the storage engine
 // key and value are strings
 func (e *Engine) Put(key, value string) error {
-	return e.putKeyValue(key, value)
+	return e.putKeyValue(key, value, 0)
+}
+
+// PutWithTTL sets a key-value pair in the storage engine which expires after the given ttl
+// an expired key is treated as a missing key and is removed by the compaction process
+func (e *Engine) PutWithTTL(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl")
+	}
+	return e.putKeyValue(key, value, e.clock.Now().Add(ttl).UnixNano())
 }
 
 // putKeyValue validates the key and value and then appends the key-value pair to the storage engine
-func (e *Engine) putKeyValue(key, value string) error {
-	if err := e.validateKey(key); err != nil {
+func (e *Engine) putKeyValue(key, value string, expiry int64) error {
+	return e.putRecord(record{key: key, value: value, expiry: expiry})
+}
+
+// putRecord validates the key and value of the record and then appends it to the storage engine
+func (e *Engine) putRecord(rec record) error {
+	rec.key = e.normalizeKey(rec.key)
+	if err := e.validateKey(rec.key); err != nil {
 		return err
 	}
-	if err := e.validateValue(value); err != nil {
+	if err := e.validateValue(rec.value); err != nil {
 		return err
 	}
-	return e.appendKeyValue(key, value)
+	return e.appendKeyValue(rec)
 }
 
-// Get retrieves the value associated with the given key from the storage engine.
+// Get retrieves the value associated with the given key from the storage engine. It returns
+// ErrKeyNotFound if the key is missing, deleted or expired. Deletions and expiry times are known from
+// the in-memory index before anything is read, so an error reading the value from the disk is returned
+// as it is and never reported as a missing key.
 func (e *Engine) Get(key string) (string, error) {
 	return e.findValueInLogs(key)
 }
 
+// GetBytes works like Get but returns the value as a byte slice. A key which is set to an empty value
+// returns an empty slice which is not nil, while a missing key returns a nil slice and ErrKeyNotFound.
+func (e *Engine) GetBytes(key string) ([]byte, error) {
+	value, err := e.findValueInLogs(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return []byte{}, nil
+	}
+	return []byte(value), nil
+}
+
+// GetInto copies the value of the key into dst and returns its size, so the same buffer can be reused
+// for many reads instead of allocating a new value for each of them. If dst is shorter than the value
+// it's left unchanged and a ShortBufferError with the size of the value is returned. Values which are
+// not compressed or encrypted are read straight into dst without allocating.
+func (e *Engine) GetInto(key string, dst []byte) (int, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return 0, err
+	}
+	start, now := time.Now(), e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+
+	n, err := e.readLatestValueInto(key, now, dst)
+	e.observeGet(start, err)
+	return n, err
+}
+
+// PutBytes works like Put but takes the value as a byte slice, a nil value is stored as an empty value
+func (e *Engine) PutBytes(key string, value []byte) error {
+	return e.Put(key, string(value))
+}
+
 // findValueInLogs searches for a value corresponding to the given key
 // in the log files, starting with the most recent.
 func (e *Engine) findValueInLogs(key string) (string, error) {
+	key = e.normalizeKey(key)
 	if err := e.validateKey(key); err != nil {
 		return "", err
 	}
+	start, now := time.Now(), e.clock.Now()
+	// the read lock is held while reading the value, so the log can't be rotated, compacted or
+	// rewritten between finding the record in the index and reading it from the disk
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return "", ErrEngineClosed
+	}
+
+	value, err := e.readLatestValue(key, now)
+	e.observeGet(start, err)
+	return value, err
+}
+
+// Exists reports whether the key has a live value in the storage engine.
+// It only looks up the in-memory index, so the value is never read from the disk.
+func (e *Engine) Exists(key string) (bool, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return false, err
+	}
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return false, ErrEngineClosed
+	}
+
+	entry, ok := e.latestEntry(key)
+	return ok && entry.live(now), nil
+}
+
+// NoTTL is returned by GetTTL for a key which is written without a TTL, so it never expires
+const NoTTL time.Duration = -1
+
+// GetTTL returns the time left until the key expires, or NoTTL if it's written without a TTL.
+// Like Exists it only looks up the in-memory index, so the value is never read from the disk.
+func (e *Engine) GetTTL(key string) (time.Duration, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return 0, err
+	}
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+
+	entry, ok := e.latestEntry(key)
+	if !ok || !entry.live(now) {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if entry.expiry == 0 {
+		return NoTTL, nil
+	}
+	return time.Unix(0, entry.expiry).Sub(now), nil
+}
+
+// MaxKeySize returns the max size of a key in bytes, keys are measured in bytes and not in characters
+// so e.g. an emoji takes 4 bytes of the limit
+func (e *Engine) MaxKeySize() int64 {
+	return e.maxKeyBytes
+}
+
+// KeyCount returns the number of distinct keys whose latest record is not a deletion.
+// The count is maintained on every write so it's cheap to call, but keys written with a TTL
+// are counted until a compaction drops them, even if they are already expired.
+func (e *Engine) KeyCount() (int, error) {
 	e.lock.RLock()
-	writeLog := e.writeLog
-	offset, ok := writeLog.index[key]
-	e.lock.RUnlock()
-	if ok {
-		value, err := e.readValueFromFile(writeLog.file.Name(), offset)
-		if value == e.tombStone {
-			return "", fmt.Errorf("value not found")
+	defer e.lock.RUnlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+	return e.keyCount, nil
+}
+
+// readLatestValue reads the latest live value of the key, the caller must hold the lock
+func (e *Engine) readLatestValue(key string, now time.Time) (string, error) {
+	value, _, err := e.readLatestRecord(key, now)
+	return value, err
+}
+
+// readLatestRecord reads the latest live value of the key and returns where its record is stored,
+// the caller must hold the lock
+func (e *Engine) readLatestRecord(key string, now time.Time) (string, RecordMeta, error) {
+	if entry, ok := e.writeLog.index[key]; ok {
+		if !entry.live(now) {
+			return "", RecordMeta{}, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+		}
+		meta := RecordMeta{Path: e.writeLog.file.Name(), Offset: entry.offset, Size: entry.size, InWriteLog: true}
+		if value, ok := e.valueCache.get(key); ok {
+			return value, meta, nil
+		}
+		value, err := e.readValueFromWriteLog(meta.Path, entry.offset)
+		if err == nil {
+			e.valueCache.add(key, value)
+		}
+		return value, meta, err
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		if entry, ok := e.lookupReadLog(e.readLogs[i], key); ok {
+			if !entry.live(now) {
+				return "", RecordMeta{}, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+			}
+			meta := RecordMeta{Path: e.readLogs[i].path, Offset: entry.offset, Size: entry.size}
+			if value, ok := e.valueCache.get(key); ok {
+				return value, meta, nil
+			}
+			value, err := e.readValueFromFile(meta.Path, entry.offset, e.readLogs[i].version)
+			if err == nil {
+				e.valueCache.add(key, value)
+			}
+			return value, meta, err
+		}
+	}
+	return "", RecordMeta{}, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+}
+
+// readLatestValueInto reads the latest live value of the key into dst like readLatestRecord, but the
+// value is only added to the value cache when it's decoded, the caller must hold the lock
+func (e *Engine) readLatestValueInto(key string, now time.Time, dst []byte) (int, error) {
+	var entry indexEntry
+	var path string
+	version, inWriteLog, found := currentFormatVersion, false, false
+	if entry, found = e.writeLog.index[key]; found {
+		path, inWriteLog = e.writeLog.file.Name(), true
+	} else {
+		for i := len(e.readLogs) - 1; i >= 0 && !found; i-- {
+			if entry, found = e.lookupReadLog(e.readLogs[i], key); found {
+				path, version = e.readLogs[i].path, e.readLogs[i].version
+			}
+		}
+	}
+	if !found || !entry.live(now) {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if value, ok := e.valueCache.get(key); ok {
+		return copyValue(dst, value)
+	}
+
+	if version != formatVersionLegacy {
+		n, err := e.readValueInto(path, entry.offset, entry.size, version, inWriteLog, dst)
+		if err != errEncodedValue {
+			return n, err
+		}
+	}
+	value, _, err := e.readLatestRecord(key, now)
+	if err != nil {
+		return 0, err
+	}
+	return copyValue(dst, value)
+}
+
+// readValueInto reads the value of the record at the given offset of the data file in path into dst,
+// the record may still be in the buffer of the write log so it's flushed first for the write log
+func (e *Engine) readValueInto(path string, offset, size int64, version int, inWriteLog bool, dst []byte) (int, error) {
+	acquire := e.fileCache.acquireMapped
+	if inWriteLog {
+		if err := e.writeLog.flush(); err != nil {
+			return 0, err
 		}
-		return value, err
+		acquire = e.fileCache.acquire
+	}
+	cf, err := acquire(path)
+	if err != nil {
+		return 0, err
+	}
+	defer e.fileCache.release(cf)
+	return readValueInto(cf, path, offset, size, version, dst)
+}
+
+// copyValue copies the value into dst, or returns a ShortBufferError if it doesn't fit
+func copyValue(dst []byte, value string) (int, error) {
+	if len(value) > len(dst) {
+		return 0, &ShortBufferError{Size: len(value)}
 	}
+	return copy(dst, value), nil
+}
 
+// latestEntry returns the index entry of the latest record of the key, the caller must hold the lock
+func (e *Engine) latestEntry(key string) (indexEntry, bool) {
+	if entry, ok := e.writeLog.index[key]; ok {
+		return entry, true
+	}
 	for i := len(e.readLogs) - 1; i >= 0; i-- {
-		currentLog := e.readLogs[i]
+		if entry, ok := e.lookupReadLog(e.readLogs[i], key); ok {
+			return entry, true
+		}
+	}
+	return indexEntry{}, false
+}
 
-		offset, exists := currentLog.index[key]
-		if exists {
-			value, err := e.readValueFromFile(currentLog.path, offset)
-			if value == e.tombStone {
-				return "", fmt.Errorf("value not found")
+// countKeys counts the distinct keys whose latest record is not a tombstone by going through
+// all the indexes, the caller must hold the lock
+func (e *Engine) countKeys() int {
+	count := 0
+	visited := make(map[string]struct{})
+	visitIndex := func(index map[string]indexEntry) {
+		for key, entry := range index {
+			// the logs are visited from the newest to the oldest so the first visit is the latest record
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			if !entry.tombstone {
+				count++
 			}
-			return value, err
 		}
 	}
 
-	return "", fmt.Errorf("key %s not found", key)
+	if e.writeLog != nil {
+		visitIndex(e.writeLog.index)
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		visitIndex(e.readLogs[i].index)
+	}
+	return count
+}
+
+// readValueFromFile reads a value from a file with the given format version at the given offset.
+func (e *Engine) readValueFromFile(path string, offset int64, version int) (string, error) {
+	rec, err := openAndReadAtDataFile(e.fileCache, path, offset, version, true)
+	if err != nil {
+		return "", err
+	}
+	return e.decodeValue(rec)
 }
 
-// readValueFromFile reads a value from a file at the given offset.
-func (e *Engine) readValueFromFile(path string, offset int64) (string, error) {
-	value, err := openAndReadAtDataFile(path, offset)
+// readValueFromWriteLog reads a value from the write log in path at the given offset.
+func (e *Engine) readValueFromWriteLog(path string, offset int64) (string, error) {
+	// the record may still be in the buffer of the write log
+	if err := e.writeLog.flush(); err != nil {
+		return "", err
+	}
+	rec, err := openAndReadAtDataFile(e.fileCache, path, offset, currentFormatVersion, false)
 	if err != nil {
 		return "", err
 	}
-	return value, nil
+	return e.decodeValue(rec)
+}
+
+// readWholeRecord reads the record of size bytes at the given offset of the data file in path with a
+// single positioned read and decompresses its value. The record may still be in the buffer of the
+// write log, so it's flushed first for the records of the write log.
+func (e *Engine) readWholeRecord(path string, offset, size int64, version int, inWriteLog bool) (record, error) {
+	acquire := e.fileCache.acquireMapped
+	if inWriteLog {
+		if err := e.writeLog.flush(); err != nil {
+			return record{}, err
+		}
+		acquire = e.fileCache.acquire
+	}
+	cf, err := acquire(path)
+	if err != nil {
+		return record{}, err
+	}
+	defer e.fileCache.release(cf)
+
+	rec, err := readWholeRecordAt(cf, path, offset, size, version)
+	if err != nil {
+		return record{}, err
+	}
+	rec.value, err = e.decodeValue(rec)
+	return rec, err
 }
 
 // Delete deletes a key-value pair from the storage engine
-// Internally it sets the value to a tombstone value and then garbage collector will remove it
+// Internally it appends a tombstone record for the key which is later dropped by the compaction
 func (e *Engine) Delete(key string) error {
 	return e.deleteKey(key)
 }
 
 // deleteKey validates the key and then appends the key-value pair to the storage engine
 func (e *Engine) deleteKey(key string) error {
+	key = e.normalizeKey(key)
 	if err := e.validateKey(key); err != nil {
 		return err
 	}
-	return e.appendKeyValue(key, e.tombStone)
+	return e.appendKeyValue(tombstoneRecord(key))
 }
 
+// DeleteRange deletes every live key which starts with the given prefix and returns the number of
+// deleted keys. The keys are deleted together like a committed batch, so either all of them are
+// deleted or none, and their records are dropped by the next compaction. An empty prefix deletes all the keys.
+func (e *Engine) DeleteRange(prefix string) (int, error) {
+	prefix = e.normalizeKey(prefix)
+	start := time.Now()
+	if err := e.lockWrites(); err != nil {
+		return 0, err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+	if e.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	now := e.clock.Now()
+	var records []record
+	err := e.walk(context.Background(), func(key string, entry indexEntry, _ func() (string, error)) error {
+		if strings.HasPrefix(key, prefix) && entry.live(now) {
+			records = append(records, tombstoneRecord(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := e.appendBatch(records); err != nil {
+		return 0, err
+	}
+	e.observeWrites(start, records)
+	return len(records), nil
+}
+
+// closeWriteLog closes the current write log and moves it to the read logs,
+// the index of the log is stored in a hint file to speed up loading it at startup
 func (e *Engine) closeWriteLog() error {
-	e.readLogs = append(e.readLogs, &readLog{path: e.writeLog.file.Name(), index: e.writeLog.index})
-	return e.writeLog.file.Close()
+	log := &readLog{path: e.writeLog.file.Name(), index: e.writeLog.index, tags: e.writeLog.tags, version: currentFormatVersion, size: e.writeLog.size}
+	e.setBloomFilters([]*readLog{log})
+	e.openReadLogs([]*readLog{log})
+	e.readLogs = append(e.readLogs, log)
+	if err := e.writeLog.sync(); err != nil {
+		return err
+	}
+	if err := e.writeLog.file.Close(); err != nil {
+		return err
+	}
+
+	if err := writeHintFile(e.fs, log, e.fileMode); err != nil {
+		slog.Warn("failed to write hint file", "path", log.path, "err", err)
+	}
+	return nil
 }
 
-// appendKeyValue appends a key-value pair to the file
-func (e *Engine) appendKeyValue(key, value string) error {
-	e.lock.Lock()
+// appendKeyValue appends a key-value record to the file
+func (e *Engine) appendKeyValue(rec record) error {
+	start := time.Now()
+	if err := e.lockWrites(); err != nil {
+		return err
+	}
 	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
 
-	if e.writeLog.size >= e.maxLogBytes {
-		err := e.closeWriteLog()
-		if err != nil {
+	if err := e.appendRecord(rec); err != nil {
+		return err
+	}
+	e.observeWrite(start, rec)
+	return nil
+}
+
+// appendRecord writes the record to the write log and updates the index, the caller must hold the lock
+func (e *Engine) appendRecord(rec record) error {
+	encodedValue, err := e.encodeValue(rec)
+	if err != nil {
+		return err
+	}
+	encoded := encodeRecord(encodedValue)
+	if !rec.tombstone() {
+		if err := e.checkDiskQuota(int64(len(encoded))); err != nil {
 			return err
 		}
+	}
+	if err := e.prepareWriteLog(int64(len(encoded))); err != nil {
+		return err
+	}
 
-		file, err := e.createNewFile()
-		if err != nil {
+	// the index points to the beginning of the record
+	offset := e.writeLog.size
+
+	written, err := e.writeLog.write(encoded)
+	e.writeLog.size += int64(written)
+	if err != nil {
+		return errors.Join(err, e.rollbackWriteLog(offset))
+	}
+	if e.syncManager.writes {
+		if err := e.writeLog.sync(); err != nil {
 			return err
 		}
-		e.writeLog = &writeLog{file: file, index: make(map[string]int64), size: 0}
 	}
 
-	keyBytes := []byte(key)
-	keySize := uint32(len(keyBytes))
-	sizeBuffer := make([]byte, 4)
-	binary.LittleEndian.PutUint32(sizeBuffer, keySize)
+	// Update the index with the position of the record
+	e.updateIndex(rec, offset, int64(written))
 
-	written, err := e.writeLog.file.Write(sizeBuffer)
-	if err != nil {
-		return err
+	e.finishOversizedWrite(int64(written))
+	return nil
+}
+
+// prepareWriteLog makes the write log ready for appending size bytes of records, it rotates the write
+// log if it's full and writes the file header if the log is empty. Records which don't fit in an
+// empty log get a log of their own. The caller must hold the write lock.
+func (e *Engine) prepareWriteLog(size int64) error {
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	if e.writeLogFull(size) {
+		if err := e.rotateWriteLog(); err != nil {
+			return err
+		}
 	}
 
-	e.writeLog.size += int64(written)
+	// the header is written lazily with the first record so empty data files stay empty
+	if e.writeLog.size == 0 {
+		written, err := e.writeLog.write(fileHeader())
+		e.writeLog.size += int64(written)
+		if err != nil {
+			return errors.Join(err, e.rollbackWriteLog(0))
+		}
+	}
 
-	written, err = e.writeLog.file.Write(keyBytes)
-	if err != nil {
+	return nil
+}
+
+// writeLogFull reports whether the write log has to be rotated before size bytes of records are appended
+func (e *Engine) writeLogFull(size int64) bool {
+	return e.writeLog.size >= e.maxLogBytes || (e.oversized(size) && e.writeLog.size > 0)
+}
+
+// checkDiskQuota returns ErrQuotaExceeded if writing size bytes grows the data files over the max disk
+// size, the caller must hold the write lock
+func (e *Engine) checkDiskQuota(size int64) error {
+	if e.maxDiskBytes > 0 && e.diskUsage()+size > e.maxDiskBytes {
+		return fmt.Errorf("%w: writing %d bytes grows the data files over %d bytes", ErrQuotaExceeded, size, e.maxDiskBytes)
+	}
+	return nil
+}
+
+// rollbackWriteLog removes the partially written bytes from the end of the write log by truncating it
+// to size, so the next records are appended after the last valid record. The buffer of the write log is
+// flushed first, so the file ends where the log does. A buffer which fails to flush fails every write
+// after it, so its partial record stays at the end of the file and is truncated when the data path is
+// opened again. A failed truncation is returned. The caller must hold the write lock.
+func (e *Engine) rollbackWriteLog(size int64) error {
+	if e.writeLog.size == size {
+		return nil
+	}
+	if e.writeLog.flush() != nil {
+		return nil
+	}
+	return e.truncateWriteLog(size)
+}
+
+// truncateWriteLog truncates the file of the write log to size, the buffer of the write log must be
+// empty. The caller must hold the write lock.
+func (e *Engine) truncateWriteLog(size int64) error {
+	if err := e.writeLog.file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate partial record at offset %d of %s: %w", size, e.writeLog.file.Name(), err)
+	}
+	e.writeLog.size = size
+	return nil
+}
+
+// oversized reports whether size bytes of records don't fit in an empty log
+func (e *Engine) oversized(size int64) bool {
+	return fileHeaderSize+size > e.maxLogBytes
+}
+
+// finishOversizedWrite rotates the write log right after oversized records are written to it, so the
+// records stay alone in their log. A failed rotation is retried by the next write as the log is full.
+func (e *Engine) finishOversizedWrite(size int64) {
+	if !e.oversized(size) {
+		return
+	}
+	if err := e.rotateWriteLog(); err != nil {
+		slog.Warn("failed to rotate the write log after an oversized write", "err", err)
+	}
+}
+
+// DropAll removes all the keys of the storage engine by removing all its data files and starting a new
+// empty write log, e.g. to reset a cache or between tests, without closing the engine or releasing the
+// lock of the data path. The reads wait for it and see an empty engine afterwards, and like after
+// compaction the dropped data files are only removed once the open snapshots are closed. The watchers are not notified
+// of the removed keys and the compaction backups are kept. The data files are removed from the oldest
+// to the newest, so a crash in between may leave some of the keys with their latest values behind.
+func (e *Engine) DropAll() error {
+	// the compaction lock makes sure no compaction or garbage collection is writing the logs meanwhile
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+	if err := e.lockWrites(); err != nil {
 		return err
 	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
 
-	e.writeLog.size += int64(written)
+	paths := make([]string, 0, len(e.readLogs)+1)
+	for _, log := range e.readLogs {
+		paths = append(paths, log.path)
+	}
+	paths = append(paths, e.writeLog.file.Name())
+	if err := e.writeLog.file.Close(); err != nil {
+		return err
+	}
 
-	// Find the current write position in the file
-	// Current position is the position that we write the value size
-	currentPos, err := e.writeLog.file.Seek(0, io.SeekCurrent)
+	file, err := e.createNewFile()
 	if err != nil {
 		return err
 	}
+	e.readLogs = nil
+	e.writeLog = newWriteLog(file, e.writeBufferSize, e.indexCapacity(1))
+	e.keyCount = 0
+	e.tags = newTagIndex()
+	e.valueCache.clear()
+	for _, path := range paths {
+		e.fileCache.evict(path)
+	}
+
+	e.snapshotManager.removeUnpinned(func() {
+		for _, path := range paths {
+			if err := removeLogFiles(e.fs, path); err != nil && !os.IsNotExist(err) {
+				slog.Warn("failed to remove dropped data file", "path", path, "err", err)
+			}
+		}
+	})
+	return nil
+}
+
+// RotateLog closes the current write log, turning it into a read log, and starts a new write log
+// regardless of its size, e.g. to make the written records available to the next compaction.
+// An empty write log is kept as it is.
+func (e *Engine) RotateLog() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
+	if e.writeLog.size == 0 {
+		return nil
+	}
+	return e.rotateWriteLog()
+}
 
-	valueBytes := []byte(value)
-	valueSize := uint32(len(valueBytes))
-	sizeBuffer = make([]byte, 4)
-	binary.LittleEndian.PutUint32(sizeBuffer, valueSize)
-	written, err = e.writeLog.file.Write(sizeBuffer)
+// indexCapacity returns the capacity of the index of each of the given number of logs which share
+// the expected keys
+func (e *Engine) indexCapacity(logs int) int {
+	return e.expectedKeys / max(logs, 1)
+}
+
+// rotateWriteLog closes the write log, turning it into a read log, and starts a new write log.
+// The caller must hold the write lock.
+func (e *Engine) rotateWriteLog() error {
+	err := e.closeWriteLog()
 	if err != nil {
 		return err
 	}
 
-	e.writeLog.size += int64(written)
-
-	written, err = e.writeLog.file.Write(valueBytes)
+	file, err := e.createNewFile()
 	if err != nil {
 		return err
 	}
+	e.writeLog = newWriteLog(file, e.writeBufferSize, e.indexCapacity(len(e.readLogs)+1))
+	return nil
+}
 
-	e.writeLog.size += int64(written)
+// updateIndex points the key of the record to its location in the write log.
+// The caller must hold the write lock.
+func (e *Engine) updateIndex(rec record, offset, size int64) {
+	tombstone := rec.tombstone()
+	previous, ok := e.latestEntry(rec.key)
+	if ok && !previous.tombstone {
+		e.keyCount--
+	}
+	if !tombstone {
+		e.keyCount++
+	}
 
-	// Update the index with the current write position
-	e.writeLog.index[key] = currentPos
+	e.valueCache.remove(rec.key)
+	e.writeLog.tags = setLogTags(e.writeLog.tags, rec.key, rec.tags)
+	e.tags.set(rec.key, rec.tags)
+	e.writeLog.index[rec.key] = indexEntry{
+		offset:    offset,
+		tombstone: tombstone,
+		expiry:    rec.expiry,
+		size:      size,
+	}
 
-	return nil
+	op := PutOp
+	if tombstone {
+		op = DeleteOp
+	}
+	e.watchManager.publish(ChangeEvent{Key: rec.key, Op: op})
+}
+
+// normalizeKey returns the key in the form it's stored with, an empty key or prefix stays empty
+func (e *Engine) normalizeKey(key string) string {
+	if e.keyNormalizer == nil || key == "" {
+		return key
+	}
+	return e.keyNormalizer(key)
 }
 
 func (e *Engine) validateKey(key string) error {
 	if key == "" {
 		return fmt.Errorf("key cannot be empty")
 	}
-	if int64(len([]byte(key))) > e.maxKeyBytes {
-		return fmt.Errorf("key cannot be longer than %d bytes", e.maxKeyBytes)
-	}
-	return nil
+	return checkSize("key", int64(len(key)), e.maxKeyBytes)
 }
 
 func (e *Engine) validateValue(value string) error {
-	if value == e.tombStone {
-		return fmt.Errorf("value cannot be tombstone")
+	return e.validateValueSize(int64(len(value)))
+}
+
+// validateValueSize checks the size of a value before any of it is written
+func (e *Engine) validateValueSize(size int64) error {
+	limit := e.maxValueBytes
+	if e.aead != nil {
+		// the nonce and the tag are stored with an encrypted value, so they take from the size its record can hold
+		limit = min(limit, math.MaxUint32-int64(e.aead.NonceSize()+e.aead.Overhead()))
 	}
-	// value size should be less than the max size of the log file
-	if int64(len([]byte(value))) > e.maxLogBytes {
-		return fmt.Errorf("value cannot be longer than %d bytes", e.maxLogBytes)
+	return checkSize("value", size, limit)
+}
+
+// checkSize returns a SizeError if size is larger than limit or than the sizes which fit in the uint32
+// size fields of a record, so a misconfigured huge limit never lets a size be truncated in the data file
+func checkSize(kind string, size, limit int64) error {
+	limit = min(limit, math.MaxUint32)
+	if size > limit {
+		return &SizeError{Kind: kind, Actual: size, Limit: limit}
 	}
 	return nil
 }
 
-func (e *Engine) createNewFile() (*os.File, error) {
-	fileName := fmt.Sprintf("%d%s", len(e.readLogs)+1, dataFileFormatSuffix)
-	dataFilePath := filepath.Join(e.dataPath, fileName)
-	file, err := os.OpenFile(dataFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644) // how we should get the righy permission
+// dataFilePath returns the path of the data file with the given sequence number, part and generation.
+// The data file is placed in its shard directory if the data files are sharded, which is created if
+// it doesn't exist.
+func (e *Engine) dataFilePath(number, part, generation int) (string, error) {
+	dir := e.dataPath
+	if e.shards > 0 {
+		dir = filepath.Join(e.dataPath, shardDirName(number, e.shards))
+		if err := e.fs.MkdirAll(dir, e.dirMode); err != nil {
+			return "", fmt.Errorf("failed to create shard directory: %w", err)
+		}
+	}
+	return filepath.Join(dir, dataFileName(number, part, generation)), nil
+}
+
+// createNewFile creates the data file for a new write log, it's numbered with the next sequence
+// number which is persisted before the file is created, so the number is never used again
+func (e *Engine) createNewFile() (File, error) {
+	m := e.meta
+	m.Sequence++
+	if err := writeMeta(e.fs, e.dataPath, m, e.fileMode); err != nil {
+		return nil, fmt.Errorf("failed to write meta file: %w", err)
+	}
+	e.meta = m
+	dataFilePath, err := e.dataFilePath(m.Sequence, 0, 0)
 	if err != nil {
 		return nil, err
 	}
+	file, err := e.fs.OpenFile(dataFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, e.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	// the meta file is renamed into the data path and the data file may be in a shard directory
+	if err := e.syncDirs(e.dataPath+metaFileName, dataFilePath); err != nil {
+		file.Close()
+		return nil, err
+	}
 	return file, nil
 }
@@ -1,8 +1,20 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -53,7 +65,7 @@ func TestGetValueFromSecondLog(t *testing.T) {
 	dataPath := "test_get_value_from_second_log/"
 	require.NoError(t, removeDir(dataPath))
 
-	engine, err := NewEngine(dataPath, WithMaxLogSize(1)) // size = 1 byte
+	engine, err := NewEngine(dataPath, WithMaxLogSize(1), WithAllowTinyLogs(true)) // size = 1 byte
 	require.NoError(t, err)
 
 	require.NoError(t, engine.Put("key1", "1"))
@@ -96,6 +108,40 @@ func TestDelete(t *testing.T) {
 }
 
 // Test for empty key
+func TestValueEqualToTombstone(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_value_equal_to_tombstone")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("key", defaultTombstone))
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, defaultTombstone, value)
+
+	require.NoError(t, engine.Delete("other"))
+	require.NoError(t, engine.Close())
+
+	// the index is rebuilt from the data file to check the records are read the same way
+	dataFiles, err := extractDatafiles(osFS{}, tempDir)
+	require.NoError(t, err)
+	for _, path := range dataFiles {
+		require.NoError(t, os.Remove(hintFilePath(path)))
+	}
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	value, err = engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, defaultTombstone, value)
+	_, err = engine.Get("other")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
 func TestEmptyKey(t *testing.T) {
 	dataPath := "test_empty_key/"
 	require.NoError(t, removeDir(dataPath))
@@ -125,12 +171,140 @@ func TestEmptyValue(t *testing.T) {
 	require.NoError(t, engine.Close())
 }
 
+func TestGetInto(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_into")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("key", "value"))
+
+	exact := make([]byte, 5)
+	n, err := engine.GetInto("key", exact)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "value", string(exact))
+
+	larger := make([]byte, 16)
+	n, err = engine.GetInto("key", larger)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "value", string(larger[:n]))
+
+	short := []byte("abc")
+	n, err = engine.GetInto("key", short)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+	var shortErr *ShortBufferError
+	require.ErrorAs(t, err, &shortErr)
+	assert.Equal(t, 5, shortErr.Size)
+	assert.Zero(t, n)
+	assert.Equal(t, "abc", string(short))
+
+	_, err = engine.GetInto("missing", larger)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestGetIntoDoesNotAllocate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_into_allocs")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	for _, mmap := range []bool{false, true} {
+		engine, err := NewEngine(filepath.Join(tempDir, strconv.FormatBool(mmap)), WithMmapReads(mmap))
+		require.NoError(t, err)
+		require.NoError(t, engine.PutWithTags("rotated", "value of the read log", "tag"))
+		require.NoError(t, engine.RotateLog())
+		require.NoError(t, engine.Put("written", "value of the write log"))
+
+		dst := make([]byte, 64)
+		for _, key := range []string{"rotated", "written"} {
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := engine.GetInto(key, dst); err != nil {
+					t.Fatal(err)
+				}
+			})
+			assert.Zero(t, allocs, key)
+		}
+		n, err := engine.GetInto("rotated", dst)
+		require.NoError(t, err)
+		assert.Equal(t, "value of the read log", string(dst[:n]))
+		require.NoError(t, engine.Close())
+	}
+}
+
+func TestGetIntoCompressedValue(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_into_compressed")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithCompression(GzipCodec{}))
+	require.NoError(t, err)
+	defer engine.Close()
+	value := strings.Repeat("compressed ", 100)
+	require.NoError(t, engine.Put("key", value))
+
+	var shortErr *ShortBufferError
+	_, err = engine.GetInto("key", make([]byte, 10))
+	require.ErrorAs(t, err, &shortErr)
+	assert.Equal(t, len(value), shortErr.Size)
+
+	dst := make([]byte, len(value))
+	n, err := engine.GetInto("key", dst)
+	require.NoError(t, err)
+	assert.Equal(t, value, string(dst[:n]))
+}
+
+func TestGetBytesEmptyValue(t *testing.T) {
+	dataPath := "test_get_bytes_empty_value/"
+	require.NoError(t, removeDir(dataPath))
+
+	engine, err := NewEngine(dataPath)
+	require.NoError(t, err)
+
+	require.NoError(t, engine.PutBytes("empty", []byte{}))
+	require.NoError(t, engine.PutBytes("nil", nil))
+	require.NoError(t, engine.PutBytes("key", []byte("value")))
+
+	check := func() {
+		for _, key := range []string{"empty", "nil"} {
+			value, err := engine.GetBytes(key)
+			require.NoError(t, err)
+			assert.NotNil(t, value)
+			assert.Empty(t, value)
+		}
+
+		value, err := engine.GetBytes("key")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+
+		value, err = engine.GetBytes("missing")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		assert.Nil(t, value)
+	}
+	check()
+
+	// the values are read from the read logs after a restart
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(dataPath)
+	require.NoError(t, err)
+	check()
+
+	require.NoError(t, engine.Delete("empty"))
+	value, err := engine.GetBytes("empty")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Nil(t, value)
+
+	require.NoError(t, engine.Close())
+}
+
 // Test for large key and value
 func TestLargeKeyValue(t *testing.T) {
 	dataPath := "test_large_key_value/"
 	require.NoError(t, removeDir(dataPath))
 
-	engine, err := NewEngine(dataPath, WithMaxKeySize(1*KB), WithMaxLogSize(10*KB))
+	engine, err := NewEngine(dataPath, WithMaxKeySize(1*KB), WithMaxValueSize(10*KB))
 	require.NoError(t, err)
 
 	largeKey := string(make([]byte, 2*KB))
@@ -160,7 +334,7 @@ func TestKeyAndValueSizeValidation(t *testing.T) {
 	dataPath := "test_key_value_size_validation/"
 	require.NoError(t, removeDir(dataPath))
 
-	engine, err := NewEngine(dataPath, WithMaxKeySize(10), WithMaxLogSize(10))
+	engine, err := NewEngine(dataPath, WithMaxKeySize(10), WithMaxValueSize(10))
 	require.NoError(t, err)
 
 	require.Error(t, engine.Put("veryLongKeyForThis", "value"))
@@ -169,6 +343,877 @@ func TestKeyAndValueSizeValidation(t *testing.T) {
 	require.NoError(t, engine.Close())
 }
 
+func TestSizeError(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_size_error")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxKeySize(10), WithMaxValueSize(20))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	err = engine.Put("veryLongKeyForThis", "value")
+	assert.ErrorIs(t, err, ErrTooLarge)
+	var sizeErr *SizeError
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Equal(t, SizeError{Kind: "key", Actual: 18, Limit: 10}, *sizeErr)
+	assert.EqualError(t, err, "key of 18 bytes is longer than 10 bytes")
+
+	_, err = engine.Get("veryLongKeyForThis")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	err = engine.Put("key", strings.Repeat("v", 25))
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Equal(t, SizeError{Kind: "value", Actual: 25, Limit: 20}, *sizeErr)
+}
+
+func TestSizeOverflowingRecordFormat(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_size_overflowing_record_format")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// the limits are larger than the sizes the record format can hold
+	engine, err := NewEngine(tempDir, WithMaxKeySize(8*GB), WithMaxValueSize(8*GB))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// the size is rejected before the value is read
+	err = engine.PutReader("key", strings.NewReader(""), math.MaxUint32+1)
+	var sizeErr *SizeError
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Equal(t, SizeError{Kind: "value", Actual: math.MaxUint32 + 1, Limit: math.MaxUint32}, *sizeErr)
+	assert.Zero(t, engine.writeLog.size)
+
+	assert.ErrorIs(t, checkSize("key", math.MaxUint32+1, 8*GB), ErrTooLarge)
+	assert.NoError(t, checkSize("key", math.MaxUint32, 8*GB))
+
+	require.NoError(t, engine.Put("key", "value"))
+}
+
+func TestMultibyteKeySize(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_multibyte_key_size")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxKeySize(8))
+	require.NoError(t, err)
+	defer engine.Close()
+	assert.Equal(t, int64(8), engine.MaxKeySize())
+
+	// every emoji takes 4 bytes, so two of them fill the limit
+	atLimit := "😀😀"
+	overLimit := "😀😀a"
+	require.Len(t, atLimit, 8)
+
+	require.NoError(t, engine.Put(atLimit, "value"))
+	value, err := engine.Get(atLimit)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	require.NoError(t, engine.Delete(atLimit))
+
+	assert.ErrorContains(t, engine.Put(overLimit, "value"), "longer than 8 bytes")
+	_, err = engine.Get(overLimit)
+	assert.ErrorContains(t, err, "longer than 8 bytes")
+	assert.ErrorContains(t, engine.Delete(overLimit), "longer than 8 bytes")
+}
+
+// Test that the value size limit is independent of the log size
+func TestMaxValueSizeIndependentOfLogSize(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_max_value_size")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithMaxValueSize(0))
+	require.Error(t, err)
+
+	// a value larger than the log size is accepted
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1), WithAllowTinyLogs(true), WithMaxValueSize(1*KB))
+	require.NoError(t, err)
+	value := string(make([]byte, 512))
+	require.NoError(t, engine.Put("key", value))
+	require.NoError(t, engine.Put("other", "value"))
+	actual, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, value, actual)
+
+	// a value larger than the value size is rejected even if the log is large enough
+	require.Error(t, engine.Put("key", string(make([]byte, 2*KB))))
+	require.NoError(t, engine.Close())
+}
+
+// Test that a value larger than the max log size gets a log of its own
+func TestOversizedValueGetsOwnLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_oversized_value")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1*KB))
+	require.NoError(t, err)
+
+	largeValue := strings.Repeat("large", 1*KB)
+	require.NoError(t, engine.Put("before", "value"))
+	require.NoError(t, engine.Put("large", largeValue))
+	require.NoError(t, engine.Put("after", "value"))
+
+	// the large value is alone in its log and the next write goes to a new log
+	require.Len(t, engine.readLogs, 2)
+	assert.Contains(t, engine.readLogs[0].index, "before")
+	assert.Len(t, engine.readLogs[1].index, 1)
+	assert.Contains(t, engine.readLogs[1].index, "large")
+	assert.Contains(t, engine.writeLog.index, "after")
+
+	verify := func() {
+		value, err := engine.Get("large")
+		require.NoError(t, err)
+		assert.Equal(t, largeValue, value)
+		for _, key := range []string{"before", "after"} {
+			value, err := engine.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}
+	}
+	verify()
+
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir, WithMaxLogSize(1*KB))
+	require.NoError(t, err)
+	verify()
+	require.NoError(t, engine.Close())
+}
+
+// Test for checking the existence of keys without reading their values
+func TestExists(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_exists")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(32), WithAllowTinyLogs(true))
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("deleted", "badger"))
+	require.NoError(t, engine.Put("other", "otter"))
+	require.NoError(t, engine.Delete("deleted"))
+
+	exists, err := engine.Exists("name")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = engine.Exists("missing")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = engine.Exists("deleted")
+	require.NoError(t, err)
+	assert.False(t, exists, "Expected a key with a tombstone as the newest record not to exist")
+
+	_, err = engine.Exists("")
+	assert.Error(t, err)
+
+	require.NoError(t, engine.Close())
+
+	// the tombstone should be detected from the index loaded from the data files as well
+	engine, err = NewEngine(tempDir, WithMaxLogSize(32), WithAllowTinyLogs(true))
+	require.NoError(t, err)
+
+	exists, err = engine.Exists("deleted")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = engine.Exists("name")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestKeyCount(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_key_count")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+	// overwrites of live keys are not counted twice, even across logs
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "new value"))
+	}
+	require.NoError(t, engine.Delete("key0"))
+	require.NoError(t, engine.Delete("key1"))
+	// deleting a missing or already deleted key doesn't change the count
+	require.NoError(t, engine.Delete("key1"))
+	require.NoError(t, engine.Delete("missing"))
+	// putting a deleted key back counts it again
+	require.NoError(t, engine.Put("key0", "back"))
+
+	count, err = engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 9, count)
+	assert.Equal(t, engine.Stats().Keys, count)
+
+	require.NoError(t, engine.Close())
+	_, err = engine.KeyCount()
+	assert.ErrorIs(t, err, ErrEngineClosed)
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	count, err = engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 9, count, "Expected the count to be rebuilt from the data files")
+
+	require.NoError(t, engine.Compact())
+	count, err = engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 9, count, "Expected the count to be kept by compaction")
+	require.NoError(t, engine.Close())
+}
+
+// Test for a key which expires between Put and Get
+func TestPutWithTTL(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_put_with_ttl")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	require.Error(t, engine.PutWithTTL("session", "token", 0))
+
+	require.NoError(t, engine.PutWithTTL("session", "token", 100*time.Millisecond))
+
+	value, err := engine.Get("session")
+	require.NoError(t, err)
+	assert.Equal(t, "token", value)
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, err = engine.Get("session")
+	assert.ErrorIs(t, err, ErrKeyNotFound, "Expected an expired key to be reported as a missing key")
+
+	exists, err := engine.Exists("session")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// a new put without ttl makes the key live again
+	require.NoError(t, engine.Put("session", "new_token"))
+	value, err = engine.Get("session")
+	require.NoError(t, err)
+	assert.Equal(t, "new_token", value)
+
+	require.NoError(t, engine.Close())
+}
+
+// Test for the expiry time surviving a restart of the engine
+func TestGetTTL(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_ttl")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.PutWithTTL("session", "token", time.Hour))
+	ttl, err := engine.GetTTL("session")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, time.Hour)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	require.NoError(t, engine.Put("name", "gopher"))
+	ttl, err = engine.GetTTL("name")
+	require.NoError(t, err)
+	assert.Equal(t, NoTTL, ttl)
+
+	require.NoError(t, engine.PutWithTTL("short", "lived", 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, err = engine.GetTTL("short")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = engine.GetTTL("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestPutWithTTLAfterRestart(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_put_with_ttl_restart")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	require.NoError(t, engine.PutWithTTL("short", "value", 100*time.Millisecond))
+	require.NoError(t, engine.PutWithTTL("long", "value", time.Hour))
+	require.NoError(t, engine.Close())
+
+	time.Sleep(150 * time.Millisecond)
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+
+	_, err = engine.Get("short")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	value, err := engine.Get("long")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	require.NoError(t, engine.Close())
+}
+
+// Test for opening a data path which is already locked by another engine
+func TestLockedDataPath(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_locked_data_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	_, err = NewEngine(tempDir)
+	assert.ErrorIs(t, err, ErrLocked, "Expected the second engine on the same path to fail while the first is open")
+
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err, "Expected the path to be usable after the first engine is closed")
+	require.NoError(t, engine.Close())
+}
+
+// Test for waiting for the lock of a data path until the engine holding it is closed
+func TestLockTimeout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_lock_timeout")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithLockTimeout(-time.Second))
+	assert.Error(t, err)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = NewEngine(tempDir, WithLockTimeout(50*time.Millisecond))
+	assert.ErrorIs(t, err, ErrLockTimeout, "Expected the second engine to give up once the timeout elapses")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, engine.Close())
+	}()
+	second, err := NewEngine(tempDir, WithLockTimeout(10*time.Second))
+	require.NoError(t, err, "Expected the second engine to acquire the lock once the first is closed")
+	require.NoError(t, second.Put("key", "value"))
+	require.NoError(t, second.Close())
+}
+
+// Test for locking a data path with a lock file outside of it
+func TestLockFilePath(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_lock_file_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	dataPath := filepath.Join(tempDir, "data")
+	lockPath := filepath.Join(tempDir, "data.lock")
+
+	_, err = NewEngine(dataPath, WithLockFilePath(""))
+	assert.Error(t, err)
+
+	engine, err := NewEngine(dataPath, WithLockFilePath(lockPath))
+	require.NoError(t, err)
+	_, err = os.Stat(lockPath)
+	require.NoError(t, err, "Expected the lock file to be created in the given path")
+	_, err = os.Stat(filepath.Join(dataPath, lockFileName))
+	assert.True(t, os.IsNotExist(err), "Expected no lock file in the data path")
+
+	_, err = NewEngine(dataPath, WithLockFilePath(lockPath))
+	assert.ErrorIs(t, err, ErrLocked, "Expected the second engine with the same lock file to fail while the first is open")
+	_, err = NewEngine(dataPath)
+	assert.ErrorIs(t, err, ErrConfigMismatch, "Expected an engine locking the data path itself to fail")
+	_, err = NewEngine(dataPath, WithLockFilePath(filepath.Join(tempDir, "other.lock")))
+	assert.ErrorIs(t, err, ErrConfigMismatch, "Expected an engine with another lock file to fail")
+
+	// the compaction engines don't take the lock file of the engine
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Compact())
+
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(dataPath, WithLockFilePath(lockPath))
+	require.NoError(t, err, "Expected the lock to be released by closing the first engine")
+	require.NoError(t, engine.Close())
+
+	// a data path locked in itself can't be opened with a lock file outside of it either
+	otherPath := filepath.Join(tempDir, "other")
+	engine, err = NewEngine(otherPath)
+	require.NoError(t, err)
+	_, err = NewEngine(otherPath, WithLockFilePath(lockPath))
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+	require.NoError(t, engine.Close())
+}
+
+// Test for opening the same path with multiple read-only engines
+func TestReadOnly(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_read_only")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(filepath.Join(tempDir, "missing"), WithReadOnly(true))
+	assert.Error(t, err, "Expected a read-only engine not to create its data path")
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Delete("key0"))
+	require.NoError(t, engine.Close())
+	dataFiles, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+
+	first, err := NewEngine(tempDir, WithReadOnly(true))
+	require.NoError(t, err)
+	second, err := NewEngine(tempDir, WithReadOnly(true))
+	require.NoError(t, err, "Expected read-only engines to share the data path")
+
+	_, err = NewEngine(tempDir)
+	assert.ErrorIs(t, err, ErrLocked, "Expected a writer to be locked out by the readers")
+
+	for _, engine := range []*Engine{first, second} {
+		value, err := engine.Get("key5")
+		require.NoError(t, err)
+		assert.Equal(t, "value5", value)
+		_, err = engine.Get("key0")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		visited := 0
+		require.NoError(t, engine.ForEach(func(key, value string) error {
+			visited++
+			return nil
+		}))
+		assert.Equal(t, 9, visited)
+
+		assert.ErrorIs(t, engine.Put("key", "value"), ErrReadOnly)
+		assert.ErrorIs(t, engine.Delete("key1"), ErrReadOnly)
+		batch := engine.NewBatch()
+		batch.Put("key", "value")
+		assert.ErrorIs(t, batch.Commit(), ErrReadOnly)
+		assert.ErrorIs(t, engine.Compact(), ErrReadOnly)
+	}
+
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+
+	// the read-only engines don't add any files to the data path
+	files, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, len(dataFiles), len(files))
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err, "Expected the path to be writable after the readers are closed")
+	require.NoError(t, engine.Close())
+}
+
+// readOnlyMountFS is the file system of the operating system which fails every write like a read-only mount
+type readOnlyMountFS struct {
+	osFS
+}
+
+func (f readOnlyMountFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	return f.osFS.OpenFile(name, flag, perm)
+}
+
+func (f readOnlyMountFS) Open(name string) (File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (readOnlyMountFS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (readOnlyMountFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EROFS}
+}
+
+func (readOnlyMountFS) MkdirAll(path string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: syscall.EROFS}
+}
+
+func TestOpenForRead(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_open_for_read")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Close())
+
+	_, err = NewEngine(tempDir, WithFileSystem(readOnlyMountFS{}))
+	assert.ErrorIs(t, err, syscall.EROFS, "Expected a writable engine to need write access")
+
+	check := func() {
+		engine, err := OpenForRead(tempDir, WithFileSystem(readOnlyMountFS{}))
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value%d", i), value)
+		}
+		assert.ErrorIs(t, engine.Put("key", "value"), ErrReadOnly)
+		require.NoError(t, engine.Close())
+	}
+	// the existing lock file is locked without creating it
+	check()
+
+	// a data path copied without its lock file is read without a lock
+	require.NoError(t, os.Remove(filepath.Join(tempDir, lockFileName)))
+	check()
+}
+
+// Test for reading while the write log is rotated on every write
+func TestConcurrentPutAndGetWithRotation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_concurrent_put_get")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1), WithAllowTinyLogs(true), WithMaxOpenFiles(4))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	const writers, readers, writes = 4, 4, 100
+	require.NoError(t, engine.Put("key", "value"))
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				assert.NoError(t, engine.Put("key", "value"))
+				assert.NoError(t, engine.Put(fmt.Sprintf("key%d_%d", w, i), "value"))
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes*2; i++ {
+				value, err := engine.Get("key")
+				assert.NoError(t, err)
+				assert.Equal(t, "value", value)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Test for closing the engine more than once
+func TestDoubleClose(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_double_close")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithBackgroundCompaction(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+
+	require.NoError(t, engine.Close())
+	require.NoError(t, engine.Close())
+}
+
+// Test for using the engine after it's closed
+func TestOperationsAfterClose(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_operations_after_close")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Close())
+
+	assert.ErrorIs(t, engine.Put("name", "badger"), ErrEngineClosed)
+	assert.ErrorIs(t, engine.PutWithTTL("name", "badger", time.Hour), ErrEngineClosed)
+	assert.ErrorIs(t, engine.Delete("name"), ErrEngineClosed)
+	assert.ErrorIs(t, engine.Compact(), ErrEngineClosed)
+
+	_, err = engine.Get("name")
+	assert.ErrorIs(t, err, ErrEngineClosed)
+
+	_, err = engine.Exists("name")
+	assert.ErrorIs(t, err, ErrEngineClosed)
+
+	err = engine.ForEach(func(key, value string) error { return nil })
+	assert.ErrorIs(t, err, ErrEngineClosed)
+}
+
+func TestDeleteRange(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_delete_range")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for _, key := range []string{"tenant1/a", "tenant1/b", "tenant1/c", "tenant1/d", "tenant10/a", "tenant2/a"} {
+		require.NoError(t, engine.Put(key, "value"))
+	}
+	require.NoError(t, engine.Delete("tenant1/d"))
+
+	deleted, err := engine.DeleteRange("tenant1/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted, "Expected only the live keys in the prefix to be counted")
+
+	for _, key := range []string{"tenant1/a", "tenant1/b", "tenant1/c", "tenant1/d"} {
+		_, err := engine.Get(key)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	for _, key := range []string{"tenant10/a", "tenant2/a"} {
+		value, err := engine.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+
+	deleted, err = engine.DeleteRange("tenant1/")
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+	require.NoError(t, engine.Close())
+
+	// the write log with the tombstones becomes a read log, so the compaction drops the deleted keys
+	engine, err = NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Compact())
+	for _, log := range engine.readLogs {
+		for key := range log.index {
+			assert.False(t, strings.HasPrefix(key, "tenant1/"), "Expected %s to be reclaimed by compaction", key)
+		}
+	}
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestReopen(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_reopen")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	dataDir := filepath.Join(tempDir, "data")
+	otherDir := filepath.Join(tempDir, "other")
+
+	engine, err := NewEngine(dataDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("name", "gopher"))
+
+	other, err := NewEngine(otherDir)
+	require.NoError(t, err)
+	require.NoError(t, other.Put("added", "behind the engine"))
+	require.NoError(t, other.Close())
+	otherFiles, err := extractDatafiles(osFS{}, ensureTrailingSlash(otherDir))
+	require.NoError(t, err)
+	require.Len(t, otherFiles, 1)
+	data, err := os.ReadFile(otherFiles[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, dataFileName(100, 0, 0)), data, 0o644))
+
+	_, err = engine.Get("added")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, engine.Reopen())
+
+	value, err := engine.Get("added")
+	require.NoError(t, err)
+	assert.Equal(t, "behind the engine", value)
+	value, err = engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	// the new write log is numbered after the added data file
+	require.NoError(t, engine.Put("name", "badger"))
+	assert.Equal(t, 101, extractFileNumber(engine.writeLog.file.Name()))
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	require.NoError(t, engine.Close())
+	assert.ErrorIs(t, engine.Reopen(), ErrEngineClosed)
+}
+
+func TestRepairIndex(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_repair_index")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("deleted", "value"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("other", "badger"))
+	require.NoError(t, engine.Delete("deleted"))
+
+	// the indexes diverge from the data files
+	delete(engine.readLogs[0].index, "name")
+	entry := engine.writeLog.index["other"]
+	entry.offset += 3
+	engine.writeLog.index["other"] = entry
+	delete(engine.writeLog.index, "deleted")
+	engine.writeLog.index["ghost"] = indexEntry{offset: fileHeaderSize}
+
+	require.NoError(t, engine.RepairIndex())
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+	value, err = engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+	for _, key := range []string{"deleted", "ghost"} {
+		_, err = engine.Get(key)
+		assert.ErrorIs(t, err, ErrKeyNotFound, key)
+	}
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// the write log is still written after the repair
+	require.NoError(t, engine.Put("after", "repair"))
+	value, err = engine.Get("after")
+	require.NoError(t, err)
+	assert.Equal(t, "repair", value)
+
+	require.NoError(t, engine.Close())
+	assert.ErrorIs(t, engine.RepairIndex(), ErrEngineClosed)
+}
+
+// shortWriteFile writes only the first half of the data of the next write and fails it
+type shortWriteFile struct {
+	File
+}
+
+func (f *shortWriteFile) Write(data []byte) (int, error) {
+	n, _ := f.File.Write(data[:len(data)/2])
+	return n, errors.New("disk full")
+}
+
+func TestPartialWriteIsRolledBack(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_partial_write")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	sizeBefore := engine.writeLog.size
+
+	file := engine.writeLog.file
+	engine.writeLog.file = &shortWriteFile{File: file}
+	require.Error(t, engine.Put("other", "otter"))
+	engine.writeLog.file = file
+
+	assert.Equal(t, sizeBefore, engine.writeLog.size)
+	stat, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, sizeBefore, stat.Size(), "Expected the partial record to be truncated")
+
+	// the next record is appended right after the last valid record
+	require.NoError(t, engine.Put("last", "lynx"))
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	report, err := engine.Verify()
+	require.NoError(t, err)
+	for _, file := range report.Files {
+		assert.Zero(t, file.Errors, "Expected no partial record in %s", file.Path)
+	}
+
+	value, err := engine.Get("last")
+	require.NoError(t, err)
+	assert.Equal(t, "lynx", value)
+	_, err = engine.Get("other")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+// failingReadFS fails the positioned reads of the files it opens while failing is set
+type failingReadFS struct {
+	osFS
+	failing bool
+}
+
+func (fsys *failingReadFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := fsys.osFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &failingReadFile{File: file, fsys: fsys}, nil
+}
+
+type failingReadFile struct {
+	File
+	fsys *failingReadFS
+}
+
+func (f *failingReadFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.fsys.failing {
+		return 0, errReadFailed
+	}
+	return f.File.ReadAt(p, off)
+}
+
+var errReadFailed = errors.New("input/output error")
+
+func TestReadErrorIsNotReportedAsNotFound(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_read_error")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	fsys := &failingReadFS{}
+	engine, err := NewEngine(tempDir, WithFileSystem(fsys))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("read", "value"))
+	require.NoError(t, engine.Put("deleted", "value"))
+	require.NoError(t, engine.Delete("deleted"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("written", "value"))
+
+	// the failed reads of the write log and the read logs surface as they are
+	fsys.failing = true
+	for _, key := range []string{"read", "written"} {
+		_, err = engine.Get(key)
+		assert.ErrorIs(t, err, errReadFailed, key)
+		assert.NotErrorIs(t, err, ErrKeyNotFound, key)
+	}
+
+	// the missing and the deleted keys are known from the index without reading the disk
+	for _, key := range []string{"deleted", "missing"} {
+		_, err = engine.Get(key)
+		assert.ErrorIs(t, err, ErrKeyNotFound, key)
+	}
+
+	fsys.failing = false
+	value, err := engine.Get("read")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
 func removeDir(dirname string) error {
 	if err := os.RemoveAll(dirname); err != nil && !os.IsNotExist(err) {
 		return err
@@ -176,3 +1221,232 @@ func removeDir(dirname string) error {
 
 	return nil
 }
+
+func TestTinyLogsWarning(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_tiny_logs_warning")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	open := func(options ...OptionSetter) {
+		engine, err := NewEngine(tempDir, options...)
+		require.NoError(t, err)
+		require.NoError(t, engine.Close())
+	}
+
+	open(WithMaxLogSize(tinyLogSize))
+	assert.Empty(t, logs.String())
+	open(WithMaxLogSize(tinyLogSize-1), WithAllowTinyLogs(true))
+	assert.Empty(t, logs.String())
+
+	open(WithMaxLogSize(1))
+	assert.Contains(t, logs.String(), "max log size is too small")
+	assert.Contains(t, logs.String(), "maxLogSize=1")
+}
+
+func TestDropAll(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_drop_all")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.PutWithTags(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i), "tag"))
+	}
+	require.NotEmpty(t, engine.readLogs)
+	snapshot, err := engine.Snapshot()
+	require.NoError(t, err)
+
+	// the reads run concurrently with dropping the keys
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			_, err := engine.Get(fmt.Sprintf("key%d", i%20))
+			if err != nil {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}
+	}()
+	require.NoError(t, engine.DropAll())
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		_, err := engine.Get(fmt.Sprintf("key%d", i))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	keys, err := engine.KeysByTag("tag")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// the snapshot still reads the dropped data files, which are removed once it's closed
+	value, err := snapshot.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", value)
+	require.NoError(t, snapshot.Close())
+	dataFiles, err := extractDatafiles(osFS{}, ensureTrailingSlash(tempDir))
+	require.NoError(t, err)
+	// the new write log is empty so it's not listed
+	assert.Empty(t, dataFiles)
+
+	require.NoError(t, engine.Put("key1", "new"))
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	value, err = engine.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, "new", value)
+	_, err = engine.Get("key2")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestRotateLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_rotate_log")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key1", "value1"))
+	require.NoError(t, engine.Put("key2", "value2"))
+	oldPath := engine.writeLog.file.Name()
+	require.Empty(t, engine.readLogs)
+
+	require.NoError(t, engine.RotateLog())
+	require.Len(t, engine.readLogs, 1)
+	assert.Equal(t, oldPath, engine.readLogs[0].path)
+	newPath := engine.writeLog.file.Name()
+	assert.NotEqual(t, oldPath, newPath)
+	assert.True(t, strings.HasSuffix(newPath, dataFileFormatSuffix))
+	_, err = os.Stat(newPath)
+	require.NoError(t, err)
+
+	// an empty write log is not rotated again
+	require.NoError(t, engine.RotateLog())
+	assert.Len(t, engine.readLogs, 1)
+	assert.Equal(t, newPath, engine.writeLog.file.Name())
+
+	for _, key := range []string{"key1", "key2"} {
+		value, err := engine.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, "value"+strings.TrimPrefix(key, "key"), value)
+	}
+	require.NoError(t, engine.Put("key3", "value3"))
+	value, err := engine.Get("key3")
+	require.NoError(t, err)
+	assert.Equal(t, "value3", value)
+}
+
+func TestNamespaces(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_namespaces")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	users, err := NewEngine(tempDir, WithNamespace("users"), WithMaxLogSize(128))
+	require.NoError(t, err)
+	orders, err := NewEngine(tempDir, WithNamespace("orders"), WithMaxLogSize(128))
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		require.NoError(t, users.Put(key, "user"+key))
+		require.NoError(t, orders.Put(key, "order"+key))
+	}
+	require.NoError(t, orders.Delete("key0"))
+	require.NoError(t, users.Compact())
+	assert.DirExists(t, filepath.Join(tempDir, "users"))
+	assert.DirExists(t, filepath.Join(tempDir, "orders"))
+
+	check := func(users, orders *Engine) {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value, err := users.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, "user"+key, value)
+			value, err = orders.Get(key)
+			if i == 0 {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+				continue
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "order"+key, value)
+		}
+	}
+	check(users, orders)
+
+	require.NoError(t, users.Close())
+	require.NoError(t, orders.Close())
+	users, err = NewEngine(tempDir, WithNamespace("users"))
+	require.NoError(t, err)
+	defer users.Close()
+	orders, err = NewEngine(tempDir, WithNamespace("orders"))
+	require.NoError(t, err)
+	defer orders.Close()
+	check(users, orders)
+
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		_, err := NewEngine(tempDir, WithNamespace(name))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_key_normalizer")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithKeyNormalizer(strings.ToLower))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("user", "gopher"))
+	value, err := engine.Get("USER")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	require.NoError(t, engine.Put("User", "badger"))
+	value, err = engine.Get("user")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+	count, err := engine.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	batch := engine.NewBatch()
+	batch.Put("Team/One", "1")
+	batch.Put("TEAM/TWO", "2")
+	require.NoError(t, batch.Commit())
+
+	// the scans take the prefix in any case and return the normalized keys
+	keys, err := engine.KeysWithPrefix("Team/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/one", "team/two"}, keys)
+	visited := make(map[string]string)
+	require.NoError(t, engine.ScanPrefix("TEAM/", func(key, value string) error {
+		visited[key] = value
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"team/one": "1", "team/two": "2"}, visited)
+
+	require.NoError(t, engine.Delete("USER"))
+	_, err = engine.Get("user")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	deleted, err := engine.DeleteRange("TEAM/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	_, err = NewEngine(tempDir, WithKeyNormalizer(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// an export stream starts with a header magic|version followed by an entry for every live key
+// keySize|valueSize|expiry|tagsSize|key|tags|value, and ends with an entry with a zero key size. Keys
+// can't be empty, so the end marker tells a complete stream apart from a truncated one. The tags are
+// encoded like in the records, the streams of the first version have no tags.
+const (
+	exportFormatVersionNoTags = 1
+	exportFormatVersion       = 2
+	exportHeaderSize          = 5
+	exportEntrySize           = 20
+	exportEntrySizeNoTags     = 16
+)
+
+var exportMagic = []byte("KSHX")
+
+// Export writes the latest value of every live key to w as a length-prefixed stream, which can be
+// loaded into another engine with Import together with their expiry times and tags. Unlike copying the data files the stream only contains
+// the live keys, superseded records and tombstones are dropped. The values are written one by one,
+// and the read lock is held for the whole export like ForEach.
+func (e *Engine) Export(w io.Writer) error {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	writer := bufio.NewWriter(w)
+	header := make([]byte, exportHeaderSize)
+	copy(header, exportMagic)
+	header[4] = exportFormatVersion
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	now := e.clock.Now()
+	entry := make([]byte, exportEntrySize)
+	err := e.walkInFileOrder(context.Background(), func(key string, indexEntry indexEntry, readValue func() (string, error)) error {
+		if !indexEntry.live(now) {
+			return nil
+		}
+		value, err := readValue()
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(entry, uint32(len(key)))
+		binary.LittleEndian.PutUint32(entry[4:], uint32(len(value)))
+		binary.LittleEndian.PutUint64(entry[8:], uint64(indexEntry.expiry))
+		tags := encodeTags(e.tags.tags[key])
+		binary.LittleEndian.PutUint32(entry[16:], uint32(len(tags)))
+		if _, err := writer.Write(entry); err != nil {
+			return err
+		}
+		if _, err := writer.WriteString(key); err != nil {
+			return err
+		}
+		if _, err := writer.Write(tags); err != nil {
+			return err
+		}
+		_, err = writer.WriteString(value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the end marker
+	clear(entry)
+	if _, err := writer.Write(entry); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Import puts every key of a stream written by Export into the engine, keeping the expiry time and
+// the tags of the keys. The entries are read and written one by one, so the stream is never fully buffered.
+// Keys which expired since the export are skipped. If the stream is truncated the entries before
+// the truncation are already imported and io.ErrUnexpectedEOF is returned.
+func (e *Engine) Import(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	header := make([]byte, exportHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("failed to read export header: %w", err)
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) {
+		return fmt.Errorf("invalid export header")
+	}
+	var entry []byte
+	switch header[4] {
+	case exportFormatVersionNoTags:
+		entry = make([]byte, exportEntrySizeNoTags)
+	case exportFormatVersion:
+		entry = make([]byte, exportEntrySize)
+	default:
+		return fmt.Errorf("unsupported export format version %d", header[4])
+	}
+
+	for {
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		keySize := int64(binary.LittleEndian.Uint32(entry))
+		if keySize == 0 {
+			return nil
+		}
+		valueSize := int64(binary.LittleEndian.Uint32(entry[4:]))
+		expiry := int64(binary.LittleEndian.Uint64(entry[8:]))
+		var tagsSize int64
+		if len(entry) == exportEntrySize {
+			tagsSize = int64(binary.LittleEndian.Uint32(entry[16:]))
+		}
+		if keySize > e.maxKeyBytes || valueSize > e.maxValueBytes {
+			return fmt.Errorf("export entry is too large, key size %d and value size %d", keySize, valueSize)
+		}
+
+		data := make([]byte, keySize+tagsSize+valueSize)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if expiry != 0 && expiry <= e.clock.Now().UnixNano() {
+			continue
+		}
+		tags, err := decodeTags(data[keySize : keySize+tagsSize])
+		if err != nil {
+			return fmt.Errorf("invalid tags of export entry: %w", err)
+		}
+		if tags, err = e.validateTags(tags); err != nil {
+			return err
+		}
+		rec := record{key: string(data[:keySize]), value: string(data[keySize+tagsSize:]), expiry: expiry, tags: tags}
+		if err := e.putRecord(rec); err != nil {
+			return err
+		}
+	}
+}
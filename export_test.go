@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportAndImport(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "export_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	src, err := NewEngine(filepath.Join(tempDir, "src"), WithMaxLogSize(4*KB))
+	require.NoError(t, err)
+	defer src.Close()
+
+	expected := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		require.NoError(t, src.Put(key, value))
+		expected[key] = value
+	}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Put(fmt.Sprintf("key%d", i), "updated"))
+		expected[fmt.Sprintf("key%d", i)] = "updated"
+	}
+	for i := 10; i < 20; i++ {
+		require.NoError(t, src.Delete(fmt.Sprintf("key%d", i)))
+		delete(expected, fmt.Sprintf("key%d", i))
+	}
+	expected["large"] = strings.Repeat("x", 2*KB)
+	require.NoError(t, src.Put("large", expected["large"]))
+	expected["ttl"] = "value"
+	require.NoError(t, src.PutWithTTL("ttl", "value", time.Hour))
+	expected["tagged"] = "value"
+	require.NoError(t, src.PutWithTags("tagged", "value", "red", "blue"))
+	expected["retagged"] = "value"
+	require.NoError(t, src.PutWithTags("retagged", "value", "red"))
+	require.NoError(t, src.Put("retagged", "value"))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Export(&buf))
+
+	dst, err := NewEngine(filepath.Join(tempDir, "dst"), WithMaxLogSize(4*KB))
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.Import(bytes.NewReader(buf.Bytes())))
+
+	imported := make(map[string]string)
+	require.NoError(t, dst.ForEach(func(key, value string) error {
+		imported[key] = value
+		return nil
+	}))
+	assert.Equal(t, expected, imported)
+
+	entry, _ := dst.latestEntry("ttl")
+	assert.NotZero(t, entry.expiry, "Expected the expiry time to be imported")
+	tagged, err := dst.KeysByTag("red")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tagged"}, tagged, "Expected the latest tags to be imported")
+	tagged, err = dst.KeysByTag("blue")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tagged"}, tagged)
+
+	// the superseded records and tombstones are not exported
+	count, err := dst.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, len(expected), count)
+	assert.Len(t, dst.writeLog.index, len(expected))
+
+	// a truncated stream is detected
+	err = dst.Import(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Error(t, dst.Import(strings.NewReader("not an export")))
+}
+
+func TestImportFirstFormatVersion(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "import_first_version_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// a stream of the first version has no tags in its entries
+	stream := append([]byte(nil), exportMagic...)
+	stream = append(stream, exportFormatVersionNoTags)
+	stream = binary.LittleEndian.AppendUint32(stream, 3)
+	stream = binary.LittleEndian.AppendUint32(stream, 5)
+	stream = binary.LittleEndian.AppendUint64(stream, 0)
+	stream = append(stream, "keyvalue"...)
+	stream = append(stream, make([]byte, exportEntrySizeNoTags)...)
+	require.NoError(t, engine.Import(bytes.NewReader(stream)))
+
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// fileCache keeps a bounded number of read-only file handles open, keyed by log path,
+// so repeated reads from the same log don't pay for an open/close cycle on every Get.
+// When the cache is full the least recently used handle is evicted. A handle that is
+// still in use by a reader when it's evicted is closed as soon as the last reader releases it.
+type fileCache struct {
+	lock sync.Mutex
+	// capacity represents the max number of the cached handles, zero keeps all of them
+	capacity int
+	entries  map[string]*list.Element
+	// order keeps the cached files from the most recently used (front) to the least recently used (back)
+	order *list.List
+	// closed is set when the cache is closed, no new handles are opened afterwards
+	closed bool
+	// mmap enables memory mapping the files acquired by acquireMapped
+	mmap bool
+	// fs represents the file system the files are opened from
+	fs FS
+}
+
+type cachedFile struct {
+	path    string
+	file    File
+	refs    int
+	evicted bool
+	// data is the memory mapping of the file, it's nil if the file is not mapped
+	data []byte
+	// mmapFailed is set when mapping the file failed, so it's not retried on every read
+	mmapFailed bool
+}
+
+// Stat returns the info of the file, its size bounds the records read from it
+func (cf *cachedFile) Stat() (os.FileInfo, error) {
+	return cf.file.Stat()
+}
+
+// ReadAt reads from the memory mapping of the file if it's mapped, otherwise from the file itself
+func (cf *cachedFile) ReadAt(p []byte, off int64) (int, error) {
+	if cf.data == nil {
+		return cf.file.ReadAt(p, off)
+	}
+	if off >= int64(len(cf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, cf.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// close unmaps and closes the file
+func (cf *cachedFile) close() error {
+	var errs []error
+	if cf.data != nil {
+		errs = append(errs, munmapFile(cf.data))
+		cf.data = nil
+	}
+	errs = append(errs, cf.file.Close())
+	return errors.Join(errs...)
+}
+
+func newFileCache(fsys FS, capacity int) *fileCache {
+	return &fileCache{
+		fs:       fsys,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// acquire returns an open read-only handle for the given path, opening it if it's not cached yet.
+// every successful acquire must be followed by a release once the caller is done with the handle.
+func (c *fileCache) acquire(path string) (*cachedFile, error) {
+	return c.acquireFile(path, false)
+}
+
+// acquireMapped works like acquire, but also memory maps the file if memory mapped reads are enabled.
+// It must only be used for the files which are not written anymore, as the mapping doesn't grow with
+// the file. If the file can't be mapped it's read through the file handle.
+func (c *fileCache) acquireMapped(path string) (*cachedFile, error) {
+	return c.acquireFile(path, c.mmap)
+}
+
+func (c *fileCache) acquireFile(path string, mapped bool) (*cachedFile, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil, ErrEngineClosed
+	}
+
+	var cf *cachedFile
+	if element, ok := c.entries[path]; ok {
+		cf = element.Value.(*cachedFile)
+		// a file which was cached while it was the write log is mapped on its first mapped read. The
+		// readers of the cached handle read it without the lock, so the mapping is made in a new entry
+		// and the old one is closed once its readers release it.
+		if mapped && cf.data == nil && !cf.mmapFailed {
+			_ = c.removeElement(element)
+			cf = nil
+		} else {
+			c.order.MoveToFront(element)
+			cf.refs++
+		}
+	}
+	if cf != nil {
+		return cf, nil
+	}
+
+	file, err := c.fs.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cf = &cachedFile{path: path, file: file, refs: 1}
+	// the new entry is mapped before it's shared, only the files of the operating system can be mapped
+	if mapped {
+		if file, ok := osFile(cf.file); !ok {
+			cf.mmapFailed = true
+		} else if data, err := mmapFile(file); err != nil {
+			slog.Warn("failed to memory map the file, reading it through the file handle", "path", path, "err", err)
+			cf.mmapFailed = true
+		} else {
+			cf.data = data
+		}
+	}
+	c.entries[path] = c.order.PushFront(cf)
+
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+
+	return cf, nil
+}
+
+// openReadLogs opens the handles of the read logs in the file cache if all the files are kept open,
+// a log which can't be opened is opened again on its first read
+func (e *Engine) openReadLogs(logs []*readLog) {
+	if !e.keepAllFilesOpen {
+		return
+	}
+	for _, log := range logs {
+		cf, err := e.fileCache.acquireMapped(log.path)
+		if err != nil {
+			slog.Warn("failed to open read log", "path", log.path, "err", err)
+			continue
+		}
+		e.fileCache.release(cf)
+	}
+}
+
+// release marks the caller as done with the handle, closing it if it was evicted in the meantime.
+func (c *fileCache) release(cf *cachedFile) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cf.refs--
+	if cf.evicted && cf.refs == 0 {
+		_ = cf.close()
+	}
+}
+
+// evict removes the handle for the given path from the cache, it's used when a log file is
+// moved or removed so that later reads don't use a descriptor pointing to the old file.
+func (c *fileCache) evict(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[path]; ok {
+		c.removeElement(element)
+	}
+}
+
+// close evicts all the cached handles and closes the ones which are not in use anymore.
+func (c *fileCache) close() error {
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+
+	return c.evictAll()
+}
+
+// evictAll removes all the handles from the cache and closes the ones which are not in use anymore,
+// it's used when the data files may be replaced behind the engine
+func (c *fileCache) evictAll() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var errs []error
+	for c.order.Len() > 0 {
+		if err := c.removeElement(c.order.Back()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *fileCache) removeElement(element *list.Element) error {
+	cf := element.Value.(*cachedFile)
+	c.order.Remove(element)
+	delete(c.entries, cf.path)
+	cf.evicted = true
+	if cf.refs == 0 {
+		return cf.close()
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestFiles(t *testing.T, dir string, count int) []string {
+	paths := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d%s", i+1, dataFileFormatSuffix))
+		require.NoError(t, os.WriteFile(path, []byte("test"), 0o644))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestFileCacheReusesHandles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_file_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	paths := createTestFiles(t, tempDir, 1)
+	cache := newFileCache(osFS{}, 2)
+
+	first, err := cache.acquire(paths[0])
+	require.NoError(t, err)
+	cache.release(first)
+
+	second, err := cache.acquire(paths[0])
+	require.NoError(t, err)
+	cache.release(second)
+
+	assert.Same(t, first.file, second.file, "Expected the cached handle to be reused")
+	require.NoError(t, cache.close())
+}
+
+func TestFileCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_file_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	paths := createTestFiles(t, tempDir, 3)
+	cache := newFileCache(osFS{}, 2)
+
+	for _, path := range paths[:2] {
+		cf, err := cache.acquire(path)
+		require.NoError(t, err)
+		cache.release(cf)
+	}
+
+	// use the first file again so the second one becomes the least recently used
+	first, err := cache.acquire(paths[0])
+	require.NoError(t, err)
+	cache.release(first)
+
+	third, err := cache.acquire(paths[2])
+	require.NoError(t, err)
+	cache.release(third)
+
+	assert.Len(t, cache.entries, 2)
+	assert.Contains(t, cache.entries, paths[0])
+	assert.Contains(t, cache.entries, paths[2])
+	assert.NotContains(t, cache.entries, paths[1])
+
+	require.NoError(t, cache.close())
+	assert.Empty(t, cache.entries)
+}
+
+func TestFileCacheKeepsEvictedHandleOpenUntilReleased(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_file_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	paths := createTestFiles(t, tempDir, 1)
+	cache := newFileCache(osFS{}, 1)
+
+	cf, err := cache.acquire(paths[0])
+	require.NoError(t, err)
+
+	cache.evict(paths[0])
+	buffer := make([]byte, 4)
+	_, err = cf.file.ReadAt(buffer, 0)
+	require.NoError(t, err, "Expected an evicted handle to stay open while in use")
+
+	cache.release(cf)
+	_, err = cf.file.ReadAt(buffer, 0)
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestGetWithMaxOpenFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_max_open_files")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(16), WithAllowTinyLogs(true), WithMaxOpenFiles(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value%d", i), value)
+		}
+	}
+	assert.LessOrEqual(t, len(engine.fileCache.entries), 2)
+
+	require.NoError(t, engine.Close())
+	assert.Empty(t, engine.fileCache.entries)
+}
+
+func TestKeepAllFilesOpen(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_keep_all_files_open")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(64), WithMaxOpenFiles(2), WithKeepAllFilesOpen(true))
+	require.NoError(t, err)
+
+	// assertReadLogsOpen checks that exactly the read logs have an open handle
+	assertReadLogsOpen := func() {
+		require.Len(t, engine.fileCache.entries, len(engine.readLogs))
+		for _, log := range engine.readLogs {
+			assert.Contains(t, engine.fileCache.entries, log.path)
+		}
+	}
+	assertReadLogsOpen()
+	require.Greater(t, len(engine.readLogs), 2)
+
+	for i := 0; i < 20; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), value)
+	}
+
+	// a rotated write log is opened as a read log, and the compacted logs replace the old ones
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.RotateLog())
+	assertReadLogsOpen()
+	require.NoError(t, engine.Compact())
+	assertReadLogsOpen()
+
+	var files []File
+	for _, element := range engine.fileCache.entries {
+		files = append(files, element.Value.(*cachedFile).file)
+	}
+	require.NoError(t, engine.Close())
+	assert.Empty(t, engine.fileCache.entries)
+	for _, file := range files {
+		_, err := file.Stat()
+		assert.ErrorIs(t, err, os.ErrClosed)
+	}
+}
+
+func TestInvalidMaxOpenFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_max_open_files")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithMaxOpenFiles(0))
+	assert.Error(t, err)
+}
+
+func TestGetWithMmapReads(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_mmap_reads")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64), WithMmapReads(true))
+	require.NoError(t, err)
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d_%d", i, round)))
+		}
+	}
+
+	verify := func() {
+		for i := 0; i < 10; i++ {
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value%d_1", i), value)
+		}
+	}
+	verify()
+
+	// the read logs are mapped while the write log is read through its file handle
+	for path, element := range engine.fileCache.entries {
+		cf := element.Value.(*cachedFile)
+		if path == engine.writeLog.file.Name() {
+			assert.Nil(t, cf.data, "Expected the write log not to be mapped")
+		} else if runtime.GOOS != "windows" {
+			assert.NotNil(t, cf.data, "Expected the read log %s to be mapped", path)
+		}
+	}
+
+	// the mappings of the replaced logs are released by the compaction
+	require.NoError(t, engine.Compact())
+	verify()
+
+	require.NoError(t, engine.Close())
+	assert.Empty(t, engine.fileCache.entries)
+}
+
+// Test for streaming a value of the write log while the log is rotated and read through its mapping,
+// run with -race to catch the reader seeing the mapping being set on its handle
+func TestGetReaderWhileWriteLogIsMapped(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_reader_mapped")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMmapReads(true))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	value := strings.Repeat("x", 64*KB)
+	require.NoError(t, engine.Put("key", value))
+	reader, err := engine.GetReader("key")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var streamed []byte
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buffer := make([]byte, 512)
+		for {
+			n, err := reader.Read(buffer)
+			streamed = append(streamed, buffer[:n]...)
+			if err != nil {
+				assert.ErrorIs(t, err, io.EOF)
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, engine.RotateLog())
+	for i := 0; i < 20; i++ {
+		got, err := engine.Get("key")
+		require.NoError(t, err)
+		assert.Equal(t, len(value), len(got))
+	}
+	wg.Wait()
+	require.NoError(t, reader.Close())
+	assert.Equal(t, value, string(streamed))
+}
+
+func BenchmarkRandomGet(b *testing.B) {
+	benchmarkRandomGet(b, false)
+}
+
+func BenchmarkRandomGetWithMmapReads(b *testing.B) {
+	benchmarkRandomGet(b, true)
+}
+
+func benchmarkRandomGet(b *testing.B, mmap bool) {
+	tempDir, err := os.MkdirTemp("", "benchmark_random_get")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	const keys = 100000
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1*MB), WithMmapReads(mmap))
+	require.NoError(b, err)
+	value := string(make([]byte, 256))
+	for i := 0; i < keys; i++ {
+		require.NoError(b, engine.Put(fmt.Sprintf("key%d", i), value))
+	}
+	require.NoError(b, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithMaxLogSize(1*MB), WithMmapReads(mmap))
+	require.NoError(b, err)
+	defer engine.Close()
+
+	random := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := engine.Get(fmt.Sprintf("key%d", random.Intn(keys))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FS represents the file system the storage engine keeps its files in, by default it's the file
+// system of the operating system. The paths are passed to it as they are given to the engine.
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// File represents an open file of an FS, the errors for missing files must satisfy os.IsNotExist
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// osFS is the FS backed by the file system of the operating system
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// dirSyncer is implemented by the FS which can sync a directory, so the files created in it and
+// renamed into it survive a crash. The FS which doesn't implement it has nothing to sync.
+type dirSyncer interface {
+	SyncDir(name string) error
+}
+
+func (osFS) SyncDir(name string) error {
+	return syncDirectory(name)
+}
+
+// syncDir syncs the directory in name if the file system supports it
+func syncDir(fsys FS, name string) error {
+	if syncer, ok := fsys.(dirSyncer); ok {
+		return syncer.SyncDir(name)
+	}
+	return nil
+}
+
+// readFile reads the whole file in name
+func readFile(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// truncateFile changes the size of the file in name
+func truncateFile(fsys FS, name string, size int64) error {
+	file, err := fsys.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeAll removes the path and everything it contains, a missing path is not an error
+func removeAll(fsys FS, path string) error {
+	stat, err := fsys.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if stat.IsDir() {
+		entries, err := fsys.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := removeAll(fsys, filepath.Join(path, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fsys.Remove(path)
+}
+
+// osFile returns the file of the operating system behind the file, if there is one, so it can be
+// locked or memory mapped
+func osFile(file File) (*os.File, bool) {
+	if retried, ok := file.(retryFile); ok {
+		file = retried.File
+	}
+	osFile, ok := file.(*os.File)
+	return osFile, ok
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// gcManager runs the garbage collector, a lighter alternative to compaction which rewrites only
+// the read logs with a high ratio of dead bytes instead of merging all the read logs
+type gcManager struct {
+	interval time.Duration
+	// deadRatio represents the ratio of dead bytes above which a read log is rewritten
+	deadRatio float64
+	ticker    *time.Ticker
+	// cancel signals the garbage collector goroutine to exit and cancels its running collection
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// WithGarbageCollection runs the garbage collector on the given interval. Each run rewrites the
+// read logs whose dead bytes, the overwritten, deleted and expired records, are more than
+// deadRatio of the log size, so the space is reclaimed without rewriting the mostly live logs.
+// The garbage collector and compaction never run at the same time.
+func WithGarbageCollection(interval time.Duration, deadRatio float64) OptionSetter {
+	return func(engine *Engine) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid garbage collection interval")
+		}
+		if deadRatio <= 0 || deadRatio >= 1 {
+			return fmt.Errorf("invalid garbage collection dead ratio")
+		}
+		engine.gcManager.interval = interval
+		engine.gcManager.deadRatio = deadRatio
+		return nil
+	}
+}
+
+// startGarbageCollection starts running the garbage collector on the configured interval
+func (e *Engine) startGarbageCollection() {
+	e.gcManager.ticker = time.NewTicker(e.gcManager.interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	e.gcManager.cancel = cancel
+	e.gcManager.wg.Add(1)
+	go func() {
+		defer e.gcManager.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.gcManager.ticker.C:
+				if err := e.collectGarbage(ctx, e.gcManager.deadRatio); err != nil && !errors.Is(err, context.Canceled) {
+					slog.Warn("failed to run garbage collection", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopGarbageCollection stops the garbage collector, cancelling its in-flight collection
+func (e *Engine) stopGarbageCollection() {
+	if e.gcManager.ticker != nil {
+		e.gcManager.ticker.Stop()
+		e.gcManager.cancel()
+		e.gcManager.wg.Wait()
+		e.gcManager.ticker = nil
+	}
+}
+
+// collectGarbage rewrites the read logs whose ratio of dead bytes is more than deadRatio
+func (e *Engine) collectGarbage(ctx context.Context, deadRatio float64) error {
+	// the compaction lock makes sure the read logs are not replaced by a compaction meanwhile
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+
+	e.lock.RLock()
+	if e.closed {
+		e.lock.RUnlock()
+		return ErrEngineClosed
+	}
+	snapshotReadLogs := make([]*readLog, len(e.readLogs))
+	copy(snapshotReadLogs, e.readLogs)
+	// the keys written after the snapshot only make more records dead, so it's safe to ignore them
+	shadowed := make(map[string]struct{}, len(e.writeLog.index))
+	for key := range e.writeLog.index {
+		shadowed[key] = struct{}{}
+	}
+	e.lock.RUnlock()
+
+	now := e.clock.Now()
+	for i := len(snapshotReadLogs) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		log := snapshotReadLogs[i]
+		if deadBytesRatio(log, shadowed, now) > deadRatio {
+			if err := e.rewriteLog(log, snapshotReadLogs[:i], shadowed, now); err != nil {
+				return fmt.Errorf("failed to rewrite %s: %w", log.path, err)
+			}
+		}
+
+		for key := range log.index {
+			shadowed[key] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// deadBytesRatio estimates the ratio of the bytes of the log which can be reclaimed, these are the
+// records shadowed by newer logs, tombstones and expired records
+func deadBytesRatio(log *readLog, shadowed map[string]struct{}, now time.Time) float64 {
+	size := log.size
+	if log.version != formatVersionLegacy {
+		size -= fileHeaderSize
+	}
+	if size <= 0 {
+		return 0
+	}
+
+	liveBytes, _ := liveRecords(log, shadowed, now)
+	return float64(size-liveBytes) / float64(size)
+}
+
+// liveRecords returns the size and the number of the live records of the log which are not shadowed
+// by newer logs
+func liveRecords(log *readLog, shadowed map[string]struct{}, now time.Time) (int64, int) {
+	var liveBytes int64
+	var liveKeys int
+	for key, entry := range log.index {
+		if _, ok := shadowed[key]; !ok && entry.live(now) {
+			liveBytes += entry.size
+			liveKeys++
+		}
+	}
+	return liveBytes, liveKeys
+}
+
+// existsInLogs reports whether any of the logs has a record of the key
+func existsInLogs(logs []*readLog, key string) bool {
+	for _, log := range logs {
+		if _, ok := log.index[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLog rewrites the log with only its records which are still needed. These are the live
+// records which are not shadowed by a newer log, and the deletions of the keys which still have
+// records in the older logs, as dropping those would bring the older records back.
+func (e *Engine) rewriteLog(log *readLog, olderLogs []*readLog, shadowed map[string]struct{}, now time.Time) (err error) {
+	path := nextGenerationPath(log.path)
+	tmpPath := path + ".tmp"
+	file, err := e.fs.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, e.fileMode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			e.fs.Remove(tmpPath)
+		}
+	}()
+
+	rewritten := &readLog{path: path, index: make(map[string]indexEntry), version: currentFormatVersion}
+	data := fileHeader()
+	for key, entry := range log.index {
+		if _, ok := shadowed[key]; ok {
+			continue
+		}
+
+		rec := tombstoneRecord(key)
+		if entry.live(now) {
+			rec.flags = 0
+			rec.value, err = e.readValueFromFile(log.path, entry.offset, log.version)
+			if err != nil {
+				return err
+			}
+			rec.expiry = entry.expiry
+			rec.tags = log.tags[key]
+		} else if !existsInLogs(olderLogs, key) {
+			continue
+		}
+
+		encodedValue, err := e.encodeValue(rec)
+		if err != nil {
+			return err
+		}
+		encoded := encodeRecord(encodedValue)
+		rewritten.index[key] = indexEntry{
+			offset:    int64(len(data)),
+			tombstone: rec.tombstone(),
+			expiry:    rec.expiry,
+			size:      int64(len(encoded)),
+		}
+		rewritten.tags = setLogTags(rewritten.tags, key, rec.tags)
+		data = append(data, encoded...)
+	}
+	rewritten.size = int64(len(data))
+	e.setBloomFilters([]*readLog{rewritten})
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = e.fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := writeHintFile(e.fs, rewritten, e.fileMode); err != nil {
+		slog.Warn("failed to write hint file", "path", path, "err", err)
+	}
+	// the new generation must survive a crash before the old one is removed
+	if err = e.syncDirs(path); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	for i, current := range e.readLogs {
+		if current == log {
+			e.readLogs[i] = rewritten
+		}
+	}
+	// the expired keys are dropped, so the count is rebuilt from the new indexes
+	e.keyCount = e.countKeys()
+	// the cached handle points to the old file which is about to be removed
+	e.fileCache.evict(log.path)
+	e.openReadLogs([]*readLog{rewritten})
+	e.lock.Unlock()
+
+	e.snapshotManager.removeUnpinned(func() {
+		if err := removeLogFiles(e.fs, log.path); err != nil {
+			slog.Warn("failed to remove rewritten data file", "path", log.path, "err", err)
+		}
+	})
+	return nil
+}
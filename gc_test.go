@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGarbageCollectionShrinksOverwrittenLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "garbage_collection_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithGarbageCollection(time.Second, 1.5))
+	require.Error(t, err)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(2*KB))
+	require.NoError(t, err)
+
+	// the first log has live keys and a key which is deleted in the second log
+	require.NoError(t, engine.Put("deleted", "value"))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("kept%d", i), "value"))
+	}
+	require.NoError(t, engine.rotateWriteLog())
+	// the second log is full of keys which are overwritten in the third log
+	for i := 0; i < 30; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Delete("deleted"))
+	require.NoError(t, engine.rotateWriteLog())
+	for i := 0; i < 25; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("new_value%d", i)))
+	}
+	require.NoError(t, engine.rotateWriteLog())
+
+	firstLog, secondLog, thirdLog := engine.readLogs[0], engine.readLogs[1], engine.readLogs[2]
+	require.NoError(t, engine.collectGarbage(context.Background(), 0.5))
+
+	// only the mostly dead second log is rewritten
+	require.Len(t, engine.readLogs, 3)
+	assert.Same(t, firstLog, engine.readLogs[0])
+	assert.Same(t, thirdLog, engine.readLogs[2])
+	assert.Equal(t, nextGenerationPath(secondLog.path), engine.readLogs[1].path)
+	assert.Less(t, engine.readLogs[1].size, secondLog.size/2)
+	_, err = os.Stat(secondLog.path)
+	assert.True(t, os.IsNotExist(err), "Expected the rewritten log to be removed")
+
+	// the tombstone is kept as the first log still has a record of the key
+	assert.Len(t, engine.readLogs[1].index, 6)
+	assert.True(t, engine.readLogs[1].index["deleted"].tombstone)
+
+	verify := func() {
+		for i := 0; i < 30; i++ {
+			expected := fmt.Sprintf("value%d", i)
+			if i < 25 {
+				expected = fmt.Sprintf("new_value%d", i)
+			}
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+		_, err := engine.Get("deleted")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		count, err := engine.KeyCount()
+		require.NoError(t, err)
+		assert.Equal(t, 40, count)
+	}
+	verify()
+
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir, WithMaxLogSize(1*KB))
+	require.NoError(t, err)
+	verify()
+	require.NoError(t, engine.Close())
+}
+
+func TestBackgroundGarbageCollection(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "background_garbage_collection_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(256), WithGarbageCollection(10*time.Millisecond, 0.5))
+	require.NoError(t, err)
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d_%d", i, round)))
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		engine.lock.RLock()
+		defer engine.lock.RUnlock()
+		for _, log := range engine.readLogs {
+			if extractFileGeneration(log.path) > 0 {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond, "Expected the garbage collector to rewrite a log")
+
+	for i := 0; i < 5; i++ {
+		value, err := engine.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d_9", i), value)
+	}
+	require.NoError(t, engine.Close())
+}
+
+func TestSupersededGenerationIsRemoved(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "superseded_generation_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	paths := removeSupersededGenerations(osFS{}, []string{"/data/1.dat", "/data/2.dat", "/data/2-1.dat", "/data/3.dat"}, true)
+	assert.Equal(t, []string{"/data/1.dat", "/data/2-1.dat", "/data/3.dat"}, paths)
+}
@@ -3,6 +3,7 @@ module github.com/rezkam/kashk/storage
 go 1.21.1
 
 require (
+	github.com/golang/snappy v0.0.4
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/sys v0.12.0
 )
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// hint files keep a copy of the index of a read log next to its data file, so the index can be
+// loaded at startup without reading the whole data file. A hint file starts with a header
+// magic|version|dataFileSize|dataFileVersion followed by the bloom filter of the log
+// bitsPerKey|words|bits, which is empty if the log doesn't have one, then the index entries
+// keySize|key|offset|tombstone|expiry|size|tagsSize|tags and ends with the CRC32C of everything
+// before it. The tags are encoded the same way as in the records.
+const (
+	hintFileFormatSuffix = ".hint"
+	hintFormatVersion    = 4
+	hintHeaderSize       = 14
+	hintEntrySize        = 29
+)
+
+var hintMagic = []byte("KSHH")
+
+// errStaleHint is returned when the hint file doesn't match the current content of its data file
+var errStaleHint = errors.New("stale hint file")
+
+// hintFilePath returns the path of the hint file for the data file in path
+func hintFilePath(path string) string {
+	return strings.TrimSuffix(path, dataFileFormatSuffix) + hintFileFormatSuffix
+}
+
+// moveHintFile moves the hint file of the data file in oldPath next to the data file in newPath
+// it's a no-op if the data file doesn't have a hint file
+func moveHintFile(fsys FS, oldPath, newPath string) error {
+	err := fsys.Rename(hintFilePath(oldPath), hintFilePath(newPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeHintFile writes the index of the log to its hint file. The file is written to a temporary
+// file first and then renamed, so a crash never leaves a partially written hint file behind.
+func writeHintFile(fsys FS, log *readLog, perm os.FileMode) error {
+	stat, err := fsys.Stat(log.path)
+	if err != nil {
+		return err
+	}
+
+	path := hintFilePath(log.path)
+	tmpPath := path + ".tmp"
+	file, err := fsys.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer fsys.Remove(tmpPath)
+
+	checksum := crc32.New(crcTable)
+	writer := bufio.NewWriter(io.MultiWriter(file, checksum))
+
+	header := make([]byte, hintHeaderSize)
+	copy(header, hintMagic)
+	header[4] = hintFormatVersion
+	binary.LittleEndian.PutUint64(header[5:], uint64(stat.Size()))
+	header[13] = byte(log.version)
+	if _, err := writer.Write(header); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writeHintFilter(writer, log.filter); err != nil {
+		file.Close()
+		return err
+	}
+
+	keySize := make([]byte, 4)
+	entry := make([]byte, hintEntrySize)
+	for key, indexEntry := range log.index {
+		binary.LittleEndian.PutUint32(keySize, uint32(len(key)))
+		binary.LittleEndian.PutUint64(entry, uint64(indexEntry.offset))
+		entry[8] = 0
+		if indexEntry.tombstone {
+			entry[8] = 1
+		}
+		binary.LittleEndian.PutUint64(entry[9:], uint64(indexEntry.expiry))
+		binary.LittleEndian.PutUint64(entry[17:], uint64(indexEntry.size))
+		tags := encodeTags(log.tags[key])
+		binary.LittleEndian.PutUint32(entry[25:], uint32(len(tags)))
+
+		for _, data := range [][]byte{keySize, []byte(key), entry, tags} {
+			if _, err := writer.Write(data); err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, checksum.Sum32()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return fsys.Rename(tmpPath, path)
+}
+
+// loadHintFile builds the read log of the data file in path from its hint file.
+// It returns an error if the hint file doesn't exist, is corrupted or doesn't match the data file
+// anymore, in which case the index should be rebuilt from the data file itself.
+func loadHintFile(fsys FS, path string) (*readLog, error) {
+	data, err := readFile(fsys, hintFilePath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < hintHeaderSize+4 || !bytes.Equal(data[:len(hintMagic)], hintMagic) {
+		return nil, fmt.Errorf("invalid hint file header")
+	}
+	if data[4] != hintFormatVersion {
+		return nil, fmt.Errorf("unsupported hint file format version %d", data[4])
+	}
+	content := data[:len(data)-4]
+	if crc32.Checksum(content, crcTable) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, ErrCorruptRecord
+	}
+
+	stat, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if int64(binary.LittleEndian.Uint64(content[5:])) != stat.Size() {
+		return nil, errStaleHint
+	}
+
+	log := &readLog{
+		path:    path,
+		index:   make(map[string]indexEntry),
+		version: int(content[13]),
+		size:    stat.Size(),
+	}
+
+	filter, entries, err := readHintFilter(content[hintHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	log.filter = filter
+	for len(entries) > 0 {
+		if len(entries) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		keySize := int(binary.LittleEndian.Uint32(entries))
+		if len(entries) < 4+keySize+hintEntrySize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		key := string(entries[4 : 4+keySize])
+		entry := entries[4+keySize:]
+		log.index[key] = indexEntry{
+			offset:    int64(binary.LittleEndian.Uint64(entry)),
+			tombstone: entry[8] == 1,
+			expiry:    int64(binary.LittleEndian.Uint64(entry[9:])),
+			size:      int64(binary.LittleEndian.Uint64(entry[17:])),
+		}
+		tagsSize := int(binary.LittleEndian.Uint32(entry[25:]))
+		entries = entries[4+keySize+hintEntrySize:]
+		if len(entries) < tagsSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		tags, err := decodeTags(entries[:tagsSize])
+		if err != nil {
+			return nil, err
+		}
+		log.tags = setLogTags(log.tags, key, tags)
+		entries = entries[tagsSize:]
+	}
+
+	return log, nil
+}
+
+// writeHintFilter writes the bloom filter section of a hint file, a nil filter is written as an empty section
+func writeHintFilter(writer io.Writer, filter *bloomFilter) error {
+	section := make([]byte, 8)
+	if filter == nil {
+		_, err := writer.Write(section)
+		return err
+	}
+	binary.LittleEndian.PutUint32(section, uint32(filter.bitsPerKey))
+	binary.LittleEndian.PutUint32(section[4:], uint32(len(filter.bits)))
+	for _, word := range filter.bits {
+		section = binary.LittleEndian.AppendUint64(section, word)
+	}
+	_, err := writer.Write(section)
+	return err
+}
+
+// readHintFilter reads the bloom filter section from the beginning of data and returns the filter,
+// which is nil for an empty section, and the rest of data
+func readHintFilter(data []byte) (*bloomFilter, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	bitsPerKey := int(binary.LittleEndian.Uint32(data))
+	words := int(binary.LittleEndian.Uint32(data[4:]))
+	data = data[8:]
+	if len(data) < words*8 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if bitsPerKey == 0 {
+		return nil, data[words*8:], nil
+	}
+
+	filter := newBloomFilter(0, bitsPerKey)
+	filter.bits = make([]uint64, words)
+	for i := range filter.bits {
+		filter.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return filter, data[words*8:], nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHintFileMatchesDataFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_hint_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(t, engine.Delete("key3"))
+	require.Greater(t, len(engine.readLogs), 1)
+
+	for _, log := range engine.readLogs {
+		hintLog, err := loadHintFile(osFS{}, log.path)
+		require.NoError(t, err, "Expected a hint file for the closed log %s", log.path)
+
+		scannedLog, err := extractReadLog(osFS{}, log.path, defaultTombstone, 0)
+		require.NoError(t, err)
+		assert.Equal(t, scannedLog, hintLog)
+	}
+
+	require.NoError(t, engine.Close())
+}
+
+func TestStaleHintFileFallsBackToDataFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_stale_hint_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	dataFilePath := engine.writeLog.file.Name()
+	require.NoError(t, engine.Close())
+
+	// append a record which is missing from the hint file
+	file, err := os.OpenFile(dataFilePath, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write(encodeRecord(record{key: "other", value: "badger"}))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = loadHintFile(osFS{}, dataFilePath)
+	assert.ErrorIs(t, err, errStaleHint)
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+
+	value, err := engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+
+	value, err = engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestCorruptHintFileFallsBackToDataFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_corrupt_hint_file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("name", "gopher"))
+	dataFilePath := engine.writeLog.file.Name()
+	require.NoError(t, engine.Close())
+
+	hint, err := os.ReadFile(hintFilePath(dataFilePath))
+	require.NoError(t, err)
+	hint[hintHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(hintFilePath(dataFilePath), hint, 0o644))
+
+	_, err = loadHintFile(osFS{}, dataFilePath)
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+
+	value, err := engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+
+	require.NoError(t, engine.Close())
+}
+
+func BenchmarkOpenWithHintFiles(b *testing.B) {
+	benchmarkOpen(b, true)
+}
+
+func BenchmarkOpenWithoutHintFiles(b *testing.B) {
+	benchmarkOpen(b, false)
+}
+
+func benchmarkOpen(b *testing.B, withHints bool) {
+	tempDir, err := os.MkdirTemp("", "benchmark_open")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1*MB))
+	require.NoError(b, err)
+	value := string(make([]byte, 256))
+	for i := 0; i < 100000; i++ {
+		require.NoError(b, engine.Put(fmt.Sprintf("key%d", i), value))
+	}
+	require.NoError(b, engine.Close())
+
+	if !withHints {
+		dataFiles, err := extractDatafiles(osFS{}, tempDir)
+		require.NoError(b, err)
+		for _, path := range dataFiles {
+			require.NoError(b, os.Remove(hintFilePath(path)))
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine, err := NewEngine(tempDir, WithMaxLogSize(1*MB))
+		require.NoError(b, err)
+		require.NoError(b, engine.Close())
+	}
+}
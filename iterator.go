@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"sort"
+)
+
+// Iterator visits the live keys of the storage engine in ascending order as of the time it's created.
+// It reads from a snapshot, so unlike ForEach it doesn't hold the lock of the engine while iterating
+// and the writes made meanwhile are not visible through it. The data files it reads from are pinned
+// until it's closed like the ones of a snapshot, so an iterator should be closed once it's not needed
+// anymore. An Iterator is not safe for concurrent use.
+type Iterator struct {
+	snapshot *Snapshot
+	// entries represents the latest records of the live keys in the order they are visited
+	entries []iteratorEntry
+	// position represents the index of the current entry, it's -1 before the first call to Next
+	position int
+	value    string
+	err      error
+}
+
+// iteratorEntry represents the latest record of a key and the log of the snapshot it's stored in
+type iteratorEntry struct {
+	key   string
+	entry indexEntry
+	log   int
+}
+
+// NewIterator returns an iterator positioned before the first key, Next must be called to move it to
+// the first key. An iterator which can't be created returns false from its first Next and the error
+// from Err.
+func (e *Engine) NewIterator() *Iterator {
+	iterator := &Iterator{position: -1}
+	iterator.snapshot, iterator.err = e.Snapshot()
+	if iterator.err != nil {
+		return iterator
+	}
+
+	// the logs of a snapshot are ordered from the newest to the oldest so the first visit is the latest record
+	visited := make(map[string]struct{})
+	for i, log := range iterator.snapshot.logs {
+		for key, entry := range log.index {
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			if entry.live(iterator.snapshot.now) {
+				iterator.entries = append(iterator.entries, iteratorEntry{key: key, entry: entry, log: i})
+			}
+		}
+	}
+	sort.Slice(iterator.entries, func(i, j int) bool {
+		return iterator.entries[i].key < iterator.entries[j].key
+	})
+	return iterator
+}
+
+// Next moves the iterator to the next key and reads its value, it returns false once there are no
+// more keys or reading the value fails, in which case Err returns the error
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.position >= len(it.entries) {
+		return false
+	}
+	it.position++
+	it.value = ""
+	if it.position == len(it.entries) {
+		return false
+	}
+
+	s := it.snapshot
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		it.err = ErrSnapshotClosed
+		return false
+	}
+
+	current := it.entries[it.position]
+	log := s.logs[current.log]
+	var rec record
+	if log.version == formatVersionLegacy {
+		rec, it.err = readAtDataFile(log.file, log.file.Name(), current.entry.offset, log.version)
+	} else {
+		rec, it.err = readWholeRecordAt(log.file, log.file.Name(), current.entry.offset, current.entry.size, log.version)
+	}
+	if it.err == nil {
+		it.value, it.err = s.engine.decodeValue(rec)
+	}
+	return it.err == nil
+}
+
+// Key returns the key the iterator is positioned at, it's empty before the first call to Next and
+// after the iteration is finished
+func (it *Iterator) Key() string {
+	if it.position < 0 || it.position >= len(it.entries) || it.err != nil {
+		return ""
+	}
+	return it.entries[it.position].key
+}
+
+// Value returns the value of the key the iterator is positioned at
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Err returns the error which stopped the iteration, it's nil if the iteration is finished or still running
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the data files pinned by the iterator, Next returns false after it. It's safe to call
+// Close more than once.
+func (it *Iterator) Close() error {
+	if it.snapshot == nil {
+		return nil
+	}
+	return it.snapshot.Close()
+}
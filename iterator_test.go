@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_iterator")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(KB), WithBackupRetention(0, 0))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	expected := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key%03d", i), fmt.Sprintf("value%d", i)
+		require.NoError(t, engine.Put(key, value))
+		expected[key] = value
+	}
+	require.NoError(t, engine.Delete("key050"))
+	delete(expected, "key050")
+
+	iterator := engine.NewIterator()
+	defer iterator.Close()
+
+	// the writes and the compaction proceed while the iterator is open
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			assert.NoError(t, engine.Put(fmt.Sprintf("key%03d", i), "changed"))
+		}
+		assert.NoError(t, engine.Compact())
+	}()
+
+	visited := make(map[string]string)
+	var keys []string
+	for iterator.Next() {
+		visited[iterator.Key()] = iterator.Value()
+		keys = append(keys, iterator.Key())
+	}
+	wg.Wait()
+	require.NoError(t, iterator.Err())
+	assert.Equal(t, expected, visited)
+	assert.IsIncreasing(t, keys)
+	assert.False(t, iterator.Next())
+	assert.Empty(t, iterator.Key())
+
+	// a closed iterator stops
+	iterator = engine.NewIterator()
+	require.True(t, iterator.Next())
+	require.NoError(t, iterator.Close())
+	require.NoError(t, iterator.Close())
+	assert.False(t, iterator.Next())
+	assert.ErrorIs(t, iterator.Err(), ErrSnapshotClosed)
+
+	require.NoError(t, engine.Close())
+	iterator = engine.NewIterator()
+	assert.False(t, iterator.Next())
+	assert.ErrorIs(t, iterator.Err(), ErrEngineClosed)
+	require.NoError(t, iterator.Close())
+}
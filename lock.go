@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	lockFileName = ".lock"
+	// maxLockPollInterval represents the longest wait between two attempts to acquire a lock with a timeout
+	maxLockPollInterval = 100 * time.Millisecond
+)
+
+var (
+	// ErrLocked is returned when the data path is already locked by another engine
+	ErrLocked = errors.New("data path is locked by another engine")
+	// ErrLockTimeout is returned when the data path is still locked by another engine once the lock timeout elapses
+	ErrLockTimeout = errors.New("timed out waiting for the lock of the data path")
+)
+
+// createFlock creates the lock file in lockPath and acquires an exclusive lock on it without blocking,
+// or a shared lock if shared is set. It fails fast with ErrLocked if another engine already holds
+// the lock in a conflicting mode, unless a timeout is given, in which case the lock is polled with a
+// growing interval until it's acquired or it fails with ErrLockTimeout once the timeout elapses.
+// Only the files of the operating system can be locked, the lock file of another FS is created
+// without a lock. A shared lock is skipped if there is no lock file and it can't be created, e.g. on
+// a read-only mount, since every engine which writes to the data path creates it; a nil lock file
+// is returned then.
+func createFlock(fsys FS, lockPath string, shared bool, perm os.FileMode, timeout time.Duration) (File, error) {
+	lockFile, err := openLockFile(fsys, lockPath, shared, perm)
+	if err != nil || lockFile == nil {
+		return nil, err
+	}
+	file, ok := osFile(lockFile)
+	if !ok {
+		return lockFile, nil
+	}
+
+	lock := lockFileExclusive
+	if shared {
+		lock = lockFileShared
+	}
+	deadline := time.Now().Add(timeout)
+	interval := time.Millisecond
+	for {
+		err := lock(file)
+		if err == nil {
+			return lockFile, nil
+		}
+		if !errors.Is(err, errWouldBlock) {
+			lockFile.Close()
+			return nil, err
+		}
+
+		if timeout == 0 {
+			lockFile.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLocked, lockPath)
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			lockFile.Close()
+			return nil, fmt.Errorf("%w after %s: %s", ErrLockTimeout, timeout, lockPath)
+		}
+		time.Sleep(min(interval, remaining))
+		interval = min(2*interval, maxLockPollInterval)
+	}
+}
+
+// openLockFile opens the lock file in lockPath, it's created if it doesn't exist. For a shared lock
+// the existing file is opened without asking for the permission to create it, and a missing file
+// which can't be created returns a nil file without an error.
+func openLockFile(fsys FS, lockPath string, shared bool, perm os.FileMode) (File, error) {
+	if !shared {
+		return fsys.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, perm)
+	}
+
+	lockFile, err := fsys.Open(lockPath)
+	if !os.IsNotExist(err) {
+		return lockFile, err
+	}
+	lockFile, err = fsys.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, perm)
+	if os.IsPermission(err) || errors.Is(err, syscall.EROFS) {
+		return nil, nil
+	}
+	return lockFile, err
+}
+
+// releaseFlock releases the lock acquired by createFlock and closes the lock file
+func releaseFlock(lockFile File) error {
+	if lockFile == nil {
+		return nil
+	}
+	if file, ok := osFile(lockFile); ok {
+		if err := unlockFile(file); err != nil {
+			lockFile.Close()
+			return err
+		}
+	}
+	return lockFile.Close()
+}
@@ -1,74 +1,283 @@
 package storage
 
 import (
-	"io"
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
 	"sort"
+	"sync"
+	"time"
 )
 
+// indexEntry represents the location of the latest record of a key in a log
+type indexEntry struct {
+	// offset represents the position of the record in the data file
+	offset int64
+	// tombstone is set when the record marks the key as deleted, so the deletion can be
+	// detected without reading the value from the disk
+	tombstone bool
+	// expiry represents the time the record expires as unix nanoseconds, zero means no expiry
+	expiry int64
+	// size represents the number of bytes the record takes in the data file
+	size int64
+}
+
+// expired reports whether the record has an expiry time which is passed at now
+func (entry indexEntry) expired(now time.Time) bool {
+	return entry.expiry != 0 && entry.expiry <= now.UnixNano()
+}
+
+// live reports whether the record holds a value which can be returned at now
+func (entry indexEntry) live(now time.Time) bool {
+	return !entry.tombstone && !entry.expired(now)
+}
+
 // log represents the data and index for the storage engine
 type readLog struct {
 	path  string
-	index map[string]int64
+	index map[string]indexEntry
+	// version represents the format version of the data file
+	version int
+	// size represents the size of the data file in bytes
+	size int64
+	// filter represents the bloom filter of the keys of the log, it's nil unless WithBloomFilter is used
+	filter *bloomFilter
+	// tags represents the tags of the records of the log which have tags, it's nil if none of them has
+	tags map[string][]string
 }
 
 type writeLog struct {
-	file  *os.File
-	index map[string]int64
-	size  int64
+	file  File
+	index map[string]indexEntry
+	// tags represents the tags of the records of the log which have tags, it's nil if none of them has
+	tags map[string][]string
+	// size represents the size of the log including the buffered bytes which are not written to the file yet
+	size int64
+	// buffer collects the writes in memory before they are written to the file, it's nil if the
+	// writes are not buffered
+	buffer *bufio.Writer
+	// bufferLock protects the buffer, which is flushed by the readers holding the read lock of the engine
+	bufferLock sync.Mutex
+}
+
+// newWriteLog returns an empty write log of the file, the writes are buffered up to bufferSize bytes
+// before they are written to the file, a zero bufferSize writes them to the file directly.
+// The index is allocated with the given capacity.
+func newWriteLog(file File, bufferSize, capacity int) *writeLog {
+	log := &writeLog{file: file, index: make(map[string]indexEntry, capacity)}
+	if bufferSize > 0 {
+		log.buffer = bufio.NewWriterSize(file, bufferSize)
+	}
+	return log
+}
+
+// write appends the data to the log through the buffer, the caller must hold the write lock of the engine
+func (l *writeLog) write(data []byte) (int, error) {
+	if l.buffer == nil {
+		return l.file.Write(data)
+	}
+	l.bufferLock.Lock()
+	defer l.bufferLock.Unlock()
+	return l.buffer.Write(data)
+}
+
+// flush writes the buffered data to the file, it must be called before the file is read or closed
+func (l *writeLog) flush() error {
+	if l.buffer == nil {
+		return nil
+	}
+	l.bufferLock.Lock()
+	defer l.bufferLock.Unlock()
+	return l.buffer.Flush()
 }
 
-func initReadLogs(paths []string) ([]*readLog, error) {
+// sync flushes the buffered data and syncs the file to the disk
+func (l *writeLog) sync() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// initReadLogs loads the read logs of the data files in paths, a partial record at the end of the
+// newest data file is truncated unless readOnly is set, in which case it's only ignored.
+// The data files are loaded in parallel on all the CPU cores. The indexes built from the data files
+// are allocated with the given capacity.
+func initReadLogs(fsys FS, paths []string, tombstone string, readOnly bool, capacity int) ([]*readLog, error) {
+	return loadReadLogs(fsys, paths, tombstone, readOnly, capacity, runtime.NumCPU())
+}
+
+// loadReadLogs loads the read logs of the data files in paths with the given number of workers,
+// the read logs are returned in the order of the data files regardless of which one is loaded first.
+// The first error stops the workers from loading more data files and is returned.
+func loadReadLogs(fsys FS, paths []string, tombstone string, readOnly bool, capacity, workers int) ([]*readLog, error) {
 	// sorting is needed as the file names order puts 13 before 2, and we rely on the order of files in making the index
 	sort.Slice(paths, func(i, j int) bool {
-		return extractFileNumber(paths[i]) < extractFileNumber(paths[j])
+		return lessFileName(paths[i], paths[j])
 	})
-	logs := make([]*readLog, 0, len(paths))
-	for _, path := range paths {
-		log, err := extractReadLog(path)
-		if err != nil {
-			return nil, err
+	paths = removeSupersededGenerations(fsys, paths, readOnly)
+
+	logs := make([]*readLog, len(paths))
+	jobs := make(chan int)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		firstErr error
+	)
+	for w := 0; w < min(workers, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				log, err := loadReadLog(fsys, paths[i], tombstone, readOnly, i == len(paths)-1, capacity)
+				if err != nil {
+					stopOnce.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+					return
+				}
+				logs[i] = log
+			}
+		}()
+	}
+
+feed:
+	for i := range paths {
+		select {
+		case jobs <- i:
+		case <-stop:
+			break feed
 		}
-		logs = append(logs, log)
 	}
+	close(jobs)
+	wg.Wait()
 
+	if firstErr != nil {
+		return nil, firstErr
+	}
 	return logs, nil
 }
 
-func extractReadLog(path string) (*readLog, error) {
+// loadReadLog loads the read log of the data file in path from its hint file, or from the data file
+// itself if the hint file can't be used. newest is set for the newest data file, which is the only
+// one which can end with a partial record. The index built from the data file is allocated with the given capacity.
+func loadReadLog(fsys FS, path string, tombstone string, readOnly bool, newest bool, capacity int) (*readLog, error) {
+	log, err := loadHintFile(fsys, path)
+	if err == nil {
+		return log, nil
+	}
+	if !os.IsNotExist(err) {
+		slog.Warn("failed to load hint file, rebuilding the index from the data file", "path", path, "err", err)
+	}
+
+	log, err = extractReadLog(fsys, path, tombstone, capacity)
+	// only the newest log can be cut off by a crash while writing to it, older logs were
+	// complete when they were rotated so a partial record in them is a corruption
+	var partialErr *partialRecordError
+	if errors.As(err, &partialErr) && newest {
+		if readOnly {
+			slog.Warn("ignoring partial record at the end of the data file", "path", path, "offset", partialErr.offset)
+		} else {
+			slog.Warn("truncating partial record at the end of the data file", "path", path, "offset", partialErr.offset)
+			if err := truncateFile(fsys, path, partialErr.offset); err != nil {
+				return nil, err
+			}
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// extractReadLog builds the index of the data file in path, the records which delete their key are
+// marked as deleted in the index. The data files written before the tombstone flag mark them with
+// the tombstone value instead. If the file ends with a partial record, the log of the
+// complete records is returned with the partialRecordError. The index is allocated with the given
+// capacity, so it doesn't grow over and over while a large data file is read.
+func extractReadLog(fsys FS, path string, tombstone string, capacity int) (*readLog, error) {
 	log := &readLog{
 		path:  path,
-		index: make(map[string]int64),
+		index: make(map[string]indexEntry, capacity),
 	}
 
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644) // todo: set right perm for the read only file
+	file, err := fsys.OpenFile(path, os.O_RDONLY, 0644) // todo: set right perm for the read only file
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	for {
-		key, err := readDataFile(file)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		endOffset, err := file.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return nil, err
+	log.version, err = readFileHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+
+	err = scanRecords(file, log.version, func(rec record, offset, size int64) error {
+		deleted := rec.tombstone()
+		if log.version < formatVersionTombstoneFlag {
+			deleted = rec.value == tombstone
 		}
-		log.index[key] = endOffset
+		log.index[rec.key] = indexEntry{offset: offset, tombstone: deleted, expiry: rec.expiry, size: size}
+		log.tags = setLogTags(log.tags, rec.key, rec.tags)
+		return nil
+	})
+	var partialErr *partialRecordError
+	if errors.As(err, &partialErr) {
+		log.size = partialErr.offset
+		return log, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	log.size = stat.Size()
+	return log, nil
+}
 
-		// Intentionally reading value to move the file cursor to the next key
-		_, err = readDataFile(file)
-		if err != nil {
-			if err == io.EOF {
-				break
+// sameDataFile reports whether the data files in path a and b are generations of the same data file
+func sameDataFile(a, b string) bool {
+	aNumber, aPart, _ := parseFileName(a)
+	bNumber, bPart, _ := parseFileName(b)
+	return aNumber == bNumber && aPart == bPart
+}
+
+// removeSupersededGenerations drops the data files which are already rewritten by the garbage
+// collector from the sorted paths. They are only left behind if the process stops before the
+// garbage collector removes them, and they are removed from the disk unless readOnly is set.
+func removeSupersededGenerations(fsys FS, paths []string, readOnly bool) []string {
+	kept := paths[:0]
+	for i, path := range paths {
+		if i+1 < len(paths) && sameDataFile(paths[i+1], path) {
+			slog.Warn("ignoring data file superseded by its rewrite", "path", path)
+			if !readOnly {
+				if err := removeLogFiles(fsys, path); err != nil {
+					slog.Warn("failed to remove superseded data file", "path", path, "err", err)
+				}
 			}
-			return nil, err
+			continue
 		}
+		kept = append(kept, path)
 	}
-	return log, nil
+	return kept
+}
+
+// removeLogFiles removes the data file in path and its hint file if it has one
+func removeLogFiles(fsys FS, path string) error {
+	if err := fsys.Remove(path); err != nil {
+		return err
+	}
+	if err := fsys.Remove(hintFilePath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"encoding/binary"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -47,7 +49,7 @@ func TestExtractReadLog(t *testing.T) {
 	tmpFile.Close()
 
 	// Run function
-	readLog, err := extractReadLog(tmpFile.Name())
+	readLog, err := extractReadLog(osFS{}, tmpFile.Name(), defaultTombstone, 0)
 	require.NoError(t, err)
 
 	// Validate results
@@ -64,6 +66,251 @@ func TestExtractReadLog(t *testing.T) {
 	for k, expected := range expectedOffsets {
 		actual, found := readLog.index[k]
 		require.True(t, found, "Key %s not found in index", k)
-		assert.Equal(t, expected, actual, "Expected offset %d, got %d", expected, actual)
+		assert.Equal(t, expected, actual.offset, "Expected offset %d, got %d", expected, actual.offset)
+	}
+}
+
+func TestPartialTrailingRecordIsTruncated(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "partial_trailing_record_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key1", "value1"))
+	require.NoError(t, engine.Put("key2", "value2"))
+	validSize := engine.writeLog.size
+	dataFilePath := engine.writeLog.file.Name()
+	crashEngine(t, engine)
+
+	// simulate a crash in the middle of writing the value of the last record
+	file, err := os.OpenFile(dataFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write(encodeRecord(record{key: "key3", value: "a long value"})[:recordHeaderSize(currentFormatVersion)+6])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	stat, err := os.Stat(dataFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, validSize, stat.Size(), "Expected the partial record to be truncated")
+
+	for _, key := range []string{"key1", "key2"} {
+		_, err := engine.Get(key)
+		assert.NoError(t, err)
+	}
+	_, err = engine.Get("key3")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestPartialRecordInOlderLogFails(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "partial_record_in_older_log_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put("key", "some value"))
+	}
+	require.NoError(t, engine.Close())
+	require.Greater(t, len(engine.readLogs), 1)
+
+	// cut the first log in the middle of its last record
+	firstLog := engine.readLogs[0]
+	require.NoError(t, os.Remove(hintFilePath(firstLog.path)))
+	require.NoError(t, os.Truncate(firstLog.path, firstLog.size-3))
+
+	_, err = NewEngine(tempDir, WithMaxLogSize(64))
+	var partialErr *partialRecordError
+	assert.ErrorAs(t, err, &partialErr)
+}
+
+func TestCorruptSizeInNewestLogFails(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "corrupt_size_in_newest_log_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	dataFilePath := engine.writeLog.file.Name()
+	firstOffset := engine.writeLog.index["key1"].offset
+	crashEngine(t, engine)
+
+	// the value size of the first record makes it end in the middle of the next record
+	data, err := os.ReadFile(dataFilePath)
+	require.NoError(t, err)
+	binary.LittleEndian.PutUint32(data[firstOffset+8:], 10)
+	require.NoError(t, os.WriteFile(dataFilePath, data, 0o644))
+
+	_, err = NewEngine(tempDir)
+	assert.ErrorIs(t, err, ErrCorruptRecord, "Expected a corruption in the middle of the log not to be truncated")
+	unchanged, err := os.ReadFile(dataFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, data, unchanged)
+}
+
+func TestPartialRecordWithRecordInItsValueIsTruncated(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "partial_record_with_record_in_value_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	validSize := engine.writeLog.size
+	// the value holds a complete record, which must not be taken for a record following a corruption
+	embedded := encodeRecord(record{key: "embedded", value: "value"})
+	value := strings.Repeat("x", 16) + string(embedded) + strings.Repeat("y", 64)
+	require.NoError(t, engine.Put("large", value))
+	dataFilePath := engine.writeLog.file.Name()
+	crashEngine(t, engine)
+
+	// the process stops after the embedded record is written and before the rest of the value is
+	require.NoError(t, os.Truncate(dataFilePath, engine.writeLog.size-32))
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err, "Expected the partial record to be truncated")
+	defer engine.Close()
+	stat, err := os.Stat(dataFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, validSize, stat.Size())
+	got, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+	_, err = engine.Get("large")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestCorruptLastRecordIsNotTruncated(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "corrupt_last_record_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithSyncWrites(true))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.Put("last", "value"))
+	dataFilePath := engine.writeLog.file.Name()
+	crashEngine(t, engine)
+
+	// a bit of the value of the fully written last record flips on the disk
+	data, err := os.ReadFile(dataFilePath)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	require.NoError(t, os.WriteFile(dataFilePath, data, 0o644))
+
+	_, err = NewEngine(tempDir)
+	assert.ErrorIs(t, err, ErrCorruptRecord, "Expected the corrupted record to be reported instead of truncated")
+	unchanged, err := os.ReadFile(dataFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, data, unchanged)
+}
+
+// writeManyLogs fills the data path with many small logs which overwrite each other's keys
+func writeManyLogs(tb testing.TB, dataPath string, records int) []string {
+	engine, err := NewEngine(dataPath, WithMaxLogSize(4*KB))
+	require.NoError(tb, err)
+	for i := 0; i < records; i++ {
+		require.NoError(tb, engine.Put(fmt.Sprintf("key%d", i%500), fmt.Sprintf("value%d", i)))
+	}
+	require.NoError(tb, engine.Close())
+
+	dataFiles, err := extractDatafiles(osFS{}, dataPath)
+	require.NoError(tb, err)
+	return dataFiles
+}
+
+func TestParallelLoadMatchesSequentialLoad(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_parallel_load")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	dataFiles := writeManyLogs(t, tempDir, 5000)
+	require.Greater(t, len(dataFiles), 20)
+	// half of the logs are loaded from their data files
+	for i, path := range dataFiles {
+		if i%2 == 0 {
+			require.NoError(t, os.Remove(hintFilePath(path)))
+		}
+	}
+
+	sequential, err := loadReadLogs(osFS{}, append([]string{}, dataFiles...), defaultTombstone, true, 0, 1)
+	require.NoError(t, err)
+	parallel, err := loadReadLogs(osFS{}, append([]string{}, dataFiles...), defaultTombstone, true, 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, sequential, parallel)
+	for i := 1; i < len(parallel); i++ {
+		assert.True(t, lessFileName(parallel[i-1].path, parallel[i].path))
+	}
+
+	// a corrupted log fails the whole load
+	corrupted := dataFiles[1]
+	require.NoError(t, os.Remove(hintFilePath(corrupted)))
+	require.NoError(t, os.WriteFile(corrupted, []byte("KSHK\x04broken record which is long enough"), 0o644))
+	_, err = loadReadLogs(osFS{}, append([]string{}, dataFiles...), defaultTombstone, true, 0, 8)
+	assert.Error(t, err)
+}
+
+func BenchmarkLoadReadLogs(b *testing.B) {
+	tempDir, err := os.MkdirTemp("", "benchmark_load_read_logs")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	dataFiles := writeManyLogs(b, tempDir, 100000)
+	for _, path := range dataFiles {
+		require.NoError(b, os.Remove(hintFilePath(path)))
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := loadReadLogs(osFS{}, append([]string{}, dataFiles...), defaultTombstone, true, 0, workers)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func BenchmarkOpenWithExpectedKeys(b *testing.B) {
+	benchmarkOpenLargeLogs(b, WithExpectedKeys(500000))
+}
+
+func BenchmarkOpenWithoutExpectedKeys(b *testing.B) {
+	benchmarkOpenLargeLogs(b)
+}
+
+// benchmarkOpenLargeLogs opens a data path of a few large logs with many distinct keys, whose indexes
+// are built from the data files
+func benchmarkOpenLargeLogs(b *testing.B, options ...OptionSetter) {
+	tempDir, err := os.MkdirTemp("", "benchmark_open_large_logs")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(8*MB), WithWriteBufferSize(64*KB))
+	require.NoError(b, err)
+	for i := 0; i < 500000; i++ {
+		require.NoError(b, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+	require.NoError(b, engine.Close())
+
+	dataFiles, err := extractDatafiles(osFS{}, tempDir)
+	require.NoError(b, err)
+	for _, path := range dataFiles {
+		require.NoError(b, os.Remove(hintFilePath(path)))
+	}
+
+	options = append(options, WithMaxLogSize(8*MB), WithReadOnly(true))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine, err := NewEngine(tempDir, options...)
+		require.NoError(b, err)
+		require.NoError(b, engine.Close())
 	}
 }
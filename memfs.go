@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS is an FS which keeps the files in memory, the files are lost when it's dropped
+type memFS struct {
+	lock  sync.Mutex
+	files map[string]*memFileData
+	dirs  map[string]time.Time
+}
+
+// memFileData represents the content of a file of a memFS, it's shared by all the open handles of
+// the file and outlives the removal of the file like the files of the operating system
+type memFileData struct {
+	lock    sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS returns an empty in-memory FS, e.g. for fast hermetic tests with WithFileSystem.
+// The engines using the same in-memory FS don't lock the data path against each other.
+func NewMemFS() FS {
+	return &memFS{
+		files: make(map[string]*memFileData),
+		dirs:  map[string]time.Time{string(filepath.Separator): time.Now(), ".": time.Now()},
+	}
+}
+
+func (m *memFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *memFS) OpenFile(name string, flag int, _ os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.dirs[name]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	data, ok := m.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		if _, ok := m.dirs[filepath.Dir(name)]; !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		data = &memFileData{modTime: time.Now()}
+		m.files[name] = data
+	}
+	if flag&os.O_TRUNC != 0 {
+		data.lock.Lock()
+		data.data = nil
+		data.lock.Unlock()
+	}
+
+	return &memFile{name: name, data: data, flag: flag}, nil
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if modTime, ok := m.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}, nil
+	}
+	if data, ok := m.files[name]; ok {
+		return data.stat(name), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *memFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		if len(m.children(name)) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		delete(m.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	data, ok := m.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if _, ok := m.dirs[filepath.Dir(newpath)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = data
+	return nil
+}
+
+func (m *memFS) ReadDir(name string) ([]os.DirEntry, error) {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.dirs[name]; !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	children := m.children(name)
+	entries := make([]os.DirEntry, 0, len(children))
+	for _, child := range children {
+		var info os.FileInfo
+		if modTime, ok := m.dirs[child]; ok {
+			info = &memFileInfo{name: filepath.Base(child), modTime: modTime, dir: true}
+		} else {
+			info = m.files[child].stat(child)
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *memFS) MkdirAll(path string, _ os.FileMode) error {
+	path = filepath.Clean(path)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		if _, ok := m.dirs[dir]; ok {
+			break
+		}
+		m.dirs[dir] = time.Now()
+	}
+	return nil
+}
+
+// children returns the paths of the files and the directories directly in the directory
+func (m *memFS) children(dir string) []string {
+	var children []string
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	add := func(path string) {
+		if path != dir && strings.HasPrefix(path, prefix) && !strings.Contains(path[len(prefix):], string(filepath.Separator)) {
+			children = append(children, path)
+		}
+	}
+	for path := range m.files {
+		add(path)
+	}
+	for path := range m.dirs {
+		add(path)
+	}
+	return children
+}
+
+func (d *memFileData) stat(name string) os.FileInfo {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return &memFileInfo{name: filepath.Base(name), size: int64(len(d.data)), modTime: d.modTime}
+}
+
+// memFile represents an open handle of a file of a memFS
+type memFile struct {
+	name   string
+	data   *memFileData
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.data.lock.RLock()
+	defer f.data.lock.RUnlock()
+
+	if off >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errors.New("file is opened read-only")}
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data.data))
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.data.data)) {
+		f.data.data = append(f.data.data, make([]byte, end-int64(len(f.data.data)))...)
+	}
+	copy(f.data.data[f.offset:], p)
+	f.offset = end
+	f.data.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.data.lock.RLock()
+		offset += int64(len(f.data.data))
+		f.data.lock.RUnlock()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("negative offset")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.data.stat(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	if size < int64(len(f.data.data)) {
+		f.data.data = f.data.data[:size]
+	} else {
+		f.data.data = append(f.data.data, make([]byte, size-int64(len(f.data.data)))...)
+	}
+	f.data.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// memFileInfo represents the os.FileInfo of a file or a directory of a memFS
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.dir }
+func (i *memFileInfo) Sys() any           { return nil }
+
+func (i *memFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
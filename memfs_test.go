@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEngineInMemoryFileSystem(t *testing.T) {
+	fsys := NewMemFS()
+	path := filepath.Join(os.TempDir(), "kashk_memfs_test", "data")
+
+	engine, err := NewEngine(path, WithFileSystem(fsys), WithMaxLogSize(256))
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	for i := 0; i < 50; i += 5 {
+		require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, engine.Put("key1", "changed"))
+	require.NoError(t, engine.Compact())
+
+	check := func(engine *Engine) {
+		for i := 0; i < 50; i++ {
+			value, err := engine.Get(fmt.Sprintf("key%d", i))
+			switch {
+			case i%5 == 0:
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			case i == 1:
+				require.NoError(t, err)
+				assert.Equal(t, "changed", value)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value%d", i), value)
+			}
+		}
+	}
+	check(engine)
+	require.NoError(t, engine.Close())
+
+	// the data files are only kept in the in-memory file system
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	dataFiles, err := extractDatafiles(fsys, ensureTrailingSlash(path))
+	require.NoError(t, err)
+	assert.NotEmpty(t, dataFiles)
+
+	reopened, err := NewEngine(path, WithFileSystem(fsys), WithMaxLogSize(256))
+	require.NoError(t, err)
+	check(reopened)
+	require.NoError(t, reopened.Close())
+
+	_, err = NewEngine(path, WithFileSystem(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Merge copies the latest state of every key of src into the engine, so the engine ends up with the
+// union of both where src wins on conflicts. Keys deleted or expired in src are deleted from the
+// engine, and the expiry time and the tags of the copied keys are kept. The read lock of src is held
+// for the whole merge to get a consistent view of it, so writes to src wait for the merge to finish
+// and two engines must not be merged into each other at the same time.
+func (e *Engine) Merge(src *Engine) error {
+	if src == e {
+		return fmt.Errorf("can't merge an engine into itself")
+	}
+
+	src.lock.RLock()
+	defer src.lock.RUnlock()
+	if src.closed {
+		return ErrEngineClosed
+	}
+
+	now := src.clock.Now()
+	return src.walkInFileOrder(context.Background(), func(key string, entry indexEntry, readValue func() (string, error)) error {
+		if !entry.live(now) {
+			exists, err := e.Exists(key)
+			if err != nil || !exists {
+				return err
+			}
+			return e.deleteKey(key)
+		}
+
+		value, err := readValue()
+		if err != nil {
+			return err
+		}
+		return e.putRecord(record{key: key, value: value, expiry: entry.expiry, tags: src.tags.tags[key]})
+	})
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "merge_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	dst, err := NewEngine(filepath.Join(tempDir, "dst"), WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer dst.Close()
+	src, err := NewEngine(filepath.Join(tempDir, "src"), WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer src.Close()
+
+	require.NoError(t, dst.Put("only_dst", "dst"))
+	require.NoError(t, dst.Put("both", "dst"))
+	require.NoError(t, dst.Put("deleted_in_src", "dst"))
+
+	require.NoError(t, src.Put("only_src", "src"))
+	require.NoError(t, src.Put("both", "old_src"))
+	require.NoError(t, src.Put("both", "src"))
+	require.NoError(t, src.Put("deleted_in_src", "src"))
+	require.NoError(t, src.Delete("deleted_in_src"))
+	require.NoError(t, src.Delete("deleted_only_in_src"))
+	require.NoError(t, src.PutWithTTL("ttl", "src", time.Hour))
+
+	require.NoError(t, dst.Merge(src))
+
+	expected := map[string]string{"only_dst": "dst", "only_src": "src", "both": "src", "ttl": "src"}
+	for key, expectedValue := range expected {
+		value, err := dst.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, expectedValue, value, "Unexpected value of %s", key)
+	}
+	_, err = dst.Get("deleted_in_src")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	entry, _ := dst.latestEntry("ttl")
+	assert.NotZero(t, entry.expiry, "Expected the expiry time to be kept")
+
+	// a tombstone of a key missing in dst isn't copied
+	_, exists := dst.latestEntry("deleted_only_in_src")
+	assert.False(t, exists)
+
+	// src is not changed by the merge
+	count, err := src.KeyCount()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.Error(t, dst.Merge(dst))
+}
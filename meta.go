@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	metaFileName = "meta.json"
+)
+
+// ErrConfigMismatch is returned when the engine is opened with options which don't match the
+// configuration the data path is created with
+var ErrConfigMismatch = errors.New("options don't match the configuration of the data path")
+
+// meta represents the state and the configuration of the engine which is kept next to the data files
+type meta struct {
+	// Sequence represents the number of the last data file created in the data path, the numbers
+	// only grow, so a new data file never reuses the name of a compacted or removed one
+	Sequence int `json:"sequence"`
+	// Tombstone represents the tombstone value the records in the data path are deleted with
+	Tombstone string `json:"tombstone,omitempty"`
+	// FormatVersion represents the format version the data files are written with
+	FormatVersion int `json:"formatVersion,omitempty"`
+	// MaxKeySize represents the max size of the keys which can be stored in the data path
+	MaxKeySize int64 `json:"maxKeySize,omitempty"`
+	// LockFile represents the lock file kept outside of the data path by WithLockFilePath, empty when
+	// the lock file is kept in the data path
+	LockFile string `json:"lockFile,omitempty"`
+}
+
+// readMeta reads the meta file in path, a missing meta file returns an empty meta
+func readMeta(fsys FS, path string) (meta, error) {
+	var m meta
+	data, err := readFile(fsys, path+metaFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// writeMeta writes the meta file in path. The file is written to a temporary file first and then
+// renamed, so a crash never leaves a partially written meta file behind.
+func writeMeta(fsys FS, path string, m meta, perm os.FileMode) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + metaFileName + ".tmp"
+	file, err := fsys.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer fsys.Remove(tmpPath)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return fsys.Rename(tmpPath, path+metaFileName)
+}
+
+// loadMeta reads the meta file in the data path and checks the options of the engine, including the
+// lock file, against the configuration recorded in it, unless the configuration is overridden. A data path without a
+// recorded configuration takes the configuration of the engine. The sequence is taken from the
+// data files if one of them has a larger number, e.g. when the meta file is lost.
+func (e *Engine) loadMeta(dataFiles []string) error {
+	m, err := readMeta(e.fs, e.dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to read meta file: %w", err)
+	}
+
+	if m.FormatVersion > currentFormatVersion {
+		return fmt.Errorf("%w: data path is written with format version %d, the newest supported version is %d",
+			ErrConfigMismatch, m.FormatVersion, currentFormatVersion)
+	}
+	if m.Tombstone != "" && !e.overrideConfig {
+		if m.Tombstone != e.tombStone {
+			return fmt.Errorf("%w: tombstone value is different from the one the data path is created with", ErrConfigMismatch)
+		}
+		if e.maxKeyBytes < m.MaxKeySize {
+			return fmt.Errorf("%w: max key size %d is smaller than %d the data path is created with",
+				ErrConfigMismatch, e.maxKeyBytes, m.MaxKeySize)
+		}
+		// the engines locking different files would write the data path at the same time
+		if m.LockFile != e.lockFilePath {
+			return fmt.Errorf("%w: lock file %q is different from %q the data path is locked with",
+				ErrConfigMismatch, e.lockFilePath, m.LockFile)
+		}
+	}
+
+	m.Tombstone = e.tombStone
+	m.FormatVersion = currentFormatVersion
+	m.MaxKeySize = e.maxKeyBytes
+	m.LockFile = e.lockFilePath
+	for _, dataFile := range dataFiles {
+		m.Sequence = max(m.Sequence, extractFileNumber(dataFile))
+	}
+	e.meta = m
+	return nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestConfigMismatch(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_config_mismatch")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithTombStone("deleted"), WithMaxKeySize(64))
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.Close())
+
+	_, err = NewEngine(tempDir, WithTombStone("removed"), WithMaxKeySize(64))
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+	_, err = NewEngine(tempDir, WithTombStone("deleted"), WithMaxKeySize(32))
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+	_, err = NewEngine(tempDir, WithTombStone("removed"), WithReadOnly(true))
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+
+	// a larger max key size still fits the stored keys
+	engine, err = NewEngine(tempDir, WithTombStone("deleted"), WithMaxKeySize(128))
+	require.NoError(t, err)
+	require.NoError(t, engine.Close())
+
+	// the override records the new configuration, which is checked from then on
+	engine, err = NewEngine(tempDir, WithTombStone("removed"), WithConfigOverride())
+	require.NoError(t, err)
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	require.NoError(t, engine.Close())
+
+	_, err = NewEngine(tempDir, WithTombStone("deleted"))
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+	engine, err = NewEngine(tempDir, WithTombStone("removed"))
+	require.NoError(t, err)
+	require.NoError(t, engine.Close())
+}
+
+func TestNewerFormatVersion(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_newer_format_version")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	require.NoError(t, writeMeta(osFS{}, ensureTrailingSlash(tempDir), meta{Sequence: 1, FormatVersion: currentFormatVersion + 1}, defaultFileMode))
+
+	_, err = NewEngine(tempDir, WithConfigOverride())
+	assert.ErrorIs(t, err, ErrConfigMismatch)
+}
@@ -0,0 +1,67 @@
+package storage
+
+import "fmt"
+
+// RecordMeta represents where the latest record of a key is stored
+type RecordMeta struct {
+	// Path represents the path of the data file the record is stored in
+	Path string
+	// Offset represents the position of the record in the data file
+	Offset int64
+	// Size represents the number of bytes the record takes in the data file
+	Size int64
+	// ValueSize represents the size of the value in bytes
+	ValueSize int
+	// InWriteLog is set when the record is stored in the log which is currently written to
+	InWriteLog bool
+}
+
+// GetWithMetadata returns the value of the key like Get, along with where its record is stored.
+// It's meant for debugging and tooling, e.g. to check the logs a compaction moved the key to.
+func (e *Engine) GetWithMetadata(key string) (string, RecordMeta, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return "", RecordMeta{}, err
+	}
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return "", RecordMeta{}, ErrEngineClosed
+	}
+
+	value, meta, err := e.readLatestRecord(key, now)
+	if err != nil {
+		return "", RecordMeta{}, err
+	}
+	meta.ValueSize = len(value)
+	return value, meta, nil
+}
+
+// ReadRecordAt reads the key and the value of the record at the offset of the data file in path, e.g.
+// the Path and the Offset returned by GetWithMetadata, with a single positioned read for records of up
+// to 4 KB. The data file must belong to the engine and be written in a format whose records start with
+// their key. The record is read as it is, it may be overwritten, deleted or expired.
+func (e *Engine) ReadRecordAt(path string, offset int64) (key, value string, err error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return "", "", ErrEngineClosed
+	}
+
+	if e.writeLog.file != nil && path == e.writeLog.file.Name() {
+		rec, err := e.readWholeRecord(path, offset, recordReadAhead, currentFormatVersion, true)
+		return rec.key, rec.value, err
+	}
+	for _, log := range e.readLogs {
+		if log.path != path {
+			continue
+		}
+		if log.version == formatVersionLegacy {
+			return "", "", fmt.Errorf("records of %s can't be read by their offset in the legacy format", path)
+		}
+		rec, err := e.readWholeRecord(path, offset, recordReadAhead, log.version, false)
+		return rec.key, rec.value, err
+	}
+	return "", "", fmt.Errorf("%s is not a data file of the engine", path)
+}
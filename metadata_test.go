@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGetWithMetadata(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_with_metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("first", "value"))
+	require.NoError(t, engine.Put("second", "another value"))
+
+	value, meta, err := engine.GetWithMetadata("second")
+	require.NoError(t, err)
+	assert.Equal(t, "another value", value)
+	entry := engine.writeLog.index["second"]
+	assert.Equal(t, RecordMeta{
+		Path:       engine.writeLog.file.Name(),
+		Offset:     entry.offset,
+		Size:       entry.size,
+		ValueSize:  len("another value"),
+		InWriteLog: true,
+	}, meta)
+
+	// after a compaction the key is reported in the compacted log
+	require.NoError(t, engine.rotateWriteLog())
+	require.NoError(t, engine.Compact())
+	value, meta, err = engine.GetWithMetadata("first")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	require.Len(t, engine.readLogs, 1)
+	assert.Equal(t, engine.readLogs[0].path, meta.Path)
+	assert.Equal(t, engine.readLogs[0].index["first"].offset, meta.Offset)
+	assert.False(t, meta.InWriteLog)
+
+	require.NoError(t, engine.Delete("first"))
+	_, _, err = engine.GetWithMetadata("first")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+// countingReaderAt counts the positioned reads made from a file
+type countingReaderAt struct {
+	io.ReaderAt
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestReadRecordAt(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_read_record_at")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	values := map[string]string{"first": "value", "empty": "", "large": strings.Repeat("x", 3*recordReadAhead)}
+	require.NoError(t, engine.Put("first", values["first"]))
+	require.NoError(t, engine.PutWithTags("large", values["large"], "tag"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("empty", values["empty"]))
+
+	// the record is the same as the one read field by field and the one returned by the scans
+	for key, value := range values {
+		_, meta, err := engine.GetWithMetadata(key)
+		require.NoError(t, err)
+		readKey, readValue, err := engine.ReadRecordAt(meta.Path, meta.Offset)
+		require.NoError(t, err)
+		assert.Equal(t, key, readKey)
+		assert.Equal(t, value, readValue)
+
+		file, err := os.Open(meta.Path)
+		require.NoError(t, err)
+		rec, err := readAtDataFile(file, meta.Path, meta.Offset, currentFormatVersion)
+		require.NoError(t, err)
+		reader := &countingReaderAt{ReaderAt: file}
+		whole, err := readWholeRecordAt(reader, meta.Path, meta.Offset, meta.Size, currentFormatVersion)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		assert.Equal(t, rec, whole)
+		assert.Equal(t, 1, reader.reads)
+	}
+	scanned := make(map[string]string)
+	require.NoError(t, engine.ForEach(func(key, value string) error {
+		scanned[key] = value
+		return nil
+	}))
+	assert.Equal(t, values, scanned)
+
+	_, _, err = engine.ReadRecordAt(tempDir+"/missing.dat", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import "fmt"
+
+// Migrate rewrites the read logs written in a format older than targetVersion in that format, so the
+// data path no longer depends on reading the old formats, e.g. the legacy files without checksums.
+// The logs are rewritten one by one like the garbage collector does, keeping only their records
+// which are still needed, and the records deleted with the tombstone value are marked with the
+// tombstone flag. The records are only written in the current format, so it's the only target which
+// is supported. Logs already in the target format are left as they are, and writes continue while
+// the logs are rewritten.
+func (e *Engine) Migrate(targetVersion int) error {
+	if targetVersion != currentFormatVersion {
+		return fmt.Errorf("invalid target format version %d, only the current format version %d can be written",
+			targetVersion, currentFormatVersion)
+	}
+	if e.readOnly {
+		return ErrReadOnly
+	}
+
+	// the compaction lock makes sure the read logs are not replaced by a compaction meanwhile
+	e.compactionManager.lock.Lock()
+	defer e.compactionManager.lock.Unlock()
+
+	e.lock.RLock()
+	if e.closed {
+		e.lock.RUnlock()
+		return ErrEngineClosed
+	}
+	snapshotReadLogs := make([]*readLog, len(e.readLogs))
+	copy(snapshotReadLogs, e.readLogs)
+	shadowed := make(map[string]struct{}, len(e.writeLog.index))
+	for key := range e.writeLog.index {
+		shadowed[key] = struct{}{}
+	}
+	e.lock.RUnlock()
+
+	now := e.clock.Now()
+	for i := len(snapshotReadLogs) - 1; i >= 0; i-- {
+		log := snapshotReadLogs[i]
+		if log.version < targetVersion {
+			if err := e.rewriteLog(log, snapshotReadLogs[:i], shadowed, now); err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", log.path, err)
+			}
+		}
+
+		for key := range log.index {
+			shadowed[key] = struct{}{}
+		}
+	}
+
+	return nil
+}
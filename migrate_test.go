@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_migrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	legacyFile, err := os.Create(filepath.Join(tempDir, "1"+dataFileFormatSuffix))
+	require.NoError(t, err)
+	writeLegacyRecord(t, legacyFile, "name", "gopher")
+	writeLegacyRecord(t, legacyFile, "deleted", "value")
+	writeLegacyRecord(t, legacyFile, "other", "badger")
+	require.NoError(t, legacyFile.Close())
+	data := append(append([]byte{}, fileMagic...), formatVersionFlags)
+	data = append(data, encodeFlagsRecord(record{key: "deleted", value: defaultTombstone})...)
+	data = append(data, encodeFlagsRecord(record{key: "flags", value: "value"})...)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "2"+dataFileFormatSuffix), data, 0o644))
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("other", "otter"))
+	require.NoError(t, engine.RotateLog())
+
+	check := func(engine *Engine) {
+		for key, expected := range map[string]string{"name": "gopher", "other": "otter", "flags": "value"} {
+			value, err := engine.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+		_, err := engine.Get("deleted")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	check(engine)
+
+	assert.Error(t, engine.Migrate(formatVersionFlags))
+	require.NoError(t, engine.Migrate(currentFormatVersion))
+	for _, log := range engine.readLogs {
+		assert.Equal(t, currentFormatVersion, log.version, log.path)
+	}
+	check(engine)
+	require.NoError(t, engine.Close())
+
+	// the old data files are replaced on the disk
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.Len(t, engine.readLogs, 3)
+	for _, log := range engine.readLogs {
+		assert.Equal(t, currentFormatVersion, log.version, log.path)
+	}
+	check(engine)
+}
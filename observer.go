@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Observer receives the latency and the outcome of the operations of the storage engine, e.g. to
+// record them as metrics. The callbacks are called synchronously by the operations, so they should
+// be fast, and they must be safe to call from multiple goroutines. Every write is observed as a put or a
+// delete of its key, e.g. a committed batch reports each of its records with the latency of the commit,
+// and the conditional writes like CompareAndSwap are only observed when they write. Failed operations
+// are not observed.
+type Observer interface {
+	// OnGet is called after a Get, hit reports whether the key is found
+	OnGet(dur time.Duration, hit bool)
+	// OnPut is called after a put with the size of the key and the value in bytes
+	OnPut(dur time.Duration, bytes int)
+	// OnDelete is called after a Delete
+	OnDelete(dur time.Duration)
+	// OnCompaction is called after a compaction with the number of bytes it reclaimed from the disk
+	OnCompaction(dur time.Duration, reclaimed int64)
+}
+
+// noopObserver is the observer of the engines which are created without WithObserver
+type noopObserver struct{}
+
+func (noopObserver) OnGet(time.Duration, bool)         {}
+func (noopObserver) OnPut(time.Duration, int)          {}
+func (noopObserver) OnDelete(time.Duration)            {}
+func (noopObserver) OnCompaction(time.Duration, int64) {}
+
+// WithObserver reports the latency and the outcome of the operations of the engine to the observer
+func WithObserver(observer Observer) OptionSetter {
+	return func(e *Engine) error {
+		if observer == nil {
+			return fmt.Errorf("invalid observer")
+		}
+		e.observer = observer
+
+		return nil
+	}
+}
+
+// observeGet reports a Get which started at start to the observer, unless it failed
+func (e *Engine) observeGet(start time.Time, err error) {
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		e.observer.OnGet(time.Since(start), err == nil)
+	}
+}
+
+// observeWrite reports the write of the record which started at start to the observer
+func (e *Engine) observeWrite(start time.Time, rec record) {
+	if rec.tombstone() {
+		e.observer.OnDelete(time.Since(start))
+		return
+	}
+	e.observer.OnPut(time.Since(start), len(rec.key)+len(rec.value))
+}
+
+// observeWrites reports the records written together by a write which started at start to the observer
+func (e *Engine) observeWrites(start time.Time, records []record) {
+	for _, rec := range records {
+		e.observeWrite(start, rec)
+	}
+}
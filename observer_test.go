@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver records the operations it's called for
+type recordingObserver struct {
+	lock        sync.Mutex
+	gets        []bool
+	putBytes    []int
+	deletes     int
+	compactions int
+}
+
+func (o *recordingObserver) OnGet(_ time.Duration, hit bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.gets = append(o.gets, hit)
+}
+
+func (o *recordingObserver) OnPut(_ time.Duration, bytes int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.putBytes = append(o.putBytes, bytes)
+}
+
+func (o *recordingObserver) OnDelete(time.Duration) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.deletes++
+}
+
+func (o *recordingObserver) OnCompaction(_ time.Duration, reclaimed int64) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.compactions++
+}
+
+func TestObserver(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "observer_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	observer := &recordingObserver{}
+	engine, err := NewEngine(tempDir, WithObserver(observer), WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key", "value"))
+	_, err = engine.Get("key")
+	require.NoError(t, err)
+	_, err = engine.Get("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	require.NoError(t, engine.Delete("key"))
+	require.NoError(t, engine.Put("other", "value"))
+	require.NoError(t, engine.Compact())
+
+	assert.Equal(t, []bool{true, false}, observer.gets)
+	// the writes of the compaction are not reported as puts
+	assert.Equal(t, []int{8, 10}, observer.putBytes)
+	assert.Equal(t, 1, observer.deletes)
+	assert.Equal(t, 1, observer.compactions)
+
+	_, err = NewEngine(tempDir, WithObserver(nil))
+	assert.Error(t, err)
+}
+
+func TestObserverReportsEveryWrite(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "observer_every_write_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	observer := &recordingObserver{}
+	engine, err := NewEngine(tempDir, WithObserver(observer))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	batch := engine.NewBatch()
+	batch.Put("a", "1")
+	batch.Delete("b")
+	require.NoError(t, batch.Commit())
+	swapped, err := engine.CompareAndSwap("a", "1", "2")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = engine.CompareAndSwap("a", "1", "3")
+	require.NoError(t, err)
+	assert.False(t, swapped, "Expected a failed swap not to be observed as a put")
+	written, err := engine.PutIfAbsent("c", "3")
+	require.NoError(t, err)
+	assert.True(t, written)
+	written, err = engine.PutIfAbsent("c", "4")
+	require.NoError(t, err)
+	assert.False(t, written)
+	_, err = engine.Incr("n", 5)
+	require.NoError(t, err)
+	_, err = engine.Decr("n", 1)
+	require.NoError(t, err)
+	_, err = engine.Append("s", "ab")
+	require.NoError(t, err)
+	_, _, err = engine.PutAndGetPrevious("a", "22")
+	require.NoError(t, err)
+	_, _, err = engine.DeleteAndGetPrevious("a")
+	require.NoError(t, err)
+	deleted, err := engine.DeleteRange("c")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	require.NoError(t, engine.PutReader("r", strings.NewReader("abc"), 3))
+	require.NoError(t, engine.BulkLoad(func(put func(key, value string) error) error {
+		return put("k", "v")
+	}))
+
+	reader, err := engine.GetReader("s")
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	_, err = engine.GetReader("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.Equal(t, []int{2, 2, 2, 2, 2, 3, 3, 4, 2}, observer.putBytes)
+	assert.Equal(t, 3, observer.deletes)
+	assert.Equal(t, []bool{true, false}, observer.gets)
+}
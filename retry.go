@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// defaultRetryableErrors represents the errors WithRetry retries when it's given no errors, they
+// are the transient errors of networked file systems which usually succeed on a later attempt
+var defaultRetryableErrors = []error{syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ENOSPC}
+
+// retryPolicy represents how many times a failed file system call is attempted and which errors
+// are worth another attempt
+type retryPolicy struct {
+	// attempts represents the max number of attempts of a call including the first one
+	attempts int
+	// backoff represents the wait before the second attempt, it's doubled for every further attempt
+	backoff time.Duration
+	// retryable represents the errors which are retried, any other error fails the call immediately
+	retryable []error
+}
+
+// do runs op until it succeeds, fails with an error which is not retryable or runs out of attempts
+func (p *retryPolicy) do(op func() error) error {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.attempts || !p.isRetryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *retryPolicy) isRetryable(err error) bool {
+	for _, retryable := range p.retryable {
+		if errors.Is(err, retryable) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRetry retries the file system calls of the engine which fail with a transient error, e.g. on
+// networked file systems which return EAGAIN once in a while. A call is attempted up to attempts
+// times, waiting backoff before the second attempt and twice as long before every further one.
+// Only the given errors are retried, or EAGAIN, EINTR, EBUSY and ENOSPC if none are given, any
+// other error such as a denied permission fails the call immediately.
+func WithRetry(attempts int, backoff time.Duration, retryable ...error) OptionSetter {
+	return func(e *Engine) error {
+		if attempts < 1 {
+			return fmt.Errorf("invalid retry attempts")
+		}
+		if backoff < 0 {
+			return fmt.Errorf("invalid retry backoff")
+		}
+		if len(retryable) == 0 {
+			retryable = defaultRetryableErrors
+		}
+		e.retryPolicy = &retryPolicy{attempts: attempts, backoff: backoff, retryable: retryable}
+
+		return nil
+	}
+}
+
+// retryFS is the FS which retries the calls of another FS and the files it opens with a retry policy
+type retryFS struct {
+	fs     FS
+	policy *retryPolicy
+}
+
+func (r retryFS) Open(name string) (File, error) {
+	var file File
+	err := r.policy.do(func() (err error) {
+		file, err = r.fs.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return retryFile{File: file, policy: r.policy}, nil
+}
+
+func (r retryFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	var file File
+	err := r.policy.do(func() (err error) {
+		file, err = r.fs.OpenFile(name, flag, perm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return retryFile{File: file, policy: r.policy}, nil
+}
+
+func (r retryFS) Stat(name string) (info os.FileInfo, err error) {
+	err = r.policy.do(func() (err error) {
+		info, err = r.fs.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+func (r retryFS) Remove(name string) error {
+	return r.policy.do(func() error {
+		return r.fs.Remove(name)
+	})
+}
+
+func (r retryFS) Rename(oldpath, newpath string) error {
+	return r.policy.do(func() error {
+		return r.fs.Rename(oldpath, newpath)
+	})
+}
+
+func (r retryFS) ReadDir(name string) (entries []os.DirEntry, err error) {
+	err = r.policy.do(func() (err error) {
+		entries, err = r.fs.ReadDir(name)
+		return err
+	})
+	return entries, err
+}
+
+func (r retryFS) MkdirAll(path string, perm os.FileMode) error {
+	return r.policy.do(func() error {
+		return r.fs.MkdirAll(path, perm)
+	})
+}
+
+func (r retryFS) SyncDir(name string) error {
+	return r.policy.do(func() error {
+		return syncDir(r.fs, name)
+	})
+}
+
+// retryFile is a file of retryFS, the reads and the writes which fail after transferring some bytes
+// are continued from where they stopped, so a record is never written twice
+type retryFile struct {
+	File
+	policy *retryPolicy
+}
+
+func (f retryFile) Read(p []byte) (n int, err error) {
+	err = f.policy.do(func() (err error) {
+		n, err = f.File.Read(p)
+		// the bytes which are already read are returned and the next read continues after them
+		if n > 0 && err != nil && f.policy.isRetryable(err) {
+			err = nil
+		}
+		return err
+	})
+	return n, err
+}
+
+func (f retryFile) ReadAt(p []byte, off int64) (n int, err error) {
+	err = f.policy.do(func() error {
+		read, err := f.File.ReadAt(p[n:], off+int64(n))
+		n += read
+		return err
+	})
+	return n, err
+}
+
+func (f retryFile) Write(p []byte) (n int, err error) {
+	err = f.policy.do(func() error {
+		written, err := f.File.Write(p[n:])
+		n += written
+		return err
+	})
+	return n, err
+}
+
+func (f retryFile) Sync() error {
+	return f.policy.do(f.File.Sync)
+}
+
+func (f retryFile) Truncate(size int64) error {
+	return f.policy.do(func() error {
+		return f.File.Truncate(size)
+	})
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyFS fails the opens and the writes with err until it has failed failures times, the failed
+// writes write half of their data first
+type flakyFS struct {
+	FS
+	lock     sync.Mutex
+	err      error
+	failures int
+	calls    int
+}
+
+func (fsys *flakyFS) fail() error {
+	fsys.lock.Lock()
+	defer fsys.lock.Unlock()
+	fsys.calls++
+	if fsys.failures == 0 {
+		return nil
+	}
+	fsys.failures--
+	return fsys.err
+}
+
+func (fsys *flakyFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := fsys.fail(); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := fsys.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return flakyFile{File: file, fsys: fsys}, nil
+}
+
+type flakyFile struct {
+	File
+	fsys *flakyFS
+}
+
+func (f flakyFile) Write(p []byte) (int, error) {
+	if err := f.fsys.fail(); err != nil {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, err
+	}
+	return f.File.Write(p)
+}
+
+func TestRetry(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "kashk_retry_test", "data")
+	fsys := &flakyFS{FS: NewMemFS(), err: syscall.EAGAIN}
+
+	_, err := NewEngine(path, WithFileSystem(fsys), WithRetry(0, time.Millisecond))
+	assert.Error(t, err)
+	_, err = NewEngine(path, WithFileSystem(fsys), WithRetry(1, -time.Millisecond))
+	assert.Error(t, err)
+
+	engine, err := NewEngine(path, WithFileSystem(fsys), WithRetry(3, time.Millisecond))
+	require.NoError(t, err)
+
+	// every call fails twice before it succeeds, the half written records are completed
+	for _, key := range []string{"first", "second"} {
+		fsys.failures = 2
+		require.NoError(t, engine.Put(key, "value"))
+		require.NoError(t, engine.SyncNow())
+	}
+	fsys.failures = 2
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Close())
+
+	fsys.failures = 2
+	engine, err = NewEngine(path, WithFileSystem(fsys), WithRetry(3, time.Millisecond))
+	require.NoError(t, err)
+	for _, key := range []string{"first", "second"} {
+		value, err := engine.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+
+	// the calls which fail more often than the attempts fail the operation
+	fsys.failures = 3
+	assert.ErrorIs(t, engine.Put("third", "value"), syscall.EAGAIN)
+	_, err = engine.Get("third")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	require.NoError(t, engine.Close())
+
+	// the errors which are not retryable fail immediately
+	fsys.err, fsys.failures, fsys.calls = syscall.EACCES, 1, 0
+	_, err = NewEngine(path, WithFileSystem(fsys), WithRetry(3, time.Millisecond))
+	assert.ErrorIs(t, err, syscall.EACCES)
+	assert.Equal(t, 1, fsys.calls)
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// ForEach calls fn with the latest value of every live key in the storage engine.
+// A key updated in multiple logs is visited once with its newest value and deleted keys are skipped.
+// The read lock is held for the whole iteration to give a consistent view of the storage, so writes
+// are blocked until it finishes and fn must not call back into the engine.
+// The iteration stops at the first error returned by fn and that error is returned.
+func (e *Engine) ForEach(fn func(key, value string) error) error {
+	return e.ForEachContext(context.Background(), fn)
+}
+
+// ForEachContext works like ForEach, but stops the iteration and returns ctx.Err() once the
+// context is cancelled. The context is checked before every key is visited.
+func (e *Engine) ForEachContext(ctx context.Context, fn func(key, value string) error) error {
+	return e.scan(ctx, func(string) bool { return true }, fn)
+}
+
+// ScanPrefix calls fn with the latest value of every live key which starts with the given prefix,
+// an empty prefix visits all the keys. Since the index is a hash map the keys are visited in no
+// particular order. It holds the read lock like ForEach, so fn must not call back into the engine.
+func (e *Engine) ScanPrefix(prefix string, fn func(key, value string) error) error {
+	return e.ScanPrefixContext(context.Background(), prefix, fn)
+}
+
+// ScanPrefixContext works like ScanPrefix, but stops the iteration and returns ctx.Err() once the
+// context is cancelled.
+func (e *Engine) ScanPrefixContext(ctx context.Context, prefix string, fn func(key, value string) error) error {
+	prefix = e.normalizeKey(prefix)
+	return e.scan(ctx, func(key string) bool { return strings.HasPrefix(key, prefix) }, fn)
+}
+
+// ScanRange calls fn with the latest value of every live key where start <= key < end in the sorted
+// order of the keys. An empty start visits the keys from the first one and an empty end visits the
+// keys up to the last one. It holds the read lock like ForEach, so fn must not call back into the engine.
+func (e *Engine) ScanRange(start, end string, fn func(key, value string) error) error {
+	return e.scanRange(start, end, false, fn)
+}
+
+// ScanRangeInclusive works like ScanRange, but also visits the end key, start <= key <= end.
+func (e *Engine) ScanRangeInclusive(start, end string, fn func(key, value string) error) error {
+	return e.scanRange(start, end, true, fn)
+}
+
+// scanReadBatchSize represents the number of values of a range scan which are read together
+const scanReadBatchSize = 256
+
+// rangeEntry represents a key matched by a range scan, location orders the records of the matched
+// keys by the log and the offset they are stored at
+type rangeEntry struct {
+	key       string
+	location  int
+	readValue func() (string, error)
+}
+
+// scanRange calls fn with the latest value of every live key in the range in the sorted order of the keys.
+// Since the index is a hash map, the matched keys are collected and sorted before their values are read.
+func (e *Engine) scanRange(start, end string, inclusive bool, fn func(key, value string) error) error {
+	start, end = e.normalizeKey(start), e.normalizeKey(end)
+	inRange := func(key string) bool {
+		if key < start {
+			return false
+		}
+		if end == "" {
+			return true
+		}
+		return key < end || (inclusive && key == end)
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	// the keys are visited in the order of the records in the logs, which is kept as the location of
+	// their values to read them in that order
+	now := e.clock.Now()
+	var entries []rangeEntry
+	err := e.walkInFileOrder(context.Background(), func(key string, entry indexEntry, readValue func() (string, error)) error {
+		if inRange(key) && entry.live(now) {
+			entries = append(entries, rangeEntry{key: key, location: len(entries), readValue: readValue})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	// the values are read in batches of sorted keys, each batch in the order of the locations of its
+	// values, so a large range is read mostly sequentially without keeping all of its values in memory
+	values := make([]string, min(len(entries), scanReadBatchSize))
+	byLocation := make([]int, 0, len(values))
+	for start := 0; start < len(entries); start += scanReadBatchSize {
+		batch := entries[start:min(start+scanReadBatchSize, len(entries))]
+		byLocation = byLocation[:0]
+		for i := range batch {
+			byLocation = append(byLocation, i)
+		}
+		sort.Slice(byLocation, func(i, j int) bool {
+			return batch[byLocation[i]].location < batch[byLocation[j]].location
+		})
+		for _, i := range byLocation {
+			if values[i], err = batch[i].readValue(); err != nil {
+				return err
+			}
+		}
+		for i, entry := range batch {
+			if err := fn(entry.key, values[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// KeysWithPrefix returns the sorted list of the live keys which start with the given prefix, an empty
+// prefix returns all the keys. The liveness of a key is known from the index, so no value is read
+// from the disk, which makes it much cheaper than ScanPrefix for large values.
+func (e *Engine) KeysWithPrefix(prefix string) ([]string, error) {
+	prefix = e.normalizeKey(prefix)
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	now := e.clock.Now()
+	keys := []string{}
+	err := e.walk(context.Background(), func(key string, entry indexEntry, _ func() (string, error)) error {
+		if strings.HasPrefix(key, prefix) && entry.live(now) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// scan calls fn with the latest value of every live key accepted by the match function,
+// values of the keys which are not matched are never read from the disk.
+func (e *Engine) scan(ctx context.Context, match func(key string) bool, fn func(key, value string) error) error {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	now := e.clock.Now()
+	return e.walkInFileOrder(ctx, func(key string, entry indexEntry, readValue func() (string, error)) error {
+		if !match(key) || !entry.live(now) {
+			return nil
+		}
+		value, err := readValue()
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// walk calls fn with the index entry of the latest record of every key, including the deleted and
+// expired keys, and a function to read the value of the record, which reads the whole record with
+// a single positioned read. The keys of a log are visited in no particular order, so it's meant for
+// the walks which rarely read the values. The caller must hold the lock.
+func (e *Engine) walk(ctx context.Context, fn func(key string, entry indexEntry, readValue func() (string, error)) error) error {
+	return e.walkLogs(ctx, false, fn)
+}
+
+// walkInFileOrder works like walk, but visits the keys of each log in the order of their records in
+// the file, so reading the values sweeps through the file instead of seeking to random offsets.
+// Sorting the keys of a log costs a little, which pays off when most of the values are read.
+func (e *Engine) walkInFileOrder(ctx context.Context, fn func(key string, entry indexEntry, readValue func() (string, error)) error) error {
+	return e.walkLogs(ctx, true, fn)
+}
+
+// walkLogs visits the keys of the logs from the newest log to the oldest one, the keys of each log
+// are visited in the order of their offsets if inFileOrder is set
+func (e *Engine) walkLogs(ctx context.Context, inFileOrder bool, fn func(key string, entry indexEntry, readValue func() (string, error)) error) error {
+	visited := make(map[string]struct{})
+	visitKey := func(key string, entry indexEntry, readValue func(entry indexEntry) (string, error)) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the logs are visited from the newest to the oldest so the first visit has the latest value
+		if _, ok := visited[key]; ok {
+			return nil
+		}
+		visited[key] = struct{}{}
+		return fn(key, entry, func() (string, error) { return readValue(entry) })
+	}
+	visitLog := func(index map[string]indexEntry, readValue func(entry indexEntry) (string, error)) error {
+		if !inFileOrder {
+			for key, entry := range index {
+				if err := visitKey(key, entry, readValue); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		entries := make([]keyEntry, 0, len(index))
+		for key, entry := range index {
+			entries = append(entries, keyEntry{key: key, entry: entry})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].entry.offset < entries[j].entry.offset
+		})
+		for _, entry := range entries {
+			if err := visitKey(entry.key, entry.entry, readValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the write log of a read-only engine doesn't have a file
+	if e.writeLog.file != nil {
+		writeLogPath := e.writeLog.file.Name()
+		err := visitLog(e.writeLog.index, func(entry indexEntry) (string, error) {
+			rec, err := e.readWholeRecord(writeLogPath, entry.offset, entry.size, currentFormatVersion, true)
+			return rec.value, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		currentLog := e.readLogs[i]
+		err := visitLog(currentLog.index, func(entry indexEntry) (string, error) {
+			// the index of a legacy file points to the value, so only the value is read
+			if currentLog.version == formatVersionLegacy {
+				return e.readValueFromFile(currentLog.path, entry.offset, currentLog.version)
+			}
+			rec, err := e.readWholeRecord(currentLog.path, entry.offset, entry.size, currentLog.version, false)
+			return rec.value, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyEntry represents a key with the index entry of its record in a log
+type keyEntry struct {
+	key   string
+	entry indexEntry
+}
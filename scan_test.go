@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachVisitsLatestLiveValues(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_for_each")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	// update some keys and delete others so they live in multiple logs
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("new_value%d", i)))
+	}
+	for i := 5; i < 8; i++ {
+		require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i)))
+	}
+	require.Greater(t, len(engine.readLogs), 1, "Expected the data to be spread across multiple logs")
+
+	visited := make(map[string]string)
+	err = engine.ForEach(func(key, value string) error {
+		_, duplicate := visited[key]
+		assert.False(t, duplicate, "Key %s visited more than once", key)
+		visited[key] = value
+		return nil
+	})
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"key0": "new_value0",
+		"key1": "new_value1",
+		"key2": "new_value2",
+		"key3": "new_value3",
+		"key4": "new_value4",
+		"key8": "value8",
+		"key9": "value9",
+	}
+	assert.Equal(t, expected, visited)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestForEachStopsOnError(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_for_each_error")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "value"))
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = engine.ForEach(func(key, value string) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestScanPrefix(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_scan_prefix")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("user:1:profile", "gopher"))
+	require.NoError(t, engine.Put("user:2:profile", "badger"))
+	require.NoError(t, engine.Put("user:3:profile", "otter"))
+	require.NoError(t, engine.Put("order:1", "book"))
+	require.NoError(t, engine.Put("user:2:profile", "honey badger"))
+	require.NoError(t, engine.Delete("user:3:profile"))
+
+	collect := func(prefix string) map[string]string {
+		visited := make(map[string]string)
+		err := engine.ScanPrefix(prefix, func(key, value string) error {
+			visited[key] = value
+			return nil
+		})
+		require.NoError(t, err)
+		return visited
+	}
+
+	assert.Equal(t, map[string]string{
+		"user:1:profile": "gopher",
+		"user:2:profile": "honey badger",
+	}, collect("user:"))
+
+	assert.Equal(t, map[string]string{
+		"user:1:profile": "gopher",
+		"user:2:profile": "honey badger",
+		"order:1":        "book",
+	}, collect(""), "Expected an empty prefix to scan all the keys")
+
+	assert.Empty(t, collect("product:"))
+
+	require.NoError(t, engine.Close())
+}
+
+func TestForEachContextCancel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_for_each_context")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err = engine.ForEachContext(ctx, func(key, value string) error {
+		visited++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, visited, "Expected the iteration to stop right after the cancellation")
+
+	err = engine.ScanPrefixContext(ctx, "key", func(key, value string) error {
+		t.Fatalf("Unexpected visit of %s with a cancelled context", key)
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_keys_with_prefix")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	for _, key := range []string{"users/2", "users/1", "users/3", "orders/1"} {
+		require.NoError(t, engine.Put(key, "value"))
+	}
+	// the key is overwritten in a later log and then deleted in another one
+	require.NoError(t, engine.Put("users/3", "new value"))
+	require.NoError(t, engine.Delete("users/3"))
+	require.Greater(t, len(engine.readLogs), 1, "Expected the data to be spread across multiple logs")
+
+	keys, err := engine.KeysWithPrefix("users/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users/1", "users/2"}, keys)
+
+	keys, err = engine.KeysWithPrefix("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders/1", "users/1", "users/2"}, keys)
+
+	keys, err = engine.KeysWithPrefix("products/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestScanRange(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_scan_range")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	for _, key := range []string{"e", "b", "d", "a", "c"} {
+		require.NoError(t, engine.Put(key, "value-"+key))
+	}
+	require.NoError(t, engine.Put("c", "new-value-c"))
+	require.NoError(t, engine.Delete("d"))
+
+	scan := func(scanRange func(start, end string, fn func(key, value string) error) error, start, end string) []string {
+		var visited []string
+		err := scanRange(start, end, func(key, value string) error {
+			visited = append(visited, key+"="+value)
+			return nil
+		})
+		require.NoError(t, err)
+		return visited
+	}
+
+	assert.Equal(t, []string{"b=value-b", "c=new-value-c"}, scan(engine.ScanRange, "b", "e"))
+	assert.Equal(t, []string{"b=value-b", "c=new-value-c", "e=value-e"}, scan(engine.ScanRangeInclusive, "b", "e"))
+	assert.Equal(t, []string{"a=value-a", "b=value-b"}, scan(engine.ScanRange, "", "c"))
+	assert.Equal(t, []string{"c=new-value-c", "e=value-e"}, scan(engine.ScanRange, "c", ""))
+	assert.Len(t, scan(engine.ScanRange, "", ""), 4)
+	// the deleted key at the boundary is skipped
+	assert.Empty(t, scan(engine.ScanRangeInclusive, "d", "d"))
+	assert.Empty(t, scan(engine.ScanRange, "f", "z"))
+}
+
+func TestScanRangeReadsInBatches(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_scan_range_batches")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(4*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// the keys are written in the reverse order and updated in newer logs, so their order in the
+	// files differs from the sorted order and spans more than one batch
+	const keys = 2*scanReadBatchSize + 10
+	for round := 0; round < 2; round++ {
+		for i := keys - 1; i >= 0; i-- {
+			if round == 0 || i%3 == 0 {
+				require.NoError(t, engine.Put(fmt.Sprintf("key%04d", i), fmt.Sprintf("value%d_%d", i, round)))
+			}
+		}
+	}
+	require.Greater(t, len(engine.readLogs), 1)
+
+	visited := 0
+	err = engine.ScanRange("", "", func(key, value string) error {
+		round := 0
+		if visited%3 == 0 {
+			round = 1
+		}
+		assert.Equal(t, fmt.Sprintf("key%04d", visited), key)
+		assert.Equal(t, fmt.Sprintf("value%d_%d", visited, round), value)
+		visited++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, keys, visited)
+}
+
+func BenchmarkReadValuesInKeyOrder(b *testing.B) {
+	benchmarkReadValues(b, false)
+}
+
+func BenchmarkReadValuesInFileOrder(b *testing.B) {
+	benchmarkReadValues(b, true)
+}
+
+// benchmarkReadValues reads the values of many keys of a single large log either in the order the
+// keys are sorted in, like the range scans used to, or in the order of their offsets in the log
+func benchmarkReadValues(b *testing.B, inFileOrder bool) {
+	tempDir, err := os.MkdirTemp("", "benchmark_read_values")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	const keys = 50000
+	engine, err := NewEngine(tempDir, WithMaxLogSize(1*GB))
+	require.NoError(b, err)
+	defer engine.Close()
+	value := string(make([]byte, 1024))
+	for i := 0; i < keys; i++ {
+		// the keys are hashed so their order doesn't follow the order they are written in
+		require.NoError(b, engine.Put(fmt.Sprintf("%08x", uint32(i)*2654435761), value))
+	}
+	require.NoError(b, engine.RotateLog())
+	require.Len(b, engine.readLogs, 1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.lock.RLock()
+		if inFileOrder {
+			err = engine.walkInFileOrder(context.Background(), func(_ string, _ indexEntry, readValue func() (string, error)) error {
+				_, err := readValue()
+				return err
+			})
+		} else {
+			var entries []rangeEntry
+			err = engine.walk(context.Background(), func(key string, _ indexEntry, readValue func() (string, error)) error {
+				entries = append(entries, rangeEntry{key: key, readValue: readValue})
+				return nil
+			})
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].key < entries[j].key
+			})
+			for _, entry := range entries {
+				if _, err = entry.readValue(); err != nil {
+					break
+				}
+			}
+		}
+		engine.lock.RUnlock()
+		require.NoError(b, err)
+	}
+}
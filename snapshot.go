@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSnapshotClosed is returned when a snapshot is used after it's closed
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// snapshotManager keeps track of the open snapshots, the data files are not removed while a snapshot is open
+type snapshotManager struct {
+	lock sync.Mutex
+	open int
+	// deferred represents the removals of the data files which are postponed until the last snapshot is closed
+	deferred []func()
+}
+
+// Snapshot represents a read-only view of the storage engine as of the time it's created, the later
+// writes are not visible through it. A snapshot keeps the data files it reads from open, and the data
+// files removed by compaction or garbage collection are only removed once all the snapshots are closed,
+// so a snapshot should be closed as soon as it's not needed anymore. A Snapshot is safe for concurrent use.
+type Snapshot struct {
+	engine *Engine
+	// now represents the time the snapshot is created at, the records are expired as of this time
+	now  time.Time
+	lock sync.RWMutex
+	// logs represents the logs of the engine from the newest to the oldest
+	logs   []snapshotLog
+	closed bool
+}
+
+// snapshotLog represents a log as it's captured by a snapshot
+type snapshotLog struct {
+	file    File
+	index   map[string]indexEntry
+	version int
+}
+
+// Snapshot returns a view of the current state of the storage engine. The indexes of the read logs
+// never change so they are shared, only the index of the write log is copied.
+func (e *Engine) Snapshot() (_ *Snapshot, err error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	snapshot := &Snapshot{engine: e, now: e.clock.Now()}
+	defer func() {
+		if err != nil {
+			snapshot.closeFiles()
+		}
+	}()
+
+	if e.writeLog.file != nil && e.writeLog.size > 0 {
+		// the records of the copied index may still be in the buffer of the write log
+		if err := e.writeLog.flush(); err != nil {
+			return nil, err
+		}
+		file, err := e.fs.Open(e.writeLog.file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the write log: %w", err)
+		}
+		index := make(map[string]indexEntry, len(e.writeLog.index))
+		for key, entry := range e.writeLog.index {
+			index[key] = entry
+		}
+		snapshot.logs = append(snapshot.logs, snapshotLog{file: file, index: index, version: currentFormatVersion})
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		log := e.readLogs[i]
+		file, err := e.fs.Open(log.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", log.path, err)
+		}
+		snapshot.logs = append(snapshot.logs, snapshotLog{file: file, index: log.index, version: log.version})
+	}
+
+	e.snapshotManager.lock.Lock()
+	e.snapshotManager.open++
+	e.snapshotManager.lock.Unlock()
+	return snapshot, nil
+}
+
+// Get retrieves the value the key has when the snapshot is created
+func (s *Snapshot) Get(key string) (string, error) {
+	key = s.engine.normalizeKey(key)
+	if err := s.engine.validateKey(key); err != nil {
+		return "", err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return "", ErrSnapshotClosed
+	}
+
+	for _, log := range s.logs {
+		entry, ok := log.index[key]
+		if !ok {
+			continue
+		}
+		if !entry.live(s.now) {
+			break
+		}
+		rec, err := readAtDataFile(log.file, log.file.Name(), entry.offset, log.version)
+		if err != nil {
+			return "", err
+		}
+		return s.engine.decodeValue(rec)
+	}
+	return "", fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+}
+
+// Close releases the data files held by the snapshot. It's safe to call Close more than once.
+func (s *Snapshot) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.closeFiles()
+	s.lock.Unlock()
+
+	s.engine.snapshotManager.release()
+	return err
+}
+
+// closeFiles closes the data files opened by the snapshot
+func (s *Snapshot) closeFiles() error {
+	var errs []error
+	for _, log := range s.logs {
+		errs = append(errs, log.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// release marks a snapshot as closed and runs the deferred removals once no snapshot is open
+func (m *snapshotManager) release() {
+	m.lock.Lock()
+	m.open--
+	var deferred []func()
+	if m.open == 0 {
+		deferred, m.deferred = m.deferred, nil
+	}
+	m.lock.Unlock()
+
+	for _, remove := range deferred {
+		remove()
+	}
+}
+
+// removeUnpinned runs remove right away if no snapshot is open, otherwise it's run when the last
+// snapshot is closed
+func (m *snapshotManager) removeUnpinned(remove func()) {
+	m.lock.Lock()
+	if m.open > 0 {
+		m.deferred = append(m.deferred, remove)
+		m.lock.Unlock()
+		return
+	}
+	m.lock.Unlock()
+
+	remove()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotDoesNotSeeLaterWrites(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithWriteBufferSize(4*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("name", "gopher"))
+	require.NoError(t, engine.Put("other", "otter"))
+
+	snapshot, err := engine.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("name", "badger"))
+	require.NoError(t, engine.Delete("other"))
+	require.NoError(t, engine.Put("new", "newt"))
+
+	value, err := snapshot.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", value)
+	value, err = snapshot.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "otter", value)
+	_, err = snapshot.Get("new")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	value, err = engine.Get("name")
+	require.NoError(t, err)
+	assert.Equal(t, "badger", value)
+
+	require.NoError(t, snapshot.Close())
+	_, err = snapshot.Get("name")
+	assert.ErrorIs(t, err, ErrSnapshotClosed)
+	assert.NoError(t, snapshot.Close())
+}
+
+func TestSnapshotPinsCompactedLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64), WithBackupRetention(0, 0))
+	require.NoError(t, err)
+	defer engine.Close()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "old"))
+	}
+
+	snapshot, err := engine.Snapshot()
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "new"))
+	}
+	require.NoError(t, engine.Compact())
+
+	// the backup of the compacted logs is kept while the snapshot is open
+	backups, err := os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+	for i := 0; i < 20; i++ {
+		value, err := snapshot.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, "old", value)
+	}
+
+	require.NoError(t, snapshot.Close())
+	backups, err = os.ReadDir(filepath.Join(tempDir, compactionBackupDir))
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestSnapshotPinsDroppedLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(64))
+	require.NoError(t, err)
+	defer engine.Close()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), "old"))
+	}
+	dataFiles, err := extractDatafiles(osFS{}, ensureTrailingSlash(tempDir))
+	require.NoError(t, err)
+	require.NotEmpty(t, dataFiles)
+
+	snapshot, err := engine.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, engine.DropAll())
+
+	// the dropped data files are kept while the snapshot is open
+	for _, path := range dataFiles {
+		assert.FileExists(t, path)
+	}
+	for i := 0; i < 20; i++ {
+		value, err := snapshot.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, "old", value)
+	}
+
+	require.NoError(t, snapshot.Close())
+	for _, path := range dataFiles {
+		assert.NoFileExists(t, path)
+	}
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// EngineStats represents a point in time view of the internal state of the storage engine
+type EngineStats struct {
+	// ReadLogs represents the number of closed log files
+	ReadLogs int
+	// DiskBytes represents the total size of all the data files including the write log
+	DiskBytes int64
+	// Keys represents the number of live keys, deleted and expired keys are not counted
+	Keys int
+	// WriteLogBytes represents the size of the current write log
+	WriteLogBytes int64
+	// DeadBytes is an estimate of the bytes which can be reclaimed by compaction, it includes the
+	// records superseded by newer records of the same key, tombstones and expired records
+	DeadBytes int64
+	// CacheHits represents the number of reads served from the value cache
+	CacheHits uint64
+	// CacheMisses represents the number of reads of live keys which are not found in the value cache
+	CacheMisses uint64
+	// BloomFilterSkips represents the number of read logs whose index is skipped in the lookups because
+	// their bloom filter doesn't have the key
+	BloomFilterSkips uint64
+}
+
+// DiskUsage returns the total size of the data files of the engine in bytes, including the write log
+// and the writes which are still buffered. The compaction backups are not counted. It's computed from
+// the in-memory state of the logs without touching the disk.
+func (e *Engine) DiskUsage() (int64, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return 0, ErrEngineClosed
+	}
+	return e.diskUsage(), nil
+}
+
+// diskUsage returns the total size of the data files, the caller must hold the lock
+func (e *Engine) diskUsage() int64 {
+	size := e.writeLog.size
+	for _, log := range e.readLogs {
+		size += log.size
+	}
+	return size
+}
+
+// Stats returns the current metrics of the storage engine. It's computed from the in-memory index
+// without reading the data files, so it's cheap enough to be called on a metrics scrape interval.
+func (e *Engine) Stats() EngineStats {
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	stats := EngineStats{
+		ReadLogs:      len(e.readLogs),
+		WriteLogBytes: e.writeLog.size,
+	}
+	stats.CacheHits, stats.CacheMisses = e.valueCache.counters()
+	stats.BloomFilterSkips = e.bloomSkips.Load()
+
+	visited := make(map[string]struct{})
+	visitLog := func(size int64, version int, index map[string]indexEntry) {
+		var liveBytes int64
+		for key, entry := range index {
+			// the logs are visited from the newest to the oldest so the first visit is the latest record
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			if entry.live(now) {
+				liveBytes += entry.size
+				stats.Keys++
+			}
+		}
+
+		stats.DiskBytes += size
+		// the file header is not reclaimable, every data file has one
+		if version != formatVersionLegacy && size > 0 {
+			size -= fileHeaderSize
+		}
+		stats.DeadBytes += size - liveBytes
+	}
+
+	visitLog(e.writeLog.size, currentFormatVersion, e.writeLog.index)
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		visitLog(e.readLogs[i].size, e.readLogs[i].version, e.readLogs[i].index)
+	}
+
+	return stats
+}
+
+// LogStat represents the space used by a single read log
+type LogStat struct {
+	// Path represents the path of the data file of the log
+	Path string
+	// Bytes represents the size of the data file
+	Bytes int64
+	// LiveKeys represents the number of keys whose latest live record is in the log
+	LiveKeys int
+	// DeadBytes is an estimate of the bytes of the log which can be reclaimed by compaction, these are
+	// the records superseded by newer logs, tombstones and expired records
+	DeadBytes int64
+}
+
+// LogStats returns the space used by each read log from the oldest to the newest, so it can be decided
+// whether a compaction pays off. Like Stats it's computed from the in-memory index.
+func (e *Engine) LogStats() []LogStat {
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	shadowed := make(map[string]struct{}, len(e.writeLog.index))
+	for key := range e.writeLog.index {
+		shadowed[key] = struct{}{}
+	}
+
+	stats := make([]LogStat, len(e.readLogs))
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		log := e.readLogs[i]
+		liveBytes, liveKeys := liveRecords(log, shadowed, now)
+		size := log.size
+		// the file header is not reclaimable, every data file has one
+		if log.version != formatVersionLegacy && size > 0 {
+			size -= fileHeaderSize
+		}
+		stats[i] = LogStat{Path: log.path, Bytes: log.size, LiveKeys: liveKeys, DeadBytes: size - liveBytes}
+
+		for key := range log.index {
+			shadowed[key] = struct{}{}
+		}
+	}
+
+	return stats
+}
+
+// LogInfo represents a data file of the engine as it's listed by ListLogs
+type LogInfo struct {
+	// Path represents the path of the data file
+	Path string
+	// Sequence represents the sequence number the data file is named after, the logs written by a
+	// compaction share the sequence number of the newest compacted log and are told apart by Part
+	Sequence int
+	// Part represents the number of the output file of a compaction, zero for the other logs
+	Part int
+	// Generation represents how many times the data file is rewritten by the garbage collector
+	Generation int
+	// Bytes represents the size of the data file including the buffered writes of the write log
+	Bytes int64
+	// Keys represents the number of keys whose latest record in the log is kept in its index,
+	// including the keys deleted in the log and the keys overwritten by newer logs
+	Keys int
+	// Version represents the format version of the data file
+	Version int
+	// Active is set for the write log, which is the only log being written to
+	Active bool
+}
+
+// ListLogs returns the data files of the engine from the oldest to the newest, the write log is the
+// last one. A read-only engine doesn't have a write log. Unlike LogStats it doesn't look at which
+// records are live, so it's cheap enough for tooling to poll.
+func (e *Engine) ListLogs() []LogInfo {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	logs := make([]LogInfo, 0, len(e.readLogs)+1)
+	for _, log := range e.readLogs {
+		logs = append(logs, newLogInfo(log.path, log.size, len(log.index), log.version))
+	}
+	// the write log of a read-only engine doesn't have a file
+	if !e.closed && e.writeLog.file != nil {
+		info := newLogInfo(e.writeLog.file.Name(), e.writeLog.size, len(e.writeLog.index), currentFormatVersion)
+		info.Active = true
+		logs = append(logs, info)
+	}
+	return logs
+}
+
+func newLogInfo(path string, size int64, keys, version int) LogInfo {
+	sequence, part, generation := parseFileName(path)
+	return LogInfo{Path: path, Sequence: sequence, Part: part, Generation: generation, Bytes: size, Keys: keys, Version: version}
+}
+
+// defaultHistogramBounds represents the upper bounds of the buckets of SizeHistogram unless
+// WithHistogramBuckets is used
+var defaultHistogramBounds = []int64{16, 64, 256, KB, 4 * KB, 16 * KB, 64 * KB, 256 * KB, MB}
+
+// Histogram represents the distribution of sizes over buckets
+type Histogram struct {
+	// Bounds represents the inclusive upper bounds of the buckets in bytes in increasing order
+	Bounds []int64
+	// Counts represents the number of sizes in each bucket, it has an extra last element which
+	// counts the sizes larger than the last bound
+	Counts []int
+}
+
+// add counts the size in its bucket
+func (h Histogram) add(size int64) {
+	i, _ := slices.BinarySearch(h.Bounds, size)
+	h.Counts[i]++
+}
+
+// newHistogram returns an empty histogram with the given bounds
+func newHistogram(bounds []int64) Histogram {
+	return Histogram{Bounds: slices.Clone(bounds), Counts: make([]int, len(bounds)+1)}
+}
+
+// SizeHistogram bins the sizes of the live keys and of their values into the buckets set by
+// WithHistogramBuckets, e.g. for capacity planning. The values are measured as they are stored, i.e.
+// after the compression. Unlike Stats it reads the size of every live value from the data files, so it
+// holds the read lock for a while on a large data path and it's meant to be called explicitly.
+func (e *Engine) SizeHistogram() (keys, values Histogram, err error) {
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return Histogram{}, Histogram{}, ErrEngineClosed
+	}
+
+	bounds := e.histogramBounds
+	if bounds == nil {
+		bounds = defaultHistogramBounds
+	}
+	keys, values = newHistogram(bounds), newHistogram(bounds)
+
+	// the values of the write log may still be in its buffer
+	if err := e.writeLog.flush(); err != nil {
+		return Histogram{}, Histogram{}, err
+	}
+
+	visited := make(map[string]struct{})
+	visitLog := func(path string, version int, index map[string]indexEntry, immutable bool) error {
+		acquire := e.fileCache.acquire
+		if immutable {
+			acquire = e.fileCache.acquireMapped
+		}
+		var cf *cachedFile
+		for key, entry := range index {
+			// the logs are visited from the newest to the oldest so the first visit is the latest record
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			if !entry.live(now) {
+				continue
+			}
+
+			// the file is only opened if it has a live value
+			if cf == nil {
+				var err error
+				if cf, err = acquire(path); err != nil {
+					return err
+				}
+				defer e.fileCache.release(cf)
+			}
+			size, err := readValueSize(cf, entry.offset, version)
+			if err != nil {
+				return fmt.Errorf("failed to read value size of %s at offset %d of %s: %w", key, entry.offset, path, err)
+			}
+			keys.add(int64(len(key)))
+			values.add(size)
+		}
+		return nil
+	}
+
+	// the write log of a read-only engine doesn't have a file
+	if e.writeLog.file != nil {
+		if err := visitLog(e.writeLog.file.Name(), currentFormatVersion, e.writeLog.index, false); err != nil {
+			return Histogram{}, Histogram{}, err
+		}
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		log := e.readLogs[i]
+		if err := visitLog(log.path, log.version, log.index, true); err != nil {
+			return Histogram{}, Histogram{}, err
+		}
+	}
+
+	return keys, values, nil
+}
+
+// readValueSize reads the size of the value of the record at the given offset of a data file with the
+// given format version from its header, without reading the value
+func readValueSize(file io.ReaderAt, offset int64, version int) (int64, error) {
+	// the index of legacy files points to the value size
+	if version == formatVersionLegacy {
+		buffer := make([]byte, 4)
+		if _, err := file.ReadAt(buffer, offset); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return int64(binary.LittleEndian.Uint32(buffer)), nil
+	}
+
+	header := make([]byte, recordHeaderSize(version))
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return int64(binary.LittleEndian.Uint32(header[8:])), nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_stats")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+
+	stats := engine.Stats()
+	assert.Equal(t, EngineStats{}, stats, "Expected empty stats for an empty engine")
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	stats = engine.Stats()
+	assert.Equal(t, 10, stats.Keys)
+	assert.Zero(t, stats.DeadBytes, "Expected no dead bytes without updates and deletes")
+	assert.Equal(t, len(engine.readLogs), stats.ReadLogs)
+	assert.Equal(t, engine.writeLog.size, stats.WriteLogBytes)
+
+	// updates don't change the number of keys but leave dead records behind
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("new_value%d", i)))
+	}
+	updatedStats := engine.Stats()
+	assert.Equal(t, 10, updatedStats.Keys)
+	assert.Greater(t, updatedStats.DeadBytes, int64(0))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i)))
+	}
+	require.NoError(t, engine.Delete("missing"))
+	deletedStats := engine.Stats()
+	assert.Equal(t, 7, deletedStats.Keys)
+	assert.Greater(t, deletedStats.DeadBytes, updatedStats.DeadBytes)
+
+	var diskBytes int64
+	dataFiles, err := extractDatafiles(osFS{}, tempDir)
+	require.NoError(t, err)
+	for _, path := range dataFiles {
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		diskBytes += stat.Size()
+	}
+	assert.Equal(t, diskBytes, deletedStats.DiskBytes)
+
+	require.NoError(t, engine.Close())
+}
+
+func TestLogStats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_log_stats")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("overwritten", "old value"))
+	require.NoError(t, engine.Put("kept", "value"))
+	require.NoError(t, engine.rotateWriteLog())
+	require.NoError(t, engine.Put("overwritten", "new value"))
+	require.NoError(t, engine.Delete("deleted"))
+	require.NoError(t, engine.rotateWriteLog())
+
+	stats := engine.LogStats()
+	require.Len(t, stats, 2)
+
+	older := engine.readLogs[0]
+	assert.Equal(t, older.path, stats[0].Path)
+	assert.Equal(t, older.size, stats[0].Bytes)
+	assert.Equal(t, 1, stats[0].LiveKeys)
+	// the overwritten key is dead space in the older log
+	assert.Equal(t, older.index["overwritten"].size, stats[0].DeadBytes)
+
+	newer := engine.readLogs[1]
+	assert.Equal(t, 1, stats[1].LiveKeys)
+	assert.Equal(t, newer.index["deleted"].size, stats[1].DeadBytes)
+
+	// a key overwritten in the write log shadows its record in the read logs
+	require.NoError(t, engine.Put("kept", "new value"))
+	stats = engine.LogStats()
+	assert.Zero(t, stats[0].LiveKeys)
+	assert.Equal(t, older.size-fileHeaderSize, stats[0].DeadBytes)
+}
+
+func TestListLogs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_list_logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("first", "value"))
+	require.NoError(t, engine.Put("second", "value"))
+	require.NoError(t, engine.Delete("first"))
+	writeLogPath := engine.writeLog.file.Name()
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("third", "value"))
+
+	logs := engine.ListLogs()
+	require.Len(t, logs, 2)
+	assert.Equal(t, LogInfo{
+		Path:     writeLogPath,
+		Sequence: extractFileNumber(writeLogPath),
+		Bytes:    engine.readLogs[0].size,
+		Keys:     2,
+		Version:  currentFormatVersion,
+	}, logs[0])
+
+	// the write log is listed last
+	assert.Equal(t, engine.writeLog.file.Name(), logs[1].Path)
+	assert.Equal(t, logs[0].Sequence+1, logs[1].Sequence)
+	assert.Equal(t, engine.writeLog.size, logs[1].Bytes)
+	assert.Equal(t, 1, logs[1].Keys)
+	assert.True(t, logs[1].Active)
+}
+
+func TestDiskQuota(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_disk_quota")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(256), WithMaxDiskBytes(2048))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// the same keys are overwritten until the quota trips
+	for i := 0; ; i++ {
+		err = engine.Put(fmt.Sprintf("key%d", i%5), fmt.Sprintf("value%d", i))
+		if err != nil {
+			break
+		}
+		require.Less(t, i, 1000)
+	}
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	usage, err := engine.DiskUsage()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, usage, int64(2048))
+	assert.Equal(t, engine.Stats().DiskBytes, usage)
+
+	batch := engine.NewBatch()
+	batch.Put("key", "value")
+	assert.ErrorIs(t, batch.Commit(), ErrQuotaExceeded)
+
+	// deletes are still written so the space can be reclaimed
+	require.NoError(t, engine.Delete("key0"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Compact())
+
+	compacted, err := engine.DiskUsage()
+	require.NoError(t, err)
+	assert.Less(t, compacted, usage)
+	require.NoError(t, engine.Put("key0", "value"))
+	value, err := engine.Get("key0")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	_, err = NewEngine(tempDir, WithMaxDiskBytes(0))
+	assert.Error(t, err)
+}
+
+func TestSizeHistogram(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_size_histogram")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithHistogramBuckets(4, 16))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("a", strings.Repeat("x", 100)))
+	require.NoError(t, engine.Put("abcde", ""))
+	require.NoError(t, engine.RotateLog())
+	// only the latest live value of every key is counted
+	require.NoError(t, engine.Put("abcde", strings.Repeat("x", 10)))
+	require.NoError(t, engine.Put("abcdefghijklmnopqrstu", "xyz"))
+	require.NoError(t, engine.Put("deleted", strings.Repeat("x", 100)))
+	require.NoError(t, engine.Delete("deleted"))
+
+	keys, values, err := engine.SizeHistogram()
+	require.NoError(t, err)
+	assert.Equal(t, Histogram{Bounds: []int64{4, 16}, Counts: []int{1, 1, 1}}, keys)
+	assert.Equal(t, Histogram{Bounds: []int64{4, 16}, Counts: []int{1, 1, 1}}, values)
+
+	// a read-only engine has no write log file
+	require.NoError(t, engine.Close())
+	readOnly, err := OpenForRead(tempDir, WithHistogramBuckets(4, 16))
+	require.NoError(t, err)
+	defer readOnly.Close()
+	readOnlyKeys, readOnlyValues, err := readOnly.SizeHistogram()
+	require.NoError(t, err)
+	assert.Equal(t, keys, readOnlyKeys)
+	assert.Equal(t, values, readOnlyValues)
+
+	_, err = NewEngine(tempDir, WithHistogramBuckets(16, 4))
+	assert.ErrorContains(t, err, "invalid histogram buckets")
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// GetReader returns a reader which streams the value of the key from its data file, so a large value
+// can be copied e.g. to a network connection without being held in memory. The reader holds the data
+// file open until it's closed, so it reads the value as of the call even if the key is changed or its
+// log is compacted meanwhile, and it must always be closed. The checksum of the record is verified as
+// the value is read, a mismatch is returned as ErrCorruptRecord by the read which reaches the end of
+// the value. A compressed or encrypted value is decoded in memory, since the codec and the cipher work
+// on whole values.
+func (e *Engine) GetReader(key string) (io.ReadCloser, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return nil, err
+	}
+	start, now := time.Now(), e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	reader, err := e.openValueReader(key, now)
+	e.observeGet(start, err)
+	return reader, err
+}
+
+// openValueReader returns a reader of the latest live value of the key, the caller must hold the lock
+func (e *Engine) openValueReader(key string, now time.Time) (io.ReadCloser, error) {
+
+	if value, ok := e.valueCache.get(key); ok {
+		if entry, ok := e.latestEntry(key); ok && entry.live(now) {
+			return io.NopCloser(strings.NewReader(value)), nil
+		}
+	}
+
+	path, version, immutable := "", currentFormatVersion, false
+	entry, ok := e.writeLog.index[key]
+	if ok {
+		path = e.writeLog.file.Name()
+		// the record may still be in the buffer of the write log
+		if err := e.writeLog.flush(); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := len(e.readLogs) - 1; i >= 0; i-- {
+			if entry, ok = e.lookupReadLog(e.readLogs[i], key); ok {
+				path, version, immutable = e.readLogs[i].path, e.readLogs[i].version, true
+				break
+			}
+		}
+	}
+	if !ok || !entry.live(now) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	acquire := e.fileCache.acquire
+	if immutable {
+		acquire = e.fileCache.acquireMapped
+	}
+	cf, err := acquire(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := e.newValueReader(cf, path, entry.offset, version)
+	if err != nil {
+		e.fileCache.release(cf)
+		return nil, err
+	}
+	return reader, nil
+}
+
+// newValueReader returns a reader of the value of the record at the given offset of the file, the
+// reader takes over the reference to the cached file and releases it when it's closed
+func (e *Engine) newValueReader(cf *cachedFile, path string, offset int64, version int) (io.ReadCloser, error) {
+	// the index of legacy files points to the value size and their records have no checksum
+	if version == formatVersionLegacy {
+		size, err := readValueSize(cf, offset, version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value size at offset %d of %s: %w", offset, path, err)
+		}
+		return &valueReader{cache: e.fileCache, file: cf, path: path, offset: offset, section: io.NewSectionReader(cf, offset+4, size)}, nil
+	}
+
+	header := make([]byte, recordHeaderSize(version))
+	if _, err := cf.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("failed to read record header at offset %d of %s: %w", offset, path, unexpectedEOF(err))
+	}
+	keySize := int64(binary.LittleEndian.Uint32(header[4:]))
+	valueSize := int64(binary.LittleEndian.Uint32(header[8:]))
+	var flags byte
+	if version >= formatVersionFlags {
+		flags = header[20]
+	}
+	var tagsSize int64
+	if version >= formatVersionTags {
+		tagsSize = int64(binary.LittleEndian.Uint32(header[21:]))
+	}
+
+	// the codec and the cipher only work on whole values, so such a value is read and decoded at once
+	if flags&(flagCompressed|flagEncrypted) != 0 {
+		rec, err := readAtDataFile(cf, path, offset, version)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.decodeValue(rec)
+		if err != nil {
+			return nil, err
+		}
+		e.fileCache.release(cf)
+		return io.NopCloser(strings.NewReader(value)), nil
+	}
+
+	// the key and the tags precede the value and are covered by the checksum
+	keyAndTags := make([]byte, keySize+tagsSize)
+	if _, err := cf.ReadAt(keyAndTags, offset+int64(len(header))); err != nil {
+		return nil, fmt.Errorf("failed to read key at offset %d of %s: %w", offset, path, unexpectedEOF(err))
+	}
+	return &valueReader{
+		cache:    e.fileCache,
+		file:     cf,
+		path:     path,
+		offset:   offset,
+		section:  io.NewSectionReader(cf, offset+int64(len(header))+keySize+tagsSize, valueSize),
+		verify:   true,
+		crc:      crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, keyAndTags),
+		checksum: binary.LittleEndian.Uint32(header),
+	}, nil
+}
+
+// valueReader streams a value from a cached data file and verifies the checksum of its record
+type valueReader struct {
+	cache   *fileCache
+	file    *cachedFile
+	path    string
+	offset  int64
+	section *io.SectionReader
+	read    int64
+	// verify reports whether the record has a checksum, crc is updated with every read byte and it's
+	// compared to the checksum once the whole value is read
+	verify   bool
+	crc      uint32
+	checksum uint32
+	closed   bool
+}
+
+// Read reads the next bytes of the value
+func (r *valueReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	n, err := r.section.Read(p)
+	r.read += int64(n)
+	if r.verify {
+		r.crc = crc32.Update(r.crc, crcTable, p[:n])
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if r.read < r.section.Size() {
+		return n, fmt.Errorf("failed to read value at offset %d of %s: %w", r.offset, r.path, io.ErrUnexpectedEOF)
+	}
+	if r.verify && r.crc != r.checksum {
+		return n, fmt.Errorf("%w at offset %d of %s", ErrCorruptRecord, r.offset, r.path)
+	}
+	return n, io.EOF
+}
+
+// Close releases the data file, closing the reader again has no effect
+func (r *valueReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.cache.release(r.file)
+	return nil
+}
+
+// PutReader sets the key to exactly size bytes read from r, which are streamed into the write log
+// without holding the whole value in memory. The size is checked against the limits before anything is
+// read. The value is staged in a temporary file of the data path first, so a slow reader doesn't hold
+// the lock of the engine, and the record is only appended once the whole value is read. If r ends early
+// nothing is written, and if the append fails the partial record is truncated from the write log.
+// The value is stored without compression, since the codec works on whole values. With WithEncryption
+// the value is read into memory and encrypted as a whole before it's written like Put does.
+func (e *Engine) PutReader(key string, r io.Reader, size int64) error {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid value size %d", size)
+	}
+	if err := e.validateValueSize(size); err != nil {
+		return err
+	}
+	if e.aead != nil {
+		value := make([]byte, size)
+		if read, err := io.ReadFull(r, value); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("failed to read value of %s: %w, read %d of %d bytes", key, err, read, size)
+		}
+		return e.appendKeyValue(record{key: key, value: string(value)})
+	}
+
+	start := time.Now()
+	staged, header, err := e.stageStreamedValue(key, r, size)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		staged.Close()
+		_ = e.fs.Remove(staged.Name())
+	}()
+
+	if err := e.lockWrites(); err != nil {
+		return err
+	}
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	recordSize := int64(len(header)) + size
+	if err := e.checkDiskQuota(recordSize); err != nil {
+		return err
+	}
+	if err := e.prepareWriteLog(recordSize); err != nil {
+		return err
+	}
+	// the record is written to the file directly, so the buffer must not hold anything before it
+	if err := e.writeLog.flush(); err != nil {
+		return err
+	}
+
+	// the index points to the beginning of the record
+	offset := e.writeLog.size
+	if err := e.writeStagedRecord(header, staged, size); err != nil {
+		return errors.Join(err, e.truncateWriteLog(offset))
+	}
+	if e.syncManager.writes {
+		if err := e.writeLog.sync(); err != nil {
+			return err
+		}
+	}
+
+	e.updateIndex(record{key: key}, offset, recordSize)
+	e.observer.OnPut(time.Since(start), len(key)+int(size))
+
+	e.finishOversizedWrite(recordSize)
+	return nil
+}
+
+// stagedValueSuffix represents the suffix of the temporary files PutReader stages the values in
+const stagedValueSuffix = ".stream.tmp"
+
+// stageStreamedValue copies size bytes of r to a new temporary file in the data path and returns it
+// with the header and the key of the record of the value, whose checksum covers the copied value.
+// It doesn't need the lock of the engine, so the other operations continue while r is read.
+func (e *Engine) stageStreamedValue(key string, r io.Reader, size int64) (File, []byte, error) {
+	headerSize := recordHeaderSize(currentFormatVersion)
+	header := make([]byte, headerSize+len(key))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(size))
+	copy(header[headerSize:], key)
+	checksum := crc32.New(crcTable)
+	checksum.Write(header[4:])
+
+	path := fmt.Sprintf("%s%d%s", e.dataPath, e.stagedValues.Add(1), stagedValueSuffix)
+	file, err := e.fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, e.fileMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create staging file of %s: %w", key, err)
+	}
+	copied, err := io.CopyN(io.MultiWriter(file, checksum), r, size)
+	if err != nil {
+		file.Close()
+		_ = e.fs.Remove(path)
+		if errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("failed to read value of %s: %w, read %d of %d bytes", key, io.ErrUnexpectedEOF, copied, size)
+		}
+		return nil, nil, err
+	}
+	binary.LittleEndian.PutUint32(header, checksum.Sum32())
+	return file, header, nil
+}
+
+// writeStagedRecord appends the record with the given header and the value staged in the file to the
+// write log, the caller must hold the write lock
+func (e *Engine) writeStagedRecord(header []byte, staged File, size int64) error {
+	written, err := e.writeLog.file.Write(header)
+	e.writeLog.size += int64(written)
+	if err != nil {
+		return err
+	}
+	copied, err := io.Copy(e.writeLog.file, io.NewSectionReader(staged, 0, size))
+	e.writeLog.size += copied
+	if err == nil && copied < size {
+		err = fmt.Errorf("failed to copy staged value: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// removeStagedValues removes the staging files left in the data path by a crash during PutReader
+func removeStagedValues(fsys FS, path string) error {
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), stagedValueSuffix) {
+			if err := fsys.Remove(path + entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
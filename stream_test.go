@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetReader(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_get_reader")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	value := make([]byte, 4*MB)
+	rand.New(rand.NewSource(1)).Read(value)
+	require.NoError(t, engine.PutBytes("large", value))
+
+	// the value is read from the write log
+	reader, err := engine.GetReader("large")
+	require.NoError(t, err)
+	read, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, value, read)
+
+	// a reader opened before the key is changed keeps reading the old value from the read log
+	require.NoError(t, engine.RotateLog())
+	reader, err = engine.GetReader("large")
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("large", "small"))
+	read, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.NoError(t, reader.Close())
+	assert.Equal(t, value, read)
+
+	_, err = engine.GetReader("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// a corrupt value is reported once the reader reaches its end
+	require.NoError(t, engine.Delete("large"))
+	require.NoError(t, engine.Put("corrupt", string(value)))
+	path := engine.writeLog.file.Name()
+	offset := engine.writeLog.index["corrupt"].offset
+	require.NoError(t, engine.Close())
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte{value[MB] + 1}, offset+int64(recordHeaderSize(currentFormatVersion))+int64(len("corrupt"))+MB)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	reader, err = engine.GetReader("corrupt")
+	require.NoError(t, err)
+	defer reader.Close()
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+}
+
+func TestPutReader(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_put_reader")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxValueSize(8*MB))
+	require.NoError(t, err)
+
+	value := make([]byte, 6*MB)
+	rand.New(rand.NewSource(1)).Read(value)
+	require.NoError(t, engine.Put("small", "value"))
+	require.NoError(t, engine.PutReader("large", bytes.NewReader(value), int64(len(value))))
+
+	read, err := engine.GetBytes("large")
+	require.NoError(t, err)
+	assert.Equal(t, value, read)
+
+	// the size is checked before anything is written
+	var sizeErr *SizeError
+	assert.ErrorAs(t, engine.PutReader("huge", strings.NewReader(""), 8*MB+1), &sizeErr)
+	assert.Error(t, engine.PutReader("negative", strings.NewReader(""), -1))
+
+	// a reader which ends early leaves nothing behind
+	size := engine.writeLog.size
+	err = engine.PutReader("short", strings.NewReader("abc"), 10)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, size, engine.writeLog.size)
+	stat, err := engine.writeLog.file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, size, stat.Size())
+	_, err = engine.Get("short")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, engine.Put("after", "value"))
+	require.NoError(t, engine.Close())
+	// a staging file left by a crash is removed when the data path is opened again
+	stalePath := filepath.Join(tempDir, "1"+stagedValueSuffix)
+	require.NoError(t, os.WriteFile(stalePath, []byte("partial"), 0o644))
+
+	// the streamed record passes its checksum when the data path is opened again
+	engine, err = NewEngine(tempDir, WithMaxValueSize(8*MB))
+	require.NoError(t, err)
+	defer engine.Close()
+	_, err = os.Stat(stalePath)
+	assert.True(t, os.IsNotExist(err), "Expected the stale staging file to be removed")
+	read, err = engine.GetBytes("large")
+	require.NoError(t, err)
+	assert.Equal(t, value, read)
+	for _, key := range []string{"small", "after"} {
+		got, err := engine.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, "value", got)
+	}
+	_, err = engine.Get("short")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestPutReaderDoesNotBlockOtherKeys(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_put_reader_does_not_block")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Put("other", "value"))
+
+	// the reader stalls until the other key is read
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- engine.PutReader("streamed", reader, 5)
+	}()
+
+	read := make(chan error, 1)
+	go func() {
+		if _, err := engine.Get("other"); err != nil {
+			read <- err
+			return
+		}
+		read <- engine.Put("another", "value")
+	}()
+	select {
+	case err := <-read:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the other keys to be read and written while PutReader waits for its reader")
+	}
+
+	_, err = writer.Write([]byte("value"))
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	value, err := engine.Get("streamed")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// the staging file is removed once the value is appended
+	staged, err := filepath.Glob(filepath.Join(tempDir, "*"+stagedValueSuffix))
+	require.NoError(t, err)
+	assert.Empty(t, staged)
+}
+
+func TestCrashBeforeStreamedChecksumIsWritten(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_streamed_record_crash")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Put("key", "value"))
+	validSize := engine.writeLog.size
+	value := strings.Repeat("streamed", 100)
+	require.NoError(t, engine.PutReader("streamed", strings.NewReader(value), int64(len(value))))
+	dataFilePath := engine.writeLog.file.Name()
+	crashEngine(t, engine)
+
+	// the process stops after the value is written and before the checksum of its header is filled in
+	file, err := os.OpenFile(dataFilePath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteAt(make([]byte, 4), validSize)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err, "Expected the unfinished streamed record to be truncated")
+	defer engine.Close()
+	stat, err := os.Stat(dataFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, validSize, stat.Size())
+
+	got, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+	_, err = engine.Get("streamed")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+)
+
+// tagIndex keeps the keys of every tag in memory, so the keys with a tag are found without going
+// through all the keys. The tags of a key are the tags of its latest record.
+type tagIndex struct {
+	// keys represents the keys of every tag
+	keys map[string]map[string]struct{}
+	// tags represents the tags of every tagged key, so a key is removed from its old tags when it changes
+	tags map[string][]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{keys: make(map[string]map[string]struct{}), tags: make(map[string][]string)}
+}
+
+// set replaces the tags of the key, no tags remove the key from the index
+func (t *tagIndex) set(key string, tags []string) {
+	for _, tag := range t.tags[key] {
+		delete(t.keys[tag], key)
+		if len(t.keys[tag]) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.tags, key)
+	if len(tags) == 0 {
+		return
+	}
+
+	t.tags[key] = tags
+	for _, tag := range tags {
+		if t.keys[tag] == nil {
+			t.keys[tag] = make(map[string]struct{})
+		}
+		t.keys[tag][key] = struct{}{}
+	}
+}
+
+// setLogTags records the tags of the latest record of the key in the tags of a log, which are
+// allocated with the first tagged record, and returns the tags of the log
+func setLogTags(logTags map[string][]string, key string, tags []string) map[string][]string {
+	if len(tags) == 0 {
+		delete(logTags, key)
+		return logTags
+	}
+	if logTags == nil {
+		logTags = make(map[string][]string)
+	}
+	logTags[key] = tags
+	return logTags
+}
+
+// buildTagIndex builds the tag index from the tags of the logs, the caller must hold the write lock
+func (e *Engine) buildTagIndex() {
+	e.tags = newTagIndex()
+	visited := make(map[string]struct{})
+	visitLog := func(index map[string]indexEntry, tags map[string][]string) {
+		for key := range index {
+			// the logs are visited from the newest to the oldest so the first visit is the latest record
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			e.tags.set(key, tags[key])
+		}
+	}
+
+	visitLog(e.writeLog.index, e.writeLog.tags)
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		visitLog(e.readLogs[i].index, e.readLogs[i].tags)
+	}
+}
+
+// PutWithTags sets a key-value pair like Put and tags the key with the given tags, so it's returned by
+// KeysByTag for each of them. The tags are stored with the record and replace the earlier tags of the
+// key, a later Put without tags or a Delete removes the key from all its tags.
+func (e *Engine) PutWithTags(key, value string, tags ...string) error {
+	tags, err := e.validateTags(tags)
+	if err != nil {
+		return err
+	}
+	return e.putRecord(record{key: key, value: value, tags: tags})
+}
+
+// validateTags returns the tags sorted and without duplicates as they are stored, or an error if one
+// of them is empty or too large
+func (e *Engine) validateTags(tags []string) ([]string, error) {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, fmt.Errorf("tag cannot be empty")
+		}
+		if err := checkSize("tag", int64(len(tag)), e.maxKeyBytes); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// KeysByTag returns the live keys tagged with the tag in ascending order. It only looks up the
+// in-memory tag index, so the values are never read from the disk.
+func (e *Engine) KeysByTag(tag string) ([]string, error) {
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	keys := make([]string, 0, len(e.tags.keys[tag]))
+	for key := range e.tags.keys[tag] {
+		// the expired keys stay in the index until they are written again
+		if entry, ok := e.latestEntry(key); ok && entry.live(now) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_tags")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	assertKeys := func(engine *Engine, tag string, expected ...string) {
+		keys, err := engine.KeysByTag(tag)
+		require.NoError(t, err)
+		if expected == nil {
+			expected = []string{}
+		}
+		assert.Equal(t, expected, keys, tag)
+	}
+
+	require.NoError(t, engine.PutWithTags("first", "1", "active"))
+	require.NoError(t, engine.PutWithTags("second", "2", "active", "admin", "active"))
+	require.NoError(t, engine.PutWithTags("third", "3", "active"))
+	require.NoError(t, engine.PutWithTags("fourth", "4", "admin"))
+	assertKeys(engine, "active", "first", "second", "third")
+	assertKeys(engine, "admin", "fourth", "second")
+	assertKeys(engine, "missing")
+	assert.Error(t, engine.PutWithTags("key", "value", ""))
+
+	value, err := engine.Get("second")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	// the tags of a key are replaced by its latest write and removed by a delete
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.PutWithTags("first", "1", "inactive"))
+	require.NoError(t, engine.Put("third", "3"))
+	require.NoError(t, engine.Delete("fourth"))
+	assertKeys(engine, "active", "second")
+	assertKeys(engine, "admin", "second")
+	assertKeys(engine, "inactive", "first")
+	require.NoError(t, engine.Close())
+
+	// the tags are loaded from the hint files and from the data files
+	for _, removeHints := range []bool{false, true} {
+		if removeHints {
+			hints, err := filepath.Glob(filepath.Join(tempDir, "*"+hintFileFormatSuffix))
+			require.NoError(t, err)
+			require.NotEmpty(t, hints)
+			for _, hint := range hints {
+				require.NoError(t, os.Remove(hint))
+			}
+		}
+		engine, err = NewEngine(tempDir)
+		require.NoError(t, err)
+		assertKeys(engine, "active", "second")
+		assertKeys(engine, "admin", "second")
+		assertKeys(engine, "inactive", "first")
+		require.NoError(t, engine.Close())
+	}
+
+	// the tags are kept by the compaction
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+	engine, err = NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+	assertKeys(engine, "active", "second")
+	assertKeys(engine, "inactive", "first")
+	require.NoError(t, engine.Delete("second"))
+	assertKeys(engine, "active")
+	assertKeys(engine, "admin")
+}
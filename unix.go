@@ -1,24 +1,53 @@
+//go:build unix
+
 package storage
 
 import (
+	"fmt"
 	"golang.org/x/sys/unix"
 	"os"
 )
 
-const (
-	lockFileName = ".lock"
-)
+// errWouldBlock is returned by the non-blocking lock when the file is locked by someone else
+var errWouldBlock = unix.EWOULDBLOCK
+
+func lockFileExclusive(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+}
 
-func createFlock(path string) (*os.File, error) {
-	lockFile, err := os.OpenFile(path+lockFileName, os.O_CREATE|os.O_RDONLY, 0o644)
+func lockFileShared(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+}
+
+func unlockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
+
+// syncDirectory syncs the directory entries, e.g. the names of the files created in it
+func syncDirectory(name string) error {
+	dir, err := os.Open(name)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
 	}
+	return dir.Close()
+}
 
-	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+// mmapFile maps the whole file into the memory as read-only
+func mmapFile(file *os.File) ([]byte, error) {
+	stat, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
+	if stat.Size() == 0 {
+		return nil, fmt.Errorf("can't map an empty file")
+	}
+	return unix.Mmap(int(file.Fd()), 0, int(stat.Size()), unix.PROT_READ, unix.MAP_SHARED)
+}
 
-	return lockFile, nil
+func munmapFile(data []byte) error {
+	return unix.Munmap(data)
 }
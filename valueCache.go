@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+)
+
+// valueCache keeps the values of the recently read keys in memory up to a max number of bytes, so
+// reading a hot key doesn't hit the disk. When the cache is full the least recently used values are
+// evicted. The cached value of a key is removed whenever the key is written, so the cache never
+// returns a value which is not the latest one. A nil cache caches nothing.
+type valueCache struct {
+	lock     sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	// order keeps the cached values from the most recently used (front) to the least recently used (back)
+	order *list.List
+	// hits and misses count the reads of the live keys which are and aren't found in the cache
+	hits   uint64
+	misses uint64
+}
+
+type cachedValue struct {
+	key   string
+	value string
+}
+
+func newValueCache(maxBytes int64) *valueCache {
+	return &valueCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached value of the key
+func (c *valueCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedValue).value, true
+}
+
+// add caches the value of the key, evicting the least recently used values if the cache is full.
+// values which are larger than the cache itself are not cached.
+func (c *valueCache) add(key, value string) {
+	if c == nil || int64(len(key)+len(value)) > c.maxBytes {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+	c.entries[key] = c.order.PushFront(&cachedValue{key: key, value: value})
+	c.size += int64(len(key) + len(value))
+	for c.size > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove removes the cached value of the key, it's called whenever the key is written
+func (c *valueCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// clear removes all the cached values
+func (c *valueCache) clear() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+// counters returns the number of cache hits and misses
+func (c *valueCache) counters() (uint64, uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *valueCache) removeElement(element *list.Element) {
+	cv := element.Value.(*cachedValue)
+	c.order.Remove(element)
+	delete(c.entries, cv.key)
+	c.size -= int64(len(cv.key) + len(cv.value))
+}
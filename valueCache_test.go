@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueCacheHit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_value_cache_hit")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithValueCache(1*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key", "value"))
+	for i := 0; i < 3; i++ {
+		value, err := engine.Get("key")
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+
+	stats := engine.Stats()
+	assert.Equal(t, uint64(2), stats.CacheHits)
+	assert.Equal(t, uint64(1), stats.CacheMisses)
+
+	// a missing key is neither a hit nor a miss
+	_, err = engine.Get("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, uint64(1), engine.Stats().CacheMisses)
+}
+
+func TestValueCacheInvalidation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_value_cache_invalidation")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithValueCache(1*KB))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key", "value"))
+	_, err = engine.Get("key")
+	require.NoError(t, err)
+
+	require.NoError(t, engine.Put("key", "new value"))
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "new value", value)
+
+	batch := engine.NewBatch()
+	batch.Delete("key")
+	require.NoError(t, batch.Commit())
+	_, err = engine.Get("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// the compaction moves the values to new logs, the cache is read again from them
+	require.NoError(t, engine.Put("other", "value"))
+	_, err = engine.Get("other")
+	require.NoError(t, err)
+	require.NoError(t, engine.rotateWriteLog())
+	require.NoError(t, engine.Compact())
+	assert.Empty(t, engine.valueCache.entries)
+	value, err = engine.Get("other")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestValueCacheEviction(t *testing.T) {
+	cache := newValueCache(30)
+	for i := 0; i < 5; i++ {
+		// every value takes 10 bytes with its key
+		cache.add(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	assert.Equal(t, int64(30), cache.size)
+
+	// the least recently used values are evicted first
+	_, ok := cache.get("key2")
+	assert.True(t, ok)
+	cache.add("key5", "value5")
+	for _, key := range []string{"key0", "key1", "key3"} {
+		_, ok := cache.get(key)
+		assert.False(t, ok, "Expected %s to be evicted", key)
+	}
+	for _, key := range []string{"key2", "key4", "key5"} {
+		_, ok := cache.get(key)
+		assert.True(t, ok, "Expected %s to be cached", key)
+	}
+
+	// a value larger than the cache is never cached
+	cache.add("large", string(make([]byte, 100)))
+	_, ok = cache.get("large")
+	assert.False(t, ok)
+	assert.LessOrEqual(t, cache.size, int64(30))
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// VerifyReport represents the result of checking the data files of the storage engine
+type VerifyReport struct {
+	Files []FileReport
+}
+
+// FileReport represents the result of checking a single data file
+type FileReport struct {
+	// Path represents the path of the data file
+	Path string
+	// Records represents the number of records found in the data file
+	Records int
+	// Errors represents the number of problems found in the data file, e.g. a record which doesn't
+	// match its checksum, a record cut off by the end of the file or an index entry which doesn't
+	// point to the beginning of a record
+	Errors int
+	// FirstBadOffset represents the offset of the first problem in the data file, it's -1 if the
+	// data file doesn't have any
+	FirstBadOffset int64
+}
+
+// OK reports whether no problem is found in any of the data files
+func (r VerifyReport) OK() bool {
+	for _, file := range r.Files {
+		if file.Errors > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks every record of the data files of the storage engine against its length and its
+// checksum, and checks that every index entry points to the beginning of a record. It doesn't change
+// anything, problems are only reported. The read lock is held while the files are checked, so writes
+// are blocked until it finishes. The returned error is only set if a data file can't be read.
+func (e *Engine) Verify() (VerifyReport, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return VerifyReport{}, ErrEngineClosed
+	}
+
+	var report VerifyReport
+	for _, log := range e.readLogs {
+		fileReport, err := verifyDataFile(e.fs, log.path, log.index)
+		if err != nil {
+			return VerifyReport{}, err
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+	// the write log of a read-only engine doesn't have a file
+	if e.writeLog.file != nil {
+		if err := e.writeLog.flush(); err != nil {
+			return VerifyReport{}, err
+		}
+		fileReport, err := verifyDataFile(e.fs, e.writeLog.file.Name(), e.writeLog.index)
+		if err != nil {
+			return VerifyReport{}, err
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+
+	return report, nil
+}
+
+// verifyDataFile checks the records of the data file in path and the index entries pointing to them
+func verifyDataFile(fsys FS, path string, index map[string]indexEntry) (FileReport, error) {
+	report := FileReport{Path: path, FirstBadOffset: -1}
+	fail := func(offset int64) {
+		report.Errors++
+		if report.FirstBadOffset == -1 || offset < report.FirstBadOffset {
+			report.FirstBadOffset = offset
+		}
+	}
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		return FileReport{}, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return FileReport{}, err
+	}
+	size := stat.Size()
+	if size == 0 {
+		return report, nil
+	}
+
+	version, err := readFileHeader(file)
+	if err != nil {
+		fail(0)
+		return report, nil
+	}
+	offset := int64(fileHeaderSize)
+	if version == formatVersionLegacy {
+		offset = 0
+	}
+
+	// the offsets the index entries can point to, which are the value sizes for legacy files
+	boundaries := make(map[int64]struct{})
+	for offset < size {
+		var recordSize, indexOffset int64
+		if version == formatVersionLegacy {
+			recordSize, indexOffset, err = legacyRecordSize(file, offset, size)
+		} else {
+			recordSize, err = recordSizeAt(file, offset, size, version)
+			indexOffset = offset
+		}
+		if err != nil {
+			// the length of the record can't be trusted, so the following records can't be found
+			fail(offset)
+			break
+		}
+
+		if version != formatVersionLegacy {
+			_, _, err := readRecord(io.NewSectionReader(file, offset, recordSize), version, recordSize)
+			if errors.Is(err, ErrCorruptRecord) {
+				fail(offset)
+			} else if err != nil {
+				return FileReport{}, err
+			}
+		}
+		boundaries[indexOffset] = struct{}{}
+		report.Records++
+		offset += recordSize
+	}
+
+	for _, entry := range index {
+		if _, ok := boundaries[entry.offset]; !ok {
+			fail(entry.offset)
+		}
+	}
+
+	return report, nil
+}
+
+// recordSizeAt returns the size of the record at the given offset of a file with the given format
+// version, it returns io.ErrUnexpectedEOF if the record doesn't fit in the file
+func recordSizeAt(file io.ReaderAt, offset, fileSize int64, version int) (int64, error) {
+	header := make([]byte, recordHeaderSize(version))
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	size := int64(len(header)) + int64(binary.LittleEndian.Uint32(header[4:])) + int64(binary.LittleEndian.Uint32(header[8:]))
+	if version >= formatVersionTags {
+		size += int64(binary.LittleEndian.Uint32(header[21:]))
+	}
+	if offset+size > fileSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return size, nil
+}
+
+// legacyRecordSize returns the size of the legacy record at the given offset and the offset of its
+// value size, it returns io.ErrUnexpectedEOF if the record doesn't fit in the file
+func legacyRecordSize(file io.ReaderAt, offset, fileSize int64) (int64, int64, error) {
+	size := make([]byte, 4)
+	if _, err := file.ReadAt(size, offset); err != nil {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	valueOffset := offset + 4 + int64(binary.LittleEndian.Uint32(size))
+	if _, err := file.ReadAt(size, valueOffset); err != nil {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	end := valueOffset + 4 + int64(binary.LittleEndian.Uint32(size))
+	if end > fileSize {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return end - offset, valueOffset, nil
+}
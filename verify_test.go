@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("first", "value"))
+	require.NoError(t, engine.Put("second", "value"))
+	require.NoError(t, engine.Put("third", "value"))
+	require.NoError(t, engine.rotateWriteLog())
+	require.NoError(t, engine.Put("fourth", "value"))
+
+	report, err := engine.Verify()
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	require.Len(t, report.Files, 2)
+	assert.Equal(t, FileReport{Path: engine.readLogs[0].path, Records: 3, FirstBadOffset: -1}, report.Files[0])
+	assert.Equal(t, 1, report.Files[1].Records)
+
+	// flip the last byte of the value of the second record
+	corrupted := engine.readLogs[0]
+	entry := corrupted.index["second"]
+	data, err := os.ReadFile(corrupted.path)
+	require.NoError(t, err)
+	data[entry.offset+entry.size-1] ^= 0xff
+	require.NoError(t, os.WriteFile(corrupted.path, data, 0o644))
+
+	report, err = engine.Verify()
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, FileReport{Path: corrupted.path, Records: 3, Errors: 1, FirstBadOffset: entry.offset}, report.Files[0])
+	assert.Zero(t, report.Files[1].Errors)
+
+	// a record cut off by the end of the file hides the records after it
+	require.NoError(t, os.Truncate(corrupted.path, entry.offset+entry.size-1))
+	report, err = engine.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Files[0].Records)
+	assert.Equal(t, entry.offset, report.Files[0].FirstBadOffset)
+	// the record itself and the index entries of the second and the third records
+	assert.Equal(t, 3, report.Files[0].Errors)
+}
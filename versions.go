@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// versionLocation represents the position of a record in the read logs of a compaction
+type versionLocation struct {
+	// log represents the position of the log of the record in the snapshot of the compaction
+	log    int
+	offset int64
+}
+
+// GetVersions returns up to n of the most recent values of the key from the newest to the oldest, which
+// are kept by the compaction with WithVersionRetention. The history of a key starts after its latest
+// delete and the expired values are skipped, a key without any value returns ErrKeyNotFound. Unlike Get
+// it goes through all the records of the logs which have the key, so it's meant for occasional reads.
+func (e *Engine) GetVersions(key string, n int) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of versions")
+	}
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return nil, err
+	}
+	now := e.clock.Now()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	logs, err := e.logsWithKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, log := range logs {
+		records, err := e.recordsOfKey(log, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(records) - 1; i >= 0; i-- {
+			rec := records[i]
+			if rec.tombstone() {
+				return versionsOrNotFound(key, values)
+			}
+			if (indexEntry{expiry: rec.expiry}).expired(now) {
+				continue
+			}
+			value, err := e.decodeValue(rec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if len(values) == n {
+				return values, nil
+			}
+		}
+	}
+	return versionsOrNotFound(key, values)
+}
+
+// History returns the values of all the records of the key which are still in the data files from the
+// newest to the oldest, including the expired values, and the tombstone value set with WithTombStone
+// for every delete. It's a forensic tool to see how a key changed, the compaction removes the records
+// which are not needed anymore, so the history only goes back to the last compaction of the logs of
+// the key. A key without any record returns ErrKeyNotFound. It goes through all the records of the
+// logs which have the key like GetVersions, so it's meant for occasional reads.
+func (e *Engine) History(key string) ([]string, error) {
+	key = e.normalizeKey(key)
+	if err := e.validateKey(key); err != nil {
+		return nil, err
+	}
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+
+	logs, err := e.logsWithKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, log := range logs {
+		records, err := e.recordsOfKey(log, key)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(records) - 1; i >= 0; i-- {
+			rec := records[i]
+			if rec.tombstone() {
+				values = append(values, e.tombStone)
+				continue
+			}
+			value, err := e.decodeValue(rec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+	}
+	return versionsOrNotFound(key, values)
+}
+
+// logFile represents a data file of the engine with the format version it's written in
+type logFile struct {
+	path    string
+	version int
+}
+
+// logsWithKey returns the logs which have a record of the key from the newest to the oldest, the write
+// log is flushed if it has one. The caller must hold the lock.
+func (e *Engine) logsWithKey(key string) ([]logFile, error) {
+	var logs []logFile
+	if _, ok := e.writeLog.index[key]; ok {
+		// the records of the key may still be in the buffer of the write log
+		if err := e.writeLog.flush(); err != nil {
+			return nil, err
+		}
+		logs = append(logs, logFile{path: e.writeLog.file.Name(), version: currentFormatVersion})
+	}
+	for i := len(e.readLogs) - 1; i >= 0; i-- {
+		if _, ok := e.lookupReadLog(e.readLogs[i], key); ok {
+			logs = append(logs, logFile{path: e.readLogs[i].path, version: e.readLogs[i].version})
+		}
+	}
+	return logs, nil
+}
+
+// recordsOfKey returns the records of the key in the log from the oldest to the newest, the deletes
+// are marked with the tombstone flag whatever the format of the log is
+func (e *Engine) recordsOfKey(log logFile, key string) ([]record, error) {
+	var records []record
+	err := e.scanLog(log.path, func(rec record, offset int64, deleted bool) error {
+		if rec.key == key {
+			if deleted {
+				rec.flags |= flagTombstone
+			}
+			records = append(records, rec)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// versionsOrNotFound returns the values, or ErrKeyNotFound if the key doesn't have any
+func versionsOrNotFound(key string, values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return values, nil
+}
+
+// scanLog calls fn for every record of the data file in path from the oldest to the newest, with the
+// offset the index keeps for it and whether it deletes its key. A partial record at the end of the
+// file is ignored like when the data file is loaded.
+func (e *Engine) scanLog(path string, fn func(rec record, offset int64, deleted bool) error) error {
+	file, err := e.fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	version, err := readFileHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	err = scanRecords(file, version, func(rec record, offset, size int64) error {
+		deleted := rec.tombstone()
+		if version < formatVersionTombstoneFlag {
+			deleted = rec.value == e.tombStone
+		}
+		return fn(rec, offset, deleted)
+	})
+	var partialErr *partialRecordError
+	if err != nil && !errors.As(err, &partialErr) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// retainedVersions returns the locations of the records a compaction with version retention keeps,
+// which are the most recent live records of each key after its latest delete. A key whose latest
+// record is deleted or expired doesn't keep any record.
+func (e *Engine) retainedVersions(ctx context.Context, logs []*readLog, newest map[string]int,
+	now time.Time) (map[versionLocation]struct{}, error) {
+	versions := make(map[string][]versionLocation)
+	for i, log := range logs {
+		err := e.scanLog(log.path, func(rec record, offset int64, deleted bool) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			switch {
+			case deleted:
+				delete(versions, rec.key)
+			case !(indexEntry{expiry: rec.expiry}).expired(now):
+				locations := append(versions[rec.key], versionLocation{log: i, offset: offset})
+				if len(locations) > e.compactionManager.versions {
+					locations = locations[1:]
+				}
+				versions[rec.key] = locations
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retained := make(map[versionLocation]struct{})
+	for key, locations := range versions {
+		if !logs[newest[key]].index[key].live(now) {
+			continue
+		}
+		for _, location := range locations {
+			retained[location] = struct{}{}
+		}
+	}
+	return retained, nil
+}
+
+// compactRetainedVersions puts the retained records of the log, which is at the given position of the
+// snapshot of the compaction, into the compaction engine in the order they are written
+func (e *Engine) compactRetainedVersions(ctx context.Context, cEngine *Engine, log *readLog, position int,
+	retained map[versionLocation]struct{}, progress func()) error {
+	return e.scanLog(log.path, func(rec record, offset int64, deleted bool) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the progress is reported for the records of the index to match its total
+		if log.index[rec.key].offset == offset {
+			progress()
+		}
+		if _, ok := retained[versionLocation{log: position, offset: offset}]; !ok {
+			return nil
+		}
+
+		value, err := e.decodeValue(rec)
+		if err != nil {
+			return fmt.Errorf("failed to read value for key %s: %w", rec.key, err)
+		}
+		if err := cEngine.putRecord(record{key: rec.key, value: value, expiry: rec.expiry, tags: rec.tags}); err != nil {
+			return fmt.Errorf("failed to put key-value pair in compaction engine: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionRetention(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_version_retention")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	_, err = NewEngine(tempDir, WithVersionRetention(0))
+	assert.Error(t, err)
+
+	engine, err := NewEngine(tempDir, WithVersionRetention(3))
+	require.NoError(t, err)
+
+	// the versions are spread over several logs and some of them share a log
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, engine.Put("key", fmt.Sprintf("v%d", i)))
+		require.NoError(t, engine.Put("other", fmt.Sprintf("o%d", i)))
+		if i%2 == 0 {
+			require.NoError(t, engine.RotateLog())
+		}
+	}
+	require.NoError(t, engine.Put("deleted", "value"))
+	require.NoError(t, engine.Delete("deleted"))
+	require.NoError(t, engine.Put("restarted", "before"))
+	require.NoError(t, engine.Delete("restarted"))
+	require.NoError(t, engine.Put("restarted", "after"))
+	require.NoError(t, engine.RotateLog())
+
+	versions, err := engine.GetVersions("key", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v5", "v4", "v3", "v2", "v1"}, versions)
+	versions, err = engine.GetVersions("key", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v5", "v4"}, versions)
+
+	require.NoError(t, engine.Compact())
+	require.NoError(t, engine.Close())
+
+	engine, err = NewEngine(tempDir, WithVersionRetention(3))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	versions, err = engine.GetVersions("key", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v5", "v4", "v3"}, versions)
+	versions, err = engine.GetVersions("other", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"o5", "o4", "o3"}, versions)
+	value, err := engine.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "v5", value)
+
+	// the history of a key starts after its latest delete
+	versions, err = engine.GetVersions("restarted", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"after"}, versions)
+	_, err = engine.GetVersions("deleted", 10)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = engine.GetVersions("key", 0)
+	assert.Error(t, err)
+
+	// the write log is read too
+	require.NoError(t, engine.Put("key", "v6"))
+	versions, err = engine.GetVersions("key", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v6", "v5", "v4", "v3"}, versions)
+}
+
+func TestCompactionWithoutVersionRetention(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_compaction_without_version_retention")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Put("key", "v1"))
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Put("key", "v2"))
+	require.NoError(t, engine.RotateLog())
+
+	require.NoError(t, engine.Compact())
+	versions, err := engine.GetVersions("key", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v2"}, versions)
+}
+
+func TestHistory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_history")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir, WithMaxLogSize(128))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	_, err = engine.History("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// the versions are spread over many logs with other keys between them
+	for i := 0; i < 5; i++ {
+		require.NoError(t, engine.Put("key", fmt.Sprintf("value%d", i)))
+		require.NoError(t, engine.Put(fmt.Sprintf("other%d", i), "other"))
+	}
+	require.NoError(t, engine.Delete("key"))
+	require.NoError(t, engine.Put("key", "value5"))
+	require.NoError(t, engine.Delete("key"))
+	require.Greater(t, len(engine.readLogs), 2)
+
+	history, err := engine.History("key")
+	require.NoError(t, err)
+	assert.Equal(t, []string{defaultTombstone, "value5", defaultTombstone, "value4", "value3", "value2", "value1", "value0"}, history)
+
+	// the compaction drops the history of the deleted key
+	require.NoError(t, engine.RotateLog())
+	require.NoError(t, engine.Compact())
+	_, err = engine.History("key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	history, err = engine.History("other1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, history)
+}
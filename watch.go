@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"sync"
+)
+
+// watchBufferSize represents the number of events a watcher can fall behind before its events are dropped
+const watchBufferSize = 1024
+
+// Op represents the kind of change made to a key
+type Op int
+
+const (
+	// PutOp represents a key which is set to a new value
+	PutOp Op = iota + 1
+	// DeleteOp represents a key which is deleted
+	DeleteOp
+)
+
+// ChangeEvent represents a change made to a key
+type ChangeEvent struct {
+	Key string
+	Op  Op
+}
+
+// watchManager keeps the channels of the watchers which the changes are published to
+type watchManager struct {
+	lock     sync.Mutex
+	nextID   int
+	watchers map[int]chan ChangeEvent
+	// closed is set when the engine is closed, no new watchers are added afterwards
+	closed bool
+}
+
+// Watch returns a channel which receives an event for every key changed by a successful write,
+// in the order the writes are applied, and a cancel function which stops the watch and closes
+// the channel. The channel is also closed when the engine is closed.
+// The events are published without blocking the writes: the channel buffers up to 1024 events and
+// the events which don't fit in the buffer are dropped, so a watcher which needs every change must
+// keep up with the writes.
+func (e *Engine) Watch() (<-chan ChangeEvent, func()) {
+	m := e.watchManager
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := make(chan ChangeEvent, watchBufferSize)
+	if m.closed {
+		close(events)
+		return events, func() {}
+	}
+
+	id := m.nextID
+	m.nextID++
+	m.watchers[id] = events
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			if _, ok := m.watchers[id]; ok {
+				delete(m.watchers, id)
+				close(events)
+			}
+		})
+	}
+	return events, cancel
+}
+
+// publish sends the event to all the watchers, dropping it for the watchers whose buffer is full
+func (m *watchManager) publish(event ChangeEvent) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, events := range m.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// close closes the channels of all the watchers
+func (m *watchManager) close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.closed = true
+	for id, events := range m.watchers {
+		delete(m.watchers, id)
+		close(events)
+	}
+}
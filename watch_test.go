@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(tempDir)
+	require.NoError(t, err)
+
+	first, cancelFirst := engine.Watch()
+	second, cancelSecond := engine.Watch()
+	defer cancelSecond()
+
+	require.NoError(t, engine.Put("key", "value"))
+	require.NoError(t, engine.Delete("key"))
+
+	for _, events := range []<-chan ChangeEvent{first, second} {
+		assert.Equal(t, ChangeEvent{Key: "key", Op: PutOp}, <-events)
+		assert.Equal(t, ChangeEvent{Key: "key", Op: DeleteOp}, <-events)
+	}
+
+	// a cancelled watcher doesn't receive the later changes
+	cancelFirst()
+	cancelFirst()
+	require.NoError(t, engine.Put("other", "value"))
+	_, ok := <-first
+	assert.False(t, ok)
+	assert.Equal(t, ChangeEvent{Key: "other", Op: PutOp}, <-second)
+
+	// closing the engine closes the channels of the watchers
+	require.NoError(t, engine.Close())
+	_, ok = <-second
+	assert.False(t, ok)
+	closed, _ := engine.Watch()
+	_, ok = <-closed
+	assert.False(t, ok)
+}
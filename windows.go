@@ -0,0 +1,50 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// errWouldBlock is returned by the non-blocking lock when the file is locked by someone else
+var errWouldBlock = windows.ERROR_LOCK_VIOLATION
+
+// allBytes is used as the length of the locked range to lock the whole file
+const allBytes = ^uint32(0)
+
+func lockFileExclusive(file *os.File) error {
+	return windows.LockFileEx(
+		windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, allBytes, allBytes, new(windows.Overlapped),
+	)
+}
+
+func lockFileShared(file *os.File) error {
+	return windows.LockFileEx(
+		windows.Handle(file.Fd()),
+		windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, allBytes, allBytes, new(windows.Overlapped),
+	)
+}
+
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, allBytes, allBytes, new(windows.Overlapped))
+}
+
+// mmapFile is not supported on windows, the files are always read through the file handle
+func mmapFile(*os.File) ([]byte, error) {
+	return nil, errors.New("memory mapped reads are not supported on windows")
+}
+
+func munmapFile([]byte) error {
+	return nil
+}
+
+// syncDirectory does nothing on windows, where the directories can't be synced and the metadata of
+// the files is made durable with the files themselves
+func syncDirectory(string) error {
+	return nil
+}